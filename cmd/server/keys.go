@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/config"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/service/token"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const keysUsage = "usage: server keys [flags] generate [activate-in] | check"
+
+// defaultActivateIn gives every server time to be reloaded and to publish a generated key
+// before it signs, so that tokens signed with it are never rejected by the others.
+const defaultActivateIn = 24 * time.Hour
+
+// runKeys handles the keys subcommand and returns the exit code. check exits
+// with 1 if the signing key is due for rotation, so it can be run on schedule.
+func runKeys(args []string) int {
+	cfg, opts, exitCode, ok := loadConfig(args)
+	if !ok {
+		return exitCode
+	}
+	if cfg.Auth.KeysDir == "" {
+		fmt.Println("auth.keys_dir is empty")
+		return 2
+	}
+
+	switch command := opts.Args; {
+	case len(command) >= 1 && len(command) <= 2 && command[0] == "generate":
+		activateIn := defaultActivateIn
+		if len(command) == 2 {
+			d, err := time.ParseDuration(command[1])
+			if err != nil || d < 0 {
+				fmt.Println(keysUsage)
+				return 2
+			}
+			activateIn = d
+		}
+		// the first key has nothing to take over from
+		if existing, _ := token.LoadKeyDir(cfg.Auth.KeysDir); len(existing) == 0 {
+			activateIn = 0
+		}
+		k, err := token.GenerateKey(cfg.Auth.KeysDir, time.Now().Add(activateIn))
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if activateIn == 0 {
+			fmt.Printf("generated key %s, it signs tokens once the servers are reloaded\n", k.Id)
+			return 0
+		}
+		fmt.Printf("generated key %s, it signs tokens from %s, reload the servers before that\n",
+			k.Id, k.ActiveFrom.Format(time.RFC3339))
+		return 0
+	case len(command) == 1 && command[0] == "check":
+		keys, err := token.LoadKeyDir(cfg.Auth.KeysDir)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if printKeyStates(keys, cfg) {
+			return 1
+		}
+		return 0
+	default:
+		fmt.Println(keysUsage)
+		return 2
+	}
+}
+
+// loadKeys reads the signing keys from the keys directory or the single configured pair.
+func loadKeys(cfg config.Config) ([]token.Key, error) {
+	if cfg.Auth.KeysDir != "" {
+		return token.LoadKeyDir(cfg.Auth.KeysDir)
+	}
+	privateKeyBytes, err := ioutil.ReadFile(cfg.Auth.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	publicKeyBytes, err := ioutil.ReadFile(cfg.Auth.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	id := strings.TrimSuffix(filepath.Base(cfg.Auth.PrivateKey), filepath.Ext(cfg.Auth.PrivateKey))
+	k, err := token.LoadKeyPair(id, privateKeyBytes, publicKeyBytes)
+	if err != nil {
+		return nil, err
+	}
+	return []token.Key{k}, nil
+}
+
+// printKeyStates lists the keys and reports whether a new key is due, which
+// is only tracked for the keys directory.
+func printKeyStates(keys []token.Key, cfg config.Config) bool {
+	now := time.Now()
+	states := token.KeyStates(keys, now, cfg.Auth.TokenExpiration)
+	for _, s := range states {
+		switch s.State {
+		case token.KeyPending:
+			fmt.Printf("key %s: pending, signs from %s\n", s.Id, s.Until.Format(time.RFC3339))
+		case token.KeyActive:
+			fmt.Printf("key %s: active\n", s.Id)
+		case token.KeyVerifying:
+			fmt.Printf("key %s: verifying until %s\n", s.Id, s.Until.Format(time.RFC3339))
+		case token.KeyRetired:
+			fmt.Printf("key %s: retired, can be deleted\n", s.Id)
+		}
+	}
+	if cfg.Auth.KeysDir == "" || !token.RotationDue(states, now, cfg.Auth.KeyRotationInterval) {
+		return false
+	}
+	fmt.Printf("the active key is older than %v, run \"server keys generate\"\n", cfg.Auth.KeyRotationInterval)
+	return true
+}
@@ -1,64 +1,223 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"flag"
-	_ "github.com/lib/pq"
+	"fmt"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/config"
+	cachelinkrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/cache/linkrepo"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/httpapi"
-	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/postgres/accountrepo"
-	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/postgres/linkrepo"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/pipeline"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/service/idgen"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/service/token"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/account"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/click"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/link"
-	"io/ioutil"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
 func main() {
-	privateKeyPath := flag.String("privateKey", "app.rsa", "file path")
-	publicKeyPath := flag.String("publicKey", "app.rsa.pub", "file path")
-	flag.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		os.Exit(runKeys(os.Args[2:]))
+	}
+	os.Exit(run(os.Args[1:]))
+}
+
+// loadConfig returns the validated config, or false and the exit code if the program should stop.
+func loadConfig(args []string) (config.Config, config.Options, int, bool) {
+	cfg, opts, err := config.Load(os.Args[0], args)
+	if err == flag.ErrHelp {
+		return cfg, opts, 0, false
+	}
+	if err != nil {
+		fmt.Println(err)
+		return cfg, opts, 2, false
+	}
+	fmt.Printf("config:\n%v", cfg)
+	if err := cfg.Validate(); err != nil {
+		fmt.Println(err)
+		return cfg, opts, 2, false
+	}
+	if opts.CheckConfig {
+		return cfg, opts, 0, false
+	}
+	return cfg, opts, 0, true
+}
+
+// run starts the server and returns the exit code: 0 if it was stopped by a signal
+// and shut down cleanly, 1 otherwise, 2 if the config is invalid.
+func run(args []string) int {
+	cfg, _, exitCode, ok := loadConfig(args)
+	if !ok {
+		return exitCode
+	}
 
-	privateKeyBytes, err := ioutil.ReadFile(*privateKeyPath)
-	publicKeyBytes, err := ioutil.ReadFile(*publicKeyPath)
+	keys, err := loadKeys(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	printKeyStates(keys, cfg)
 
-	a, err := token.NewJwtHandler(privateKeyBytes, publicKeyBytes, 100*time.Minute)
+	store, err := openStorage(cfg)
 	if err != nil {
-		panic(err)
+		fmt.Println(err)
+		return 1
 	}
 
-	// todo: pass connection args through config
-	connStr := "user=postgres password=12345678 host=db dbname=postgres sslmode=disable"
-	conn, err := sql.Open("postgres", connStr)
+	a, err := token.NewJwtHandler(keys, cfg.Auth.TokenExpiration, store.refreshTokens)
 	if err != nil {
-		panic(err)
+		fmt.Println(err)
+		store.close()
+		return 1
+	}
+
+	linkStorage := store.links
+	if cfg.Links.CacheSize > 0 {
+		linkStorage = cachelinkrepo.New(linkStorage, cfg.Links.CacheSize, cfg.Links.CacheTtl)
 	}
-	//defer conn.Close()
 
 	accountUseCases := &account.AccountUseCases{
-		AccountStorage: accountrepo.New(conn),
-		Auth:           a,
+		AccountStorage:         store.accounts,
+		RefreshTokenStorage:    store.refreshTokens,
+		ApiKeyStorage:          store.apiKeys,
+		LinkStorage:            linkStorage,
+		LoginAttemptStorage:    store.loginAttempts,
+		Auth:                   a,
+		RefreshTokenExpiration: cfg.Auth.RefreshTokenExpiration,
+		LinksOnDelete:          account.LinksPolicy(cfg.Links.OnAccountDelete),
+	}
+
+	var generator link.LinkIdGenerator
+	switch cfg.Links.IdGenerator {
+	case "random":
+		generator, err = idgen.NewRandom(cfg.Links.IdAlphabet)
+	case "counter":
+		generator, err = idgen.NewCounter(cfg.Links.IdAlphabet, cfg.Links.IdSalt, store.sequences)
+	default:
+		err = fmt.Errorf("unknown id generator %q", cfg.Links.IdGenerator)
+	}
+	if err != nil {
+		fmt.Printf("links: %v\n", err)
+		store.close()
+		return 2
 	}
 
 	linkUseCases := &link.LinkUseCases{
-		LinkStorage: linkrepo.New(conn),
+		LinkStorage: linkStorage,
+		IdGenerator: generator,
+		IdLength:    cfg.Links.IdLength,
+	}
+
+	clickBuffer := pipeline.NewClickBuffer(store.clicks, cfg.Clicks.BufferSize, cfg.Clicks.BatchSize, cfg.Clicks.FlushInterval)
+
+	clickUseCases := &click.ClickUseCases{
+		ClickStorage: clickBuffer,
+		LinkStorage:  linkStorage,
+		IpSalt:       cfg.Clicks.IpSalt,
 	}
 
-	pipeline.LinkStatusUpdater(linkUseCases)
+	// ctx is cancelled on shutdown to stop background work
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	statusUpdaterDone := pipeline.LinkStatusUpdater(ctx, linkUseCases, cfg.StatusChecker.Workers, cfg.StatusChecker.Interval)
 
-	service := httpapi.NewApi(accountUseCases, linkUseCases)
+	service := httpapi.NewApi(accountUseCases, linkUseCases, clickUseCases)
+	service.ClientIpHeader = cfg.Server.ClientIpHeader
+	service.PublicUrl = cfg.Server.PublicUrl
 
 	server := http.Server{
-		Addr:         ":8080",
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		Addr:         cfg.Server.Addr,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
 
 		Handler: service.Router(),
 	}
-	err = server.ListenAndServe()
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	code := 0
+	for {
+		select {
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				reloadKeys(cfg, a)
+				continue
+			}
+			fmt.Printf("received %v, shutting down\n", s)
+		case err := <-serverErr:
+			fmt.Printf("server failed: %v\n", err)
+			code = 1
+		}
+		break
+	}
+	if err := shutdown(cfg.Server.ShutdownTimeout, &server, stop, statusUpdaterDone, clickBuffer, store.close); err != nil {
+		fmt.Printf("shutdown failed: %v\n", err)
+		code = 1
+	}
+	return code
+}
+
+// reloadKeys replaces the signing keys, the old ones stay in use if the new ones can't be read.
+func reloadKeys(cfg config.Config, a *token.JwtHandler) {
+	keys, err := loadKeys(cfg)
+	if err == nil {
+		err = a.SetKeys(keys)
+	}
 	if err != nil {
-		panic(err)
+		fmt.Printf("keys are not reloaded: %v\n", err)
+		return
+	}
+	fmt.Println("keys are reloaded")
+	printKeyStates(keys, cfg)
+}
+
+// shutdown stops accepting connections and waits for in-flight requests, then stops
+// background workers, writes out buffered clicks and closes the storage, all within timeout.
+func shutdown(timeout time.Duration, server *http.Server, stop context.CancelFunc, statusUpdaterDone <-chan struct{},
+	clickBuffer *pipeline.ClickBuffer, closeStorage func() error) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []string
+	if err := server.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Sprintf("http server: %v", err))
+	}
+
+	stop()
+	select {
+	case <-statusUpdaterDone:
+	case <-ctx.Done():
+		errs = append(errs, "link status updater: "+ctx.Err().Error())
+	}
+
+	// no more redirects can enqueue clicks, write out what is left
+	if err := clickBuffer.Close(ctx); err != nil {
+		errs = append(errs, fmt.Sprintf("click buffer: %v", err))
+	}
+
+	if err := closeStorage(); err != nil {
+		errs = append(errs, fmt.Sprintf("storage: %v", err))
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
 	}
+	return nil
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/migrate"
+	"strconv"
+)
+
+const migrateUsage = "usage: server migrate [flags] up | down [steps] | status"
+
+// runMigrate handles the migrate subcommand and returns the exit code.
+func runMigrate(args []string) int {
+	cfg, opts, exitCode, ok := loadConfig(args)
+	if !ok {
+		return exitCode
+	}
+	if cfg.Storage.Backend == "memory" {
+		fmt.Printf("storage backend %s has no schema to migrate\n", cfg.Storage.Backend)
+		return 2
+	}
+	if len(opts.Args) == 0 {
+		fmt.Println(migrateUsage)
+		return 2
+	}
+
+	conn, dialect, err := openDatabase(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer conn.Close()
+
+	m, err := migrate.New(conn, dialect)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	ctx := context.Background()
+
+	switch command := opts.Args[0]; {
+	case command == "up" && len(opts.Args) == 1:
+		err = migrateUp(conn, dialect)
+	case command == "down" && len(opts.Args) <= 2:
+		steps := 1
+		if len(opts.Args) == 2 {
+			if steps, err = strconv.Atoi(opts.Args[1]); err != nil {
+				fmt.Println(migrateUsage)
+				return 2
+			}
+		}
+		var reverted []migrate.Migration
+		reverted, err = m.Down(ctx, steps)
+		for _, mig := range reverted {
+			fmt.Printf("reverted %04d_%s\n", mig.Version, mig.Name)
+		}
+	case command == "status" && len(opts.Args) == 1:
+		var states []migrate.State
+		states, err = m.Status(ctx)
+		for _, s := range states {
+			status := "pending"
+			if s.AppliedAt != nil {
+				status = "applied at " + s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Printf("%04d_%s: %s\n", s.Version, s.Name, status)
+		}
+	default:
+		fmt.Println(migrateUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
+
+// migrateUp applies pending migrations of the dialect.
+func migrateUp(conn *sql.DB, dialect migrate.Dialect) error {
+	m, err := migrate.New(conn, dialect)
+	if err != nil {
+		return err
+	}
+	applied, err := m.Up(context.Background())
+	for _, mig := range applied {
+		fmt.Printf("applied %04d_%s\n", mig.Version, mig.Name)
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/config"
+	domainaccount "github.com/mp-hl-2021/lenkeforkortelse/internal/domain/account"
+	domainapikey "github.com/mp-hl-2021/lenkeforkortelse/internal/domain/apikey"
+	domainclick "github.com/mp-hl-2021/lenkeforkortelse/internal/domain/click"
+	domainlink "github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
+	domainloginattempt "github.com/mp-hl-2021/lenkeforkortelse/internal/domain/loginattempt"
+	domainrefreshtoken "github.com/mp-hl-2021/lenkeforkortelse/internal/domain/refreshtoken"
+	memoryaccountrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/accountrepo"
+	memoryapikeyrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/apikeyrepo"
+	memoryclickrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/clickrepo"
+	memorylinkrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/linkrepo"
+	memoryloginattemptrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/loginattemptrepo"
+	memoryrefreshtokenrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/refreshtokenrepo"
+	memorysequencerepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/sequencerepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/postgres/accountrepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/postgres/apikeyrepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/postgres/clickrepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/postgres/linkrepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/postgres/loginattemptrepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/postgres/refreshtokenrepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/postgres/sequencerepo"
+	sqliteaccountrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/accountrepo"
+	sqliteapikeyrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/apikeyrepo"
+	sqliteclickrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/clickrepo"
+	sqlitelinkrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/linkrepo"
+	sqliteloginattemptrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/loginattemptrepo"
+	sqliterefreshtokenrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/refreshtokenrepo"
+	sqlitesequencerepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/sequencerepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/migrate"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/service/idgen"
+	_ "modernc.org/sqlite"
+	"strings"
+)
+
+// storage holds the repositories of the configured backend.
+type storage struct {
+	accounts domainaccount.Interface
+	links    domainlink.Interface
+	clicks   domainclick.Interface
+
+	refreshTokens domainrefreshtoken.Interface
+	apiKeys       domainapikey.Interface
+	loginAttempts domainloginattempt.Interface
+	sequences     idgen.Sequence
+	// close releases connections of the backend.
+	close func() error
+}
+
+func openStorage(cfg config.Config) (storage, error) {
+	if cfg.Storage.Backend == "memory" {
+		links := memorylinkrepo.NewMemory()
+		return storage{
+			accounts: memoryaccountrepo.NewMemory(),
+			links:    links,
+			clicks:   memoryclickrepo.NewMemory(links),
+			close:    func() error { return nil },
+
+			refreshTokens: memoryrefreshtokenrepo.NewMemory(),
+			apiKeys:       memoryapikeyrepo.NewMemory(),
+			loginAttempts: memoryloginattemptrepo.NewMemory(),
+			sequences:     memorysequencerepo.NewMemory(),
+		}, nil
+	}
+
+	conn, dialect, err := openDatabase(cfg)
+	if err != nil {
+		return storage{}, err
+	}
+	if cfg.Database.MigrateOnStart {
+		if err := migrateUp(conn, dialect); err != nil {
+			conn.Close()
+			return storage{}, err
+		}
+	}
+	switch cfg.Storage.Backend {
+	case "sqlite":
+		return storage{
+			accounts: sqliteaccountrepo.New(conn),
+			links:    sqlitelinkrepo.New(conn),
+			clicks:   sqliteclickrepo.New(conn),
+			close:    conn.Close,
+
+			refreshTokens: sqliterefreshtokenrepo.New(conn),
+			apiKeys:       sqliteapikeyrepo.New(conn),
+			loginAttempts: sqliteloginattemptrepo.New(conn),
+			sequences:     sqlitesequencerepo.New(conn),
+		}, nil
+	default:
+		return storage{
+			accounts: accountrepo.New(conn),
+			links:    linkrepo.New(conn),
+			clicks:   clickrepo.New(conn),
+			close:    conn.Close,
+
+			refreshTokens: refreshtokenrepo.New(conn),
+			apiKeys:       apikeyrepo.New(conn),
+			loginAttempts: loginattemptrepo.New(conn),
+			sequences:     sequencerepo.New(conn),
+		}, nil
+	}
+}
+
+// sqlitePragmas are set on every sqlite connection: foreign keys are off by default in sqlite,
+// the write-ahead log keeps readers of other processes from blocking writes, and the busy
+// timeout makes them wait for the database lock instead of failing.
+var sqlitePragmas = []string{"foreign_keys(1)", "journal_mode(WAL)", "busy_timeout(5000)"}
+
+// openDatabase connects to the sql database of the backend and returns its migrations dialect.
+func openDatabase(cfg config.Config) (*sql.DB, migrate.Dialect, error) {
+	switch cfg.Storage.Backend {
+	case "postgres":
+		conn, err := sql.Open("postgres", cfg.Database.Dsn)
+		if err != nil {
+			return nil, migrate.Dialect{}, err
+		}
+		conn.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+		conn.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+		return conn, migrate.Postgres, nil
+	case "sqlite":
+		dsn := cfg.Database.Dsn
+		for i, pragma := range sqlitePragmas {
+			sep := "&"
+			if i == 0 && !strings.Contains(dsn, "?") {
+				sep = "?"
+			}
+			dsn += sep + "_pragma=" + pragma
+		}
+		conn, err := sql.Open("sqlite", dsn)
+		if err != nil {
+			return nil, migrate.Dialect{}, err
+		}
+		// sqlite has a single writer, one connection queues writes instead of failing them
+		// with SQLITE_BUSY, and keeps an in-memory database alive
+		conn.SetMaxOpenConns(1)
+		conn.SetMaxIdleConns(1)
+		return conn, migrate.Sqlite, nil
+	default:
+		return nil, migrate.Dialect{}, fmt.Errorf("storage backend %s has no sql database", cfg.Storage.Backend)
+	}
+}
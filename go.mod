@@ -8,5 +8,9 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/lib/pq v1.10.0
 	github.com/prometheus/client_golang v1.10.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+	golang.org/x/net v0.0.0-20210316092652-d523dce5a7f4
+	gopkg.in/yaml.v2 v2.4.0
+	modernc.org/sqlite v1.17.3
 )
@@ -0,0 +1,325 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/service/idgen"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// envPrefix starts the names of environment variables overriding config values,
+// e.g. LENKE_SERVER_ADDR overrides server.addr.
+const envPrefix = "LENKE_"
+
+// redacted replaces secrets in the printed config, the same way url.URL.Redacted does.
+const redacted = "xxxxx"
+
+type Config struct {
+	Server        Server        `yaml:"server"`
+	Storage       Storage       `yaml:"storage"`
+	Database      Database      `yaml:"database"`
+	Auth          Auth          `yaml:"auth"`
+	Links         Links         `yaml:"links"`
+	Clicks        Clicks        `yaml:"clicks"`
+	StatusChecker StatusChecker `yaml:"status_checker"`
+}
+
+type Server struct {
+	Addr            string        `yaml:"addr"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// ClientIpHeader is set by a trusted reverse proxy to the client address, like X-Forwarded-For,
+	// the peer address is used if it's empty.
+	ClientIpHeader string `yaml:"client_ip_header"`
+	// PublicUrl is the scheme and host clients reach the server at, short urls and qr codes are built with it.
+	PublicUrl string `yaml:"public_url"`
+}
+
+type Storage struct {
+	// Backend is postgres, sqlite or memory, the latter keeps everything in the process and loses it on exit.
+	Backend string `yaml:"backend"`
+}
+
+type Database struct {
+	// Dsn is a postgres connection string or a path to the sqlite database file.
+	Dsn          string `yaml:"dsn"`
+	MaxOpenConns int    `yaml:"max_open_conns"`
+	MaxIdleConns int    `yaml:"max_idle_conns"`
+	// MigrateOnStart applies pending schema migrations before the server starts.
+	MigrateOnStart bool `yaml:"migrate_on_start"`
+}
+
+type Auth struct {
+	// KeysDir holds the signing keys as <kid>.pem files, PrivateKey and PublicKey are used if it's empty.
+	KeysDir string `yaml:"keys_dir"`
+	// KeyRotationInterval is how long a key signs before a new one is due.
+	KeyRotationInterval time.Duration `yaml:"key_rotation_interval"`
+	PrivateKey          string        `yaml:"private_key"`
+	PublicKey           string        `yaml:"public_key"`
+	TokenExpiration     time.Duration `yaml:"token_expiration"`
+	// RefreshTokenExpiration limits how long a session lasts without signing in again.
+	RefreshTokenExpiration time.Duration `yaml:"refresh_token_expiration"`
+}
+
+type Links struct {
+	IdGenerator string `yaml:"id_generator"`
+	IdAlphabet  string `yaml:"id_alphabet"`
+	IdLength    int    `yaml:"id_length"`
+	IdSalt      string `yaml:"id_salt"`
+	// CacheSize is the number of links kept in memory for redirects, 0 disables the cache.
+	CacheSize int           `yaml:"cache_size"`
+	CacheTtl  time.Duration `yaml:"cache_ttl"`
+	// OnAccountDelete is cascade to delete links of a deleted account or anonymize to keep them.
+	OnAccountDelete string `yaml:"on_account_delete"`
+}
+
+type Clicks struct {
+	IpSalt        string        `yaml:"ip_salt"`
+	BufferSize    int           `yaml:"buffer_size"`
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+type StatusChecker struct {
+	Workers  int           `yaml:"workers"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+func Default() Config {
+	return Config{
+		Server: Server{
+			Addr:            ":8080",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+		},
+		Storage: Storage{
+			Backend: "postgres",
+		},
+		Database: Database{
+			MaxOpenConns:   20,
+			MaxIdleConns:   5,
+			MigrateOnStart: true,
+		},
+		Auth: Auth{
+			KeyRotationInterval: 90 * 24 * time.Hour,
+
+			PrivateKey:      "app.rsa",
+			PublicKey:       "app.rsa.pub",
+			TokenExpiration: 100 * time.Minute,
+
+			RefreshTokenExpiration: 30 * 24 * time.Hour,
+		},
+		Links: Links{
+			IdGenerator: "random",
+			IdAlphabet:  idgen.Base62,
+			IdLength:    6,
+			CacheSize:   10000,
+			CacheTtl:    time.Minute,
+
+			OnAccountDelete: "cascade",
+		},
+		Clicks: Clicks{
+			BufferSize:    10000,
+			BatchSize:     500,
+			FlushInterval: time.Second,
+		},
+		StatusChecker: StatusChecker{
+			Workers:  4,
+			Interval: 5 * time.Second,
+		},
+	}
+}
+
+// bind registers a flag for every config value, flag names are the yaml paths
+// joined with dashes, e.g. server-addr for server.addr.
+func bind(fs *flag.FlagSet, c *Config) {
+	fs.StringVar(&c.Server.Addr, "server-addr", c.Server.Addr, "address to listen on")
+	fs.DurationVar(&c.Server.ReadTimeout, "server-read-timeout", c.Server.ReadTimeout, "max time to read a request")
+	fs.DurationVar(&c.Server.WriteTimeout, "server-write-timeout", c.Server.WriteTimeout, "max time to write a response")
+	fs.DurationVar(&c.Server.ShutdownTimeout, "server-shutdown-timeout", c.Server.ShutdownTimeout, "max time to finish requests and background work on shutdown")
+	fs.StringVar(&c.Server.ClientIpHeader, "server-client-ip-header", c.Server.ClientIpHeader, "header with the client address set by a trusted reverse proxy")
+	fs.StringVar(&c.Server.PublicUrl, "server-public-url", c.Server.PublicUrl, "scheme and host of short urls, like https://lenke.example, the request host is used if empty")
+
+	fs.StringVar(&c.Storage.Backend, "storage-backend", c.Storage.Backend, "where data is kept: postgres, sqlite or memory")
+
+	fs.StringVar(&c.Database.Dsn, "database-dsn", c.Database.Dsn, "postgres connection string or sqlite database file")
+	fs.IntVar(&c.Database.MaxOpenConns, "database-max-open-conns", c.Database.MaxOpenConns, "max number of open database connections")
+	fs.IntVar(&c.Database.MaxIdleConns, "database-max-idle-conns", c.Database.MaxIdleConns, "max number of idle database connections")
+	fs.BoolVar(&c.Database.MigrateOnStart, "database-migrate-on-start", c.Database.MigrateOnStart, "apply pending schema migrations on start")
+
+	fs.StringVar(&c.Auth.KeysDir, "auth-keys-dir", c.Auth.KeysDir, "directory of rsa private keys named <kid>.pem, reloaded on SIGHUP")
+	fs.DurationVar(&c.Auth.KeyRotationInterval, "auth-key-rotation-interval", c.Auth.KeyRotationInterval, "age of the signing key after which a new one is due")
+	fs.StringVar(&c.Auth.PrivateKey, "auth-private-key", c.Auth.PrivateKey, "path to the rsa private key signing tokens")
+	fs.StringVar(&c.Auth.PublicKey, "auth-public-key", c.Auth.PublicKey, "path to the rsa public key verifying tokens")
+	fs.DurationVar(&c.Auth.TokenExpiration, "auth-token-expiration", c.Auth.TokenExpiration, "lifetime of issued access tokens")
+	fs.DurationVar(&c.Auth.RefreshTokenExpiration, "auth-refresh-token-expiration", c.Auth.RefreshTokenExpiration, "lifetime of refresh tokens, every refresh issues a new one")
+
+	fs.StringVar(&c.Links.IdGenerator, "links-id-generator", c.Links.IdGenerator, "generator of link ids: random or counter, counter ids are shorter but can be enumerated")
+	fs.StringVar(&c.Links.IdAlphabet, "links-id-alphabet", c.Links.IdAlphabet, "characters of generated link ids")
+	fs.IntVar(&c.Links.IdLength, "links-id-length", c.Links.IdLength, "initial length of generated link ids")
+	fs.StringVar(&c.Links.IdSalt, "links-id-salt", c.Links.IdSalt, "salt shuffling ids of the counter generator, must not change between restarts")
+	fs.IntVar(&c.Links.CacheSize, "links-cache-size", c.Links.CacheSize, "max number of links cached for redirects, 0 disables the cache")
+	fs.DurationVar(&c.Links.CacheTtl, "links-cache-ttl", c.Links.CacheTtl, "how long a cached link is used, changes made by other instances show up after it")
+	fs.StringVar(&c.Links.OnAccountDelete, "links-on-account-delete", c.Links.OnAccountDelete, "what happens to links of a deleted account: cascade or anonymize")
+
+	fs.StringVar(&c.Clicks.IpSalt, "clicks-ip-salt", c.Clicks.IpSalt, "salt for hashing client addresses in click statistics")
+	fs.IntVar(&c.Clicks.BufferSize, "clicks-buffer-size", c.Clicks.BufferSize, "max number of clicks waiting to be written")
+	fs.IntVar(&c.Clicks.BatchSize, "clicks-batch-size", c.Clicks.BatchSize, "number of clicks written at once")
+	fs.DurationVar(&c.Clicks.FlushInterval, "clicks-flush-interval", c.Clicks.FlushInterval, "max time a click waits to be written")
+
+	fs.IntVar(&c.StatusChecker.Workers, "status-checker-workers", c.StatusChecker.Workers, "number of concurrent link destination checks")
+	fs.DurationVar(&c.StatusChecker.Interval, "status-checker-interval", c.StatusChecker.Interval, "pause between rounds of link destination checks")
+}
+
+// Options are command line arguments that aren't part of the config.
+type Options struct {
+	// CheckConfig tells to only validate and print the config.
+	CheckConfig bool
+	// Args are the arguments left after flags.
+	Args []string
+}
+
+// Load builds the config from defaults, the yaml file, environment variables and
+// command line flags, every source overrides the previous ones.
+func Load(name string, args []string) (c Config, opts Options, err error) {
+	var path string
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&path, "config", os.Getenv(envPrefix+"CONFIG"), "path to the yaml config file")
+	fs.BoolVar(&opts.CheckConfig, "check-config", false, "validate and print the config, then exit")
+	parsed := Default()
+	bind(fs, &parsed)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, Options{}, err
+	}
+	opts.Args = fs.Args()
+
+	c = Default()
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return Config{}, Options{}, err
+		}
+		if err := yaml.UnmarshalStrict(b, &c); err != nil {
+			return Config{}, Options{}, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	values := flag.NewFlagSet(name, flag.ContinueOnError)
+	bind(values, &c)
+	var errs []string
+	values.VisitAll(func(f *flag.Flag) {
+		env := envName(f.Name)
+		if v, ok := os.LookupEnv(env); ok {
+			if err := values.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", env, err))
+			}
+		}
+	})
+	fs.Visit(func(f *flag.Flag) {
+		if values.Lookup(f.Name) != nil {
+			values.Set(f.Name, f.Value.String())
+		}
+	})
+	if len(errs) > 0 {
+		return Config{}, Options{}, errors.New(strings.Join(errs, "; "))
+	}
+	return c, opts, nil
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Validate reports every invalid value at once.
+func (c Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+	check(c.Server.Addr != "", "server.addr is empty")
+	check(c.Server.ReadTimeout > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	if c.Server.PublicUrl != "" {
+		u, err := url.Parse(c.Server.PublicUrl)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"server.public_url must be an http or https url, got %q", c.Server.PublicUrl)
+	}
+
+	check(c.Storage.Backend == "postgres" || c.Storage.Backend == "sqlite" || c.Storage.Backend == "memory",
+		"storage.backend must be postgres, sqlite or memory, got %q", c.Storage.Backend)
+
+	check(c.Storage.Backend == "memory" || c.Database.Dsn != "", "database.dsn is empty")
+	check(c.Database.MaxOpenConns > 0, "database.max_open_conns must be positive")
+	check(c.Database.MaxIdleConns >= 0, "database.max_idle_conns must not be negative")
+
+	check(c.Auth.KeysDir != "" || c.Auth.PrivateKey != "", "auth.private_key is empty")
+	check(c.Auth.KeysDir != "" || c.Auth.PublicKey != "", "auth.public_key is empty")
+	check(c.Auth.KeyRotationInterval > 0, "auth.key_rotation_interval must be positive")
+	check(c.Auth.TokenExpiration > 0, "auth.token_expiration must be positive")
+	check(c.Auth.RefreshTokenExpiration > 0, "auth.refresh_token_expiration must be positive")
+	// revocations of access tokens are deleted along with expired refresh tokens
+	check(c.Auth.TokenExpiration <= c.Auth.RefreshTokenExpiration, "auth.token_expiration must not exceed auth.refresh_token_expiration")
+
+	check(c.Links.IdGenerator == "random" || c.Links.IdGenerator == "counter",
+		"links.id_generator must be random or counter, got %q", c.Links.IdGenerator)
+	check(len(c.Links.IdAlphabet) >= 2, "links.id_alphabet must have at least two characters")
+	check(c.Links.IdLength > 0, "links.id_length must be positive")
+	check(c.Links.CacheSize >= 0, "links.cache_size must not be negative")
+	check(c.Links.CacheTtl > 0, "links.cache_ttl must be positive")
+	check(c.Links.OnAccountDelete == "cascade" || c.Links.OnAccountDelete == "anonymize",
+		"links.on_account_delete must be cascade or anonymize, got %q", c.Links.OnAccountDelete)
+
+	check(c.Clicks.IpSalt != "", "clicks.ip_salt is empty")
+	check(c.Clicks.BufferSize > 0, "clicks.buffer_size must be positive")
+	check(c.Clicks.BatchSize > 0, "clicks.batch_size must be positive")
+	check(c.Clicks.FlushInterval > 0, "clicks.flush_interval must be positive")
+
+	check(c.StatusChecker.Workers > 0, "status_checker.workers must be positive")
+	check(c.StatusChecker.Interval > 0, "status_checker.interval must be positive")
+
+	if len(errs) > 0 {
+		return errors.New("invalid config: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// String renders the config as yaml with secrets redacted, so it can be logged.
+func (c Config) String() string {
+	c.Database.Dsn = redactDsn(c.Database.Dsn)
+	c.Links.IdSalt = redact(c.Links.IdSalt)
+	c.Clicks.IpSalt = redact(c.Clicks.IpSalt)
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+var dsnPassword = regexp.MustCompile(`password=('(\\.|[^'])*'|\S*)`)
+
+// redactDsn hides the password of both url and key=value connection strings.
+func redactDsn(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "password="+redacted)
+}
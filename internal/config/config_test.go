@@ -0,0 +1,134 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setenv sets an environment variable for the rest of the test.
+func setenv(t *testing.T, key, value string) {
+	prev, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func writeConfig(t *testing.T, yaml string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(yaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadPrecedence checks that the file overrides defaults, environment variables override
+// the file and flags override both.
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  addr: ":1"
+  read_timeout: 1s
+links:
+  id_length: 7
+  cache_size: 5
+`)
+	setenv(t, "LENKE_SERVER_ADDR", ":2")
+	setenv(t, "LENKE_LINKS_ID_LENGTH", "8")
+
+	c, opts, err := Load("lenke", []string{"-config", path, "-server-addr", ":3", "migrate", "up"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.Addr != ":3" {
+		t.Errorf("got server.addr %q, want the flag", c.Server.Addr)
+	}
+	if c.Links.IdLength != 8 {
+		t.Errorf("got links.id_length %d, want the environment variable", c.Links.IdLength)
+	}
+	if c.Server.ReadTimeout != time.Second || c.Links.CacheSize != 5 {
+		t.Errorf("got server.read_timeout %v and links.cache_size %d, want the file", c.Server.ReadTimeout, c.Links.CacheSize)
+	}
+	if c.Server.WriteTimeout != Default().Server.WriteTimeout {
+		t.Errorf("got server.write_timeout %v, want the default", c.Server.WriteTimeout)
+	}
+	if len(opts.Args) != 2 || opts.Args[0] != "migrate" {
+		t.Errorf("got args %v, want the ones after flags", opts.Args)
+	}
+
+	setenv(t, "LENKE_LINKS_ID_LENGTH", "long")
+	if _, _, err := Load("lenke", nil); err == nil || !strings.Contains(err.Error(), "LENKE_LINKS_ID_LENGTH") {
+		t.Errorf("invalid environment variable: got %v", err)
+	}
+}
+
+// TestLoadUnknownKey checks that a misspelled key fails instead of being ignored.
+func TestLoadUnknownKey(t *testing.T) {
+	path := writeConfig(t, `
+server:
+  adress: ":1"
+`)
+	if _, _, err := Load("lenke", []string{"-config", path}); err == nil || !strings.Contains(err.Error(), "adress") {
+		t.Errorf("got %v, want an error naming the unknown key", err)
+	}
+}
+
+// TestValidate checks that a config is only valid with the secrets it can't default.
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.Storage.Backend = "memory"
+	c.Clicks.IpSalt = "salt"
+	if err := c.Validate(); err != nil {
+		t.Fatalf("got %v for a valid config", err)
+	}
+
+	for name, tt := range map[string]struct {
+		change func(c *Config)
+		want   string
+	}{
+		"missing ip salt":    {func(c *Config) { c.Clicks.IpSalt = "" }, "clicks.ip_salt is empty"},
+		"missing dsn":        {func(c *Config) { c.Storage.Backend = "sqlite" }, "database.dsn is empty"},
+		"relative url":       {func(c *Config) { c.Server.PublicUrl = "lenke.example" }, "server.public_url"},
+		"unknown generator":  {func(c *Config) { c.Links.IdGenerator = "uuid" }, "links.id_generator"},
+		"long access tokens": {func(c *Config) { c.Auth.TokenExpiration = 2 * c.Auth.RefreshTokenExpiration }, "auth.token_expiration"},
+	} {
+		invalid := c
+		tt.change(&invalid)
+		if err := invalid.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", name, err, tt.want)
+		}
+	}
+}
+
+// TestStringRedacted checks that the printed config hides passwords and salts.
+func TestStringRedacted(t *testing.T) {
+	for _, dsn := range []string{
+		"postgres://lenke:hunter2@db:5432/lenke?sslmode=disable",
+		"host=db user=lenke password=hunter2 dbname=lenke",
+		"host=db user=lenke password='hunter2 \\' x' dbname=lenke",
+	} {
+		c := Default()
+		c.Database.Dsn = dsn
+		c.Clicks.IpSalt = "pepper"
+		c.Links.IdSalt = "cumin"
+		s := c.String()
+		for _, secret := range []string{"hunter2", "pepper", "cumin"} {
+			if strings.Contains(s, secret) {
+				t.Errorf("config with dsn %q prints %q:\n%s", dsn, secret, s)
+			}
+		}
+		if !strings.Contains(s, "host") && !strings.Contains(s, "db:5432") {
+			t.Errorf("dsn %q isn't printed:\n%s", dsn, s)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package account
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 var (
 	ErrNotFound     = errors.New("not found")
@@ -18,7 +21,12 @@ type Credentials struct {
 }
 
 type Interface interface {
-	CreateAccount(cred Credentials) (Account, error)
-	GetAccountById(id string) (Account, error)
-	GetAccountByLogin(login string) (Account, error)
+	CreateAccount(ctx context.Context, cred Credentials) (Account, error)
+	GetAccountById(ctx context.Context, id string) (Account, error)
+	GetAccountByLogin(ctx context.Context, login string) (Account, error)
+	// UpdatePassword replaces the password hash, returns ErrNotFound if there is no such account.
+	UpdatePassword(ctx context.Context, id, password string) error
+	// DeleteAccount deletes the account, storages with foreign keys delete its links, sessions and api keys
+	// as well in the same transaction, or keep its links as anonymous ones if disownLinks.
+	DeleteAccount(ctx context.Context, id string, disownLinks bool) error
 }
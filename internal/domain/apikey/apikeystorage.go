@@ -0,0 +1,35 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+)
+
+// ApiKey is kept only as a hash like a refresh token, the key is shown to its owner once.
+type ApiKey struct {
+	Id        string
+	AccountId string
+	Name      string
+	Hash      string
+	// Prefix is the beginning of the key, it lets the owner tell keys apart.
+	Prefix     string
+	Scopes     []string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+type Interface interface {
+	StoreApiKey(ctx context.Context, k ApiKey) (ApiKey, error)
+	GetApiKeyByHash(ctx context.Context, hash string) (ApiKey, error)
+	GetApiKeysByAccountId(ctx context.Context, accountId string) ([]ApiKey, error)
+	// DeleteApiKey returns ErrNotFound if the account has no key with the id.
+	DeleteApiKey(ctx context.Context, id, accountId string) error
+	DeleteApiKeysByAccountId(ctx context.Context, accountId string) error
+	TouchApiKey(ctx context.Context, id string, usedAt time.Time) error
+}
@@ -0,0 +1,37 @@
+package click
+
+import (
+	"context"
+	"time"
+)
+
+type Click struct {
+	LinkId    string
+	ClickedAt time.Time
+	Referrer  string
+	UserAgent string
+	IpHash    string
+}
+
+// Period is the length of the intervals clicks are counted in, periods start at whole UTC days and hours.
+type Period string
+
+const (
+	Day  Period = "day"
+	Hour Period = "hour"
+)
+
+// Bucket is a number of clicks made during the period starting at Time.
+type Bucket struct {
+	Time   time.Time
+	Clicks int
+}
+
+type Interface interface {
+	StoreClick(ctx context.Context, c Click) error
+	StoreClicks(ctx context.Context, clicks []Click) error
+	// CountClicks returns the number of clicks of the link and of distinct ip hashes among them.
+	CountClicks(ctx context.Context, linkId string) (total, unique int, err error)
+	// CountClicksPer returns the number of clicks of the link in every period that has any, oldest first.
+	CountClicksPer(ctx context.Context, linkId string, period Period) ([]Bucket, error)
+}
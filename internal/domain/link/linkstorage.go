@@ -1,14 +1,17 @@
 package link
 
 import (
+	"context"
 	"errors"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/status"
+	"time"
 )
 
 var (
 	ErrNotFound     = errors.New("not found")
 	ErrAlreadyExist = errors.New("already exist")
 	ErrAccessDenied = errors.New("access denied")
+	ErrExpired      = errors.New("expired")
 )
 
 type Link struct {
@@ -16,14 +19,49 @@ type Link struct {
 	Link       string
 	LinkStatus status.LinkStatus
 	AccountId  *string
+	// Password is a bcrypt hash of the passphrase protecting the link, empty if there is none.
+	Password  string
+	ExpiresAt *time.Time
+	MaxClicks *int64
+	Clicks    int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Revision is a destination the link had before it was changed at ChangedAt.
+type Revision struct {
+	Id        int64
+	LinkId    string
+	Link      string
+	ChangedAt time.Time
+}
+
+// Expired reports whether the link has outlived its expiration time or click limit.
+func (l Link) Expired(now time.Time) bool {
+	if l.ExpiresAt != nil && !now.Before(*l.ExpiresAt) {
+		return true
+	}
+	return l.MaxClicks != nil && l.Clicks >= *l.MaxClicks
 }
 
 type Interface interface {
-	CheckIfLinkExists(linkId string) bool
-	StoreLink(link Link) (Link, error)
-	DeleteLink(linkId string) error
-	GetLinkByLinkId(linkId string) (Link, error)
-	GetLinksByAccountId(accountId string) ([]Link, error)
-	UpdateLinkStatusByLinkId(linkId string, linkStatus status.LinkStatus) error
-	GetAllUserLinks() ([]Link, error)
+	CheckIfLinkExists(ctx context.Context, linkId string) (bool, error)
+	// StoreLink returns ErrAlreadyExist if the link id is taken.
+	StoreLink(ctx context.Context, link Link) (Link, error)
+	// UpdateLink keeps the previous destination as a revision if it changes.
+	UpdateLink(ctx context.Context, link Link) (Link, error)
+	GetLinkRevisions(ctx context.Context, linkId string) ([]Revision, error)
+	DeleteLink(ctx context.Context, linkId string) error
+	// DeleteLinksByAccountId deletes every link of the account.
+	DeleteLinksByAccountId(ctx context.Context, accountId string) error
+	// DisownLinksByAccountId turns every link of the account into an anonymous one.
+	DisownLinksByAccountId(ctx context.Context, accountId string) error
+	GetLinkByLinkId(ctx context.Context, linkId string) (Link, error)
+	GetLinksByAccountId(ctx context.Context, accountId string) ([]Link, error)
+	// GetLinksByTarget returns links of the account pointing to target, anonymous ones if accountId is nil.
+	GetLinksByTarget(ctx context.Context, target string, accountId *string) ([]Link, error)
+	UpdateLinkStatusByLinkId(ctx context.Context, linkId string, linkStatus status.LinkStatus) error
+	GetAllUserLinks(ctx context.Context) ([]Link, error)
+	// IncrementLinkClicks atomically counts a redirect, returns ErrExpired if the click limit is already reached.
+	IncrementLinkClicks(ctx context.Context, linkId string) error
 }
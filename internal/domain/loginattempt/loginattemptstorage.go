@@ -0,0 +1,25 @@
+package loginattempt
+
+import (
+	"context"
+	"time"
+)
+
+// Attempts are the failed sign ins counted for a key, a login or an ip address.
+type Attempts struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+type Interface interface {
+	// GetAttempts returns zero Attempts for a key without failures.
+	GetAttempts(ctx context.Context, key string) (Attempts, error)
+	// SwapAttempts stores next for the key only if it still has prevFailures failures, zero for a key
+	// without any, and reports whether it did. Concurrent attempts use it to count a failure and
+	// set a lock in one step without overwriting each other.
+	SwapAttempts(ctx context.Context, key string, prevFailures int, next Attempts) (bool, error)
+	ResetAttempts(ctx context.Context, key string) error
+	// DeleteStaleAttempts forgets keys which last failed before the time.
+	DeleteStaleAttempts(ctx context.Context, before time.Time) error
+}
@@ -0,0 +1,42 @@
+package refreshtoken
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrAlreadyUsed = errors.New("already used")
+)
+
+// RefreshToken is kept only as a hash, the token itself is known to the client alone.
+type RefreshToken struct {
+	Hash string
+	// FamilyId is shared by all tokens rotated from the same sign in.
+	FamilyId  string
+	AccountId string
+	// AccessTokenId is the jti of the access token issued together with the refresh token.
+	AccessTokenId string
+	ExpiresAt     time.Time
+	// UsedAt is set once the token is exchanged for a new one.
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+type Interface interface {
+	StoreRefreshToken(ctx context.Context, t RefreshToken) error
+	GetRefreshToken(ctx context.Context, hash string) (RefreshToken, error)
+	// UseRefreshToken atomically marks the token as exchanged, returns ErrAlreadyUsed if it already was.
+	UseRefreshToken(ctx context.Context, hash string, usedAt time.Time) error
+	// RevokeFamily revokes every refresh token of the family and the access tokens issued with them.
+	RevokeFamily(ctx context.Context, familyId string, revokedAt time.Time) error
+	// RevokeAccount revokes every refresh token of the account and the access tokens issued with them.
+	RevokeAccount(ctx context.Context, accountId string, revokedAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, accessTokenId string) (bool, error)
+	// DeleteExpiredFamilies deletes the tokens of every family whose tokens all expired before the time.
+	// Used tokens of a family still in use are kept to detect their reuse.
+	DeleteExpiredFamilies(ctx context.Context, before time.Time) error
+}
@@ -0,0 +1,202 @@
+package linkrepo
+
+import (
+	"container/list"
+	"context"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/status"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"sync"
+	"time"
+)
+
+var (
+	cacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "link_cache_hits_total",
+		Help: "Link lookups served from the cache, including cached misses",
+	})
+	cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "link_cache_misses_total",
+		Help: "Link lookups passed to the storage",
+	})
+	cacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "link_cache_evictions_total",
+		Help: "Cached links dropped to make room for new ones",
+	})
+)
+
+// Cache is a read-through cache of links by id in front of the storage, it keeps up to size
+// recently used links and unknown ids for ttl. Writes made through the cache invalidate
+// the entry at once, writes made by other instances are seen after ttl.
+type Cache struct {
+	link.Interface
+
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// recent orders entries from the most to the least recently used.
+	recent *list.List
+	// generation changes on every invalidation, a lookup started before it must not fill the cache.
+	generation uint64
+}
+
+type entry struct {
+	linkId    string
+	link      link.Link
+	err       error
+	expiresAt time.Time
+}
+
+func New(storage link.Interface, size int, ttl time.Duration) *Cache {
+	return &Cache{
+		Interface: storage,
+		size:      size,
+		ttl:       ttl,
+		now:       time.Now,
+		entries:   make(map[string]*list.Element),
+		recent:    list.New(),
+	}
+}
+
+func (c *Cache) GetLinkByLinkId(ctx context.Context, linkId string) (link.Link, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[linkId]; ok {
+		e := el.Value.(*entry)
+		if c.now().Before(e.expiresAt) {
+			c.recent.MoveToFront(el)
+			c.mu.Unlock()
+			cacheHits.Inc()
+			return e.link, e.err
+		}
+		c.remove(el)
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	cacheMisses.Inc()
+	l, err := c.Interface.GetLinkByLinkId(ctx, linkId)
+	if err == nil || err == link.ErrNotFound {
+		c.put(generation, linkId, l, err)
+	}
+	return l, err
+}
+
+func (c *Cache) CheckIfLinkExists(ctx context.Context, linkId string) (bool, error) {
+	_, err := c.GetLinkByLinkId(ctx, linkId)
+	if err == link.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *Cache) StoreLink(ctx context.Context, lnk link.Link) (link.Link, error) {
+	// the id may be cached as unknown
+	defer c.invalidate(lnk.LinkId)
+	return c.Interface.StoreLink(ctx, lnk)
+}
+
+func (c *Cache) UpdateLink(ctx context.Context, lnk link.Link) (link.Link, error) {
+	defer c.invalidate(lnk.LinkId)
+	return c.Interface.UpdateLink(ctx, lnk)
+}
+
+func (c *Cache) DeleteLink(ctx context.Context, linkId string) error {
+	defer c.invalidate(linkId)
+	return c.Interface.DeleteLink(ctx, linkId)
+}
+
+func (c *Cache) DeleteLinksByAccountId(ctx context.Context, accountId string) error {
+	defer c.invalidateAccount(accountId)
+	return c.Interface.DeleteLinksByAccountId(ctx, accountId)
+}
+
+func (c *Cache) DisownLinksByAccountId(ctx context.Context, accountId string) error {
+	defer c.invalidateAccount(accountId)
+	return c.Interface.DisownLinksByAccountId(ctx, accountId)
+}
+
+// UpdateLinkStatusByLinkId updates the cached link in place, since the status checker
+// rewrites every link each round and invalidating would empty the cache.
+func (c *Cache) UpdateLinkStatusByLinkId(ctx context.Context, linkId string, linkStatus status.LinkStatus) error {
+	if err := c.Interface.UpdateLinkStatusByLinkId(ctx, linkId, linkStatus); err != nil {
+		return err
+	}
+	c.update(linkId, func(l *link.Link) { l.LinkStatus = linkStatus })
+	return nil
+}
+
+// IncrementLinkClicks keeps the cached counter close to the stored one, the limit itself
+// is enforced by the storage, so a stale counter can't let extra clicks through.
+func (c *Cache) IncrementLinkClicks(ctx context.Context, linkId string) error {
+	if err := c.Interface.IncrementLinkClicks(ctx, linkId); err != nil {
+		if err == link.ErrExpired {
+			c.update(linkId, func(l *link.Link) {
+				if l.MaxClicks != nil {
+					l.Clicks = *l.MaxClicks
+				}
+			})
+		}
+		return err
+	}
+	c.update(linkId, func(l *link.Link) { l.Clicks++ })
+	return nil
+}
+
+func (c *Cache) put(generation uint64, linkId string, l link.Link, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation || c.size <= 0 {
+		return
+	}
+	e := &entry{linkId: linkId, link: l, err: err, expiresAt: c.now().Add(c.ttl)}
+	if el, ok := c.entries[linkId]; ok {
+		el.Value = e
+		c.recent.MoveToFront(el)
+		return
+	}
+	c.entries[linkId] = c.recent.PushFront(e)
+	for c.recent.Len() > c.size {
+		c.remove(c.recent.Back())
+		cacheEvictions.Inc()
+	}
+}
+
+func (c *Cache) update(linkId string, fn func(l *link.Link)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[linkId]; ok {
+		if e := el.Value.(*entry); e.err == nil {
+			fn(&e.link)
+		}
+	}
+}
+
+func (c *Cache) invalidate(linkId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if el, ok := c.entries[linkId]; ok {
+		c.remove(el)
+	}
+}
+
+// invalidateAccount drops every cached link of the account, ids of its links aren't known otherwise.
+func (c *Cache) invalidateAccount(accountId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, el := range c.entries {
+		if l := el.Value.(*entry).link; l.AccountId != nil && *l.AccountId == accountId {
+			c.remove(el)
+		}
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.recent.Remove(el)
+	delete(c.entries, el.Value.(*entry).linkId)
+}
@@ -0,0 +1,138 @@
+package linkrepo
+
+import (
+	"context"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
+	memorylinkrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/linkrepo"
+	"testing"
+	"time"
+)
+
+// counting counts lookups reaching the storage.
+type counting struct {
+	link.Interface
+	lookups int
+}
+
+func (c *counting) GetLinkByLinkId(ctx context.Context, linkId string) (link.Link, error) {
+	c.lookups++
+	return c.Interface.GetLinkByLinkId(ctx, linkId)
+}
+
+func newCache(size int) (*Cache, *counting, *time.Time) {
+	storage := &counting{Interface: memorylinkrepo.NewMemory()}
+	c := New(storage, size, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	return c, storage, &now
+}
+
+func TestCacheServesRepeatedLookups(t *testing.T) {
+	ctx := context.Background()
+	c, storage, now := newCache(10)
+	if _, err := c.StoreLink(ctx, link.Link{LinkId: "a", Link: "http://a.com"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		l, err := c.GetLinkByLinkId(ctx, "a")
+		if err != nil || l.Link != "http://a.com" {
+			t.Fatalf("got %v, %v", l, err)
+		}
+	}
+	if storage.lookups != 1 {
+		t.Fatalf("storage is asked %d times, want 1", storage.lookups)
+	}
+
+	*now = now.Add(time.Minute)
+	if _, err := c.GetLinkByLinkId(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if storage.lookups != 2 {
+		t.Fatalf("expired entry is served from the cache")
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	c, _, _ := newCache(10)
+
+	if _, err := c.GetLinkByLinkId(ctx, "a"); err != link.ErrNotFound {
+		t.Fatalf("got %v, want %v", err, link.ErrNotFound)
+	}
+	if _, err := c.StoreLink(ctx, link.Link{LinkId: "a", Link: "http://a.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetLinkByLinkId(ctx, "a"); err != nil {
+		t.Fatalf("cached miss outlives the created link: %v", err)
+	}
+
+	if _, err := c.UpdateLink(ctx, link.Link{LinkId: "a", Link: "http://b.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := c.GetLinkByLinkId(ctx, "a"); l.Link != "http://b.com" {
+		t.Fatalf("link points to %s after update", l.Link)
+	}
+
+	if err := c.DeleteLink(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetLinkByLinkId(ctx, "a"); err != link.ErrNotFound {
+		t.Fatalf("deleted link is still served: %v", err)
+	}
+}
+
+func TestCacheInvalidatesLinksOfAccount(t *testing.T) {
+	ctx := context.Background()
+	c, _, _ := newCache(10)
+	owner, other := "1", "2"
+	for id, accountId := range map[string]*string{"a": &owner, "b": &other, "c": nil} {
+		if _, err := c.StoreLink(ctx, link.Link{LinkId: id, Link: "http://" + id + ".com", AccountId: accountId}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.GetLinkByLinkId(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.DisownLinksByAccountId(ctx, owner); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := c.GetLinkByLinkId(ctx, "a"); err != nil || l.AccountId != nil {
+		t.Fatalf("disowned link is served with its owner: %v, %v", l.AccountId, err)
+	}
+
+	if err := c.DeleteLinksByAccountId(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetLinkByLinkId(ctx, "b"); err != link.ErrNotFound {
+		t.Fatalf("deleted link is still served: %v", err)
+	}
+	if _, err := c.GetLinkByLinkId(ctx, "c"); err != nil {
+		t.Fatalf("link of nobody is gone: %v", err)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	c, storage, _ := newCache(2)
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := c.StoreLink(ctx, link.Link{LinkId: id, Link: "http://" + id + ".com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.GetLinkByLinkId(ctx, "a")
+	c.GetLinkByLinkId(ctx, "b")
+	c.GetLinkByLinkId(ctx, "a")
+	c.GetLinkByLinkId(ctx, "c")
+	storage.lookups = 0
+
+	c.GetLinkByLinkId(ctx, "a")
+	c.GetLinkByLinkId(ctx, "c")
+	if storage.lookups != 0 {
+		t.Fatalf("recently used links are evicted")
+	}
+	c.GetLinkByLinkId(ctx, "b")
+	if storage.lookups != 1 {
+		t.Fatalf("least recently used link is kept")
+	}
+}
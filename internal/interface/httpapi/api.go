@@ -1,25 +1,42 @@
 package httpapi
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/gorilla/mux"
+	domainlink "github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/prom"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/service/qr"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/account"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/click"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/link"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"math/big"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Api struct {
 	AccountUseCases account.AccountUseCasesInterface
 	LinkUseCases    link.LinkUseCasesInterface
+	ClickUseCases   click.ClickUseCasesInterface
+	// ClientIpHeader is a header set by a trusted reverse proxy to the client address,
+	// the peer address is used if it's empty.
+	ClientIpHeader string
+	// PublicUrl is the scheme and host short urls are built with, like https://lenke.example,
+	// the host of the request is used if it's empty.
+	PublicUrl string
 }
 
-func NewApi(a account.AccountUseCasesInterface, l link.LinkUseCasesInterface) *Api {
+func NewApi(a account.AccountUseCasesInterface, l link.LinkUseCasesInterface, c click.ClickUseCasesInterface) *Api {
 	return &Api{
 		AccountUseCases: a,
 		LinkUseCases:    l,
+		ClickUseCases:   c,
 	}
 }
 
@@ -33,20 +50,60 @@ func (a *Api) Router() http.Handler {
 	// /{link} get redirect
 	router.HandleFunc("/link/{link_id}", a.getPage).Methods(http.MethodGet)
 
+	// password form of a protected link submits here
+	router.HandleFunc("/link/{link_id}", a.postPage).Methods(http.MethodPost)
+
+	// qr code of the short link as png or svg
+	router.HandleFunc("/link/{link_id}/qr", a.getQrCode).Methods(http.MethodGet)
+
 	router.HandleFunc("/signup", a.postSignup).Methods(http.MethodPost)
 	router.HandleFunc("/signin", a.postSignin).Methods(http.MethodPost)
+	router.HandleFunc("/token/refresh", a.postRefreshToken).Methods(http.MethodPost)
+	router.HandleFunc("/signout", a.postSignout).Methods(http.MethodPost)
+
+	// public keys verifying our tokens, for other services
+	router.HandleFunc("/.well-known/jwks.json", a.getJwks).Methods(http.MethodGet)
 
 	// lookup all my links
-	router.HandleFunc("/accounts/{id}", a.authenticate(a.getAccount)).Methods(http.MethodGet)
+	router.HandleFunc("/accounts/{id}",
+		deprecated("/api/v1/links", a.authenticate(a.requireScope(account.ScopeLinksRead, a.getAccount)))).Methods(http.MethodGet)
 
 	// create link with account
-	router.HandleFunc("/accounts/{id}/", a.authenticate(a.postCreateUserLink)).Methods(http.MethodPost)
+	router.HandleFunc("/accounts/{id}/",
+		deprecated("/api/v1/links", a.authenticate(a.requireScope(account.ScopeLinksWrite, a.postCreateUserLink)))).Methods(http.MethodPost)
 
 	// /accounts/{id}/delete/{link_id}
-	router.HandleFunc("/accounts/{id}/delete/{link_id}", a.authenticate(a.getDeleteLink)).Methods(http.MethodGet)
+	router.HandleFunc("/accounts/{id}/delete/{link_id}",
+		deprecated("/api/v1/links/{link_id}", a.authenticate(a.requireScope(account.ScopeLinksWrite, a.getDeleteLink)))).Methods(http.MethodGet)
+
+	// click statistics of user's link
+	router.HandleFunc("/accounts/{id}/links/{link_id}/stats",
+		deprecated("/api/v1/links/{link_id}/stats", a.authenticate(a.requireScope(account.ScopeLinksRead, a.getLinkStats)))).Methods(http.MethodGet)
+
+	// versioned api, links are scoped by the account of the bearer token or the api key
+	v1 := router.PathPrefix("/api/v1").Subrouter()
+	v1.HandleFunc("/links", a.authenticate(a.requireScope(account.ScopeLinksWrite, a.postLinkV1))).Methods(http.MethodPost)
+	v1.HandleFunc("/links", a.authenticate(a.requireScope(account.ScopeLinksRead, a.getLinksV1))).Methods(http.MethodGet)
+	v1.HandleFunc("/links/{link_id}", a.authenticate(a.requireScope(account.ScopeLinksRead, a.getLinkV1))).Methods(http.MethodGet)
+	v1.HandleFunc("/links/{link_id}", a.authenticate(a.requireScope(account.ScopeLinksWrite, a.patchLinkV1))).Methods(http.MethodPatch)
+	v1.HandleFunc("/links/{link_id}", a.authenticate(a.requireScope(account.ScopeLinksWrite, a.deleteLinkV1))).Methods(http.MethodDelete)
+	v1.HandleFunc("/links/{link_id}/stats", a.authenticate(a.requireScope(account.ScopeLinksRead, a.getLinkStatsV1))).Methods(http.MethodGet)
+	v1.HandleFunc("/links/{link_id}/revisions", a.authenticate(a.requireScope(account.ScopeLinksRead, a.getLinkRevisionsV1))).Methods(http.MethodGet)
+	v1.HandleFunc("/links/{link_id}/revisions/{revision_id}/rollback",
+		a.authenticate(a.requireScope(account.ScopeLinksWrite, a.postRollbackLinkV1))).Methods(http.MethodPost)
+
+	// api keys for scripts, managed only by a signed in user
+	v1.HandleFunc("/keys", a.authenticate(a.requireSession(a.postApiKeyV1))).Methods(http.MethodPost)
+	v1.HandleFunc("/keys", a.authenticate(a.requireSession(a.getApiKeysV1))).Methods(http.MethodGet)
+	v1.HandleFunc("/keys/{key_id}", a.authenticate(a.requireSession(a.deleteApiKeyV1))).Methods(http.MethodDelete)
+
+	// the account itself, both end every session
+	v1.HandleFunc("/account/password", a.authenticate(a.requireSession(a.putPasswordV1))).Methods(http.MethodPut)
+	v1.HandleFunc("/account", a.authenticate(a.requireSession(a.deleteAccountV1))).Methods(http.MethodDelete)
 
 	router.Handle("/metrics", promhttp.Handler())
 
+	router.Use(a.tagRequest)
 	router.Use(prom.Measurer())
 	router.Use(a.logger)
 
@@ -62,26 +119,13 @@ type postSignupRequestModel struct {
 func (a *Api) postSignup(w http.ResponseWriter, r *http.Request) {
 	var m postSignupRequestModel
 	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		a.writeError(w, r, errInvalidJson)
 		return
 	}
 
-	acc, err := a.AccountUseCases.LoggerCreateAccount(a.AccountUseCases.CreateAccount)(m.Login, m.Password)
+	acc, err := a.AccountUseCases.LoggerCreateAccount(a.AccountUseCases.CreateAccount)(r.Context(), m.Login, m.Password)
 	if err != nil {
-		switch err {
-		case
-			account.ErrInvalidLoginString,
-			account.ErrInvalidPasswordString,
-			account.ErrTooShortString,
-			account.ErrTooLongString,
-			account.ErrNoCapitalLetters,
-			account.ErrNoDigits:
-
-			w.WriteHeader(http.StatusBadRequest)
-		default:
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-		fmt.Println(err)
+		a.writeError(w, r, err)
 		return
 	}
 
@@ -90,42 +134,149 @@ func (a *Api) postSignup(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 }
 
-// postSignin handles login request for existing user.
+type tokensResponseModel struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+func newTokensResponseModel(t account.Tokens) tokensResponseModel {
+	return tokensResponseModel{
+		AccessToken:  t.AccessToken,
+		RefreshToken: t.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(t.ExpiresIn / time.Second),
+	}
+}
+
+type refreshTokenRequestModel struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// postSignin handles login request for existing user. Clients accepting json get
+// a refresh token as well, others get just the access token as before.
 func (a *Api) postSignin(w http.ResponseWriter, r *http.Request) {
 	var m postSignupRequestModel
 	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		a.writeError(w, r, errInvalidJson)
 		return
 	}
 
-	token, err := a.AccountUseCases.LoggerLoginToAccount(a.AccountUseCases.LoginToAccount)(m.Login, m.Password)
+	tokens, err := a.AccountUseCases.LoggerLoginToAccount(a.AccountUseCases.LoginToAccount)(r.Context(), m.Login, m.Password, a.clientIp(r))
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		a.writeError(w, r, err)
 		return
 	}
 
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJson(w, http.StatusOK, newTokensResponseModel(tokens))
+		return
+	}
 	w.Header().Set("Content-Type", "application/jwt")
-	if _, err := w.Write([]byte(token)); err != nil {
+	if _, err := w.Write([]byte(tokens.AccessToken)); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 }
 
+// postRefreshToken exchanges a refresh token for a new pair of tokens.
+func (a *Api) postRefreshToken(w http.ResponseWriter, r *http.Request) {
+	var m refreshTokenRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		a.writeError(w, r, errInvalidJson)
+		return
+	}
+
+	tokens, err := a.AccountUseCases.LoggerRefreshToken(a.AccountUseCases.RefreshToken)(r.Context(), m.RefreshToken)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	writeJson(w, http.StatusOK, newTokensResponseModel(tokens))
+}
+
+// postSignout revokes the refresh token and every token issued since the sign in.
+func (a *Api) postSignout(w http.ResponseWriter, r *http.Request) {
+	var m refreshTokenRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		a.writeError(w, r, errInvalidJson)
+		return
+	}
+
+	if err := a.AccountUseCases.LoggerSignOut(a.AccountUseCases.SignOut)(r.Context(), m.RefreshToken); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// jwkModel is an rsa public key in the json web key format of RFC 7517.
+type jwkModel struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwksResponseModel struct {
+	Keys []jwkModel `json:"keys"`
+}
+
+// jwksMaxAge lets verifiers cache the keys, a key is published long before it signs
+// if it's generated with a delay, and a token with an unknown kid is worth a refetch.
+const jwksMaxAge = 5 * time.Minute
+
+// getJwks publishes the public keys tokens can be verified with.
+func (a *Api) getJwks(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.AccountUseCases.LoggerPublicKeys(a.AccountUseCases.PublicKeys)(r.Context())
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	resp := jwksResponseModel{Keys: make([]jwkModel, 0, len(keys))}
+	for _, k := range keys {
+		resp.Keys = append(resp.Keys, jwkModel{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: "RS256",
+			Kid: k.Id,
+			N:   base64.RawURLEncoding.EncodeToString(k.Key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.Key.E)).Bytes()),
+		})
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge/time.Second)))
+	writeJson(w, http.StatusOK, resp)
+}
+
 type postLinkRequestModel struct {
-	Link string `json:"link"`
+	Link      string     `json:"link"`
+	Alias     string     `json:"alias"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxClicks *int64     `json:"max_clicks"`
+	Password  string     `json:"password"`
+	Reuse     bool       `json:"reuse"`
 }
 
 // postCreateLink handles creating short link from user's link
 func (a *Api) postCreateLink(w http.ResponseWriter, r *http.Request) {
 	var m postLinkRequestModel
 	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		a.writeError(w, r, errInvalidJson)
 		return
 	}
 
-	shortLink, err := a.LinkUseCases.LoggerCutLink(a.LinkUseCases.CutLink)(m.Link, nil)
+	shortLink, err := a.LinkUseCases.LoggerCutLink(a.LinkUseCases.CutLink)(r.Context(), m.Link, nil, link.CutLinkOptions{
+		Alias:     m.Alias,
+		ExpiresAt: m.ExpiresAt,
+		MaxClicks: m.MaxClicks,
+		Password:  m.Password,
+		Reuse:     m.Reuse,
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		a.writeError(w, r, err)
 		return
 	}
 
@@ -140,17 +291,147 @@ func (a *Api) getPage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	linkId, ok := vars["link_id"]
 	if !ok {
-		w.WriteHeader(http.StatusInternalServerError)
+		a.writeError(w, r, errInternal)
 		return
 	}
 
-	l, err := a.LinkUseCases.LoggerGetLinkByLinkId(a.LinkUseCases.GetLinkByLinkId)(linkId)
+	l, err := a.LinkUseCases.LoggerGetLinkByLinkId(a.LinkUseCases.GetLinkByLinkId)(r.Context(), linkId)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		if err == link.ErrPasswordRequired {
+			writePasswordForm(w, http.StatusOK, "")
+			return
+		}
+		a.writeError(w, r, err)
+		return
+	}
+
+	a.redirect(w, r, linkId, l)
+}
+
+// postPage handles the password form of a protected link, redirects if the password is right
+func (a *Api) postPage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	linkId, ok := vars["link_id"]
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	l, err := a.LinkUseCases.LoggerUnlockLink(a.LinkUseCases.UnlockLink)(r.Context(), linkId, r.PostFormValue("password"))
+	if err != nil {
+		switch err {
+		case link.ErrWrongPassword:
+			writePasswordForm(w, http.StatusUnauthorized, "Wrong password, try again.")
+		case link.ErrTooManyAttempts:
+			writePasswordForm(w, http.StatusTooManyRequests, "Too many failed attempts, try again later.")
+		default:
+			a.writeError(w, r, err)
+		}
+		return
+	}
+
+	a.redirect(w, r, linkId, l)
+}
+
+// getQrCode handles request for the qr code of a short link
+func (a *Api) getQrCode(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	linkId, ok := vars["link_id"]
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	exists, err := a.LinkUseCases.LoggerLinkExists(a.LinkUseCases.LinkExists)(r.Context(), linkId)
+	if err != nil {
+		a.writeError(w, r, err)
 		return
 	}
+	if !exists {
+		a.writeError(w, r, domainlink.ErrNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := qr.Options{
+		Size:   qr.DefaultSize,
+		Margin: qr.DefaultMargin,
+		Level:  qr.DefaultLevel,
+	}
+	if v := query.Get("size"); v != "" {
+		if opts.Size, err = strconv.Atoi(v); err != nil {
+			a.writeError(w, r, qr.ErrInvalidSize)
+			return
+		}
+	}
+	if v := query.Get("margin"); v != "" {
+		if opts.Margin, err = strconv.Atoi(v); err != nil {
+			a.writeError(w, r, qr.ErrInvalidMargin)
+			return
+		}
+	}
+	if v := query.Get("level"); v != "" {
+		opts.Level = strings.ToUpper(v)
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = "png"
+		if strings.Contains(r.Header.Get("Accept"), "image/svg+xml") {
+			format = "svg"
+		}
+	}
+
+	var (
+		img         []byte
+		contentType string
+	)
+	switch format {
+	case "png":
+		img, err = qr.PNG(a.shortUrl(r, linkId), opts)
+		contentType = "image/png"
+	case "svg":
+		img, err = qr.SVG(a.shortUrl(r, linkId), opts)
+		contentType = "image/svg+xml"
+	default:
+		err = errInvalidFormat
+	}
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Vary", "Accept")
+	if _, err := w.Write(img); err != nil {
+		fmt.Printf("request-id: %s; error: %v;\n", requestId(r), err)
+	}
+}
 
-	http.Redirect(w, r, l, http.StatusSeeOther)
+// redirect sends the client to the destination of the link and records the click.
+func (a *Api) redirect(w http.ResponseWriter, r *http.Request, linkId, destination string) {
+	// a lost click must not break the redirect, so the error is only logged
+	_ = a.ClickUseCases.LoggerRegisterClick(a.ClickUseCases.RegisterClick)(r.Context(), linkId, r.Referer(), r.UserAgent(), a.clientIp(r))
+
+	http.Redirect(w, r, destination, http.StatusSeeOther)
+}
+
+// clientIp extracts the address of the client from the request. Behind a proxy that is the last
+// address of ClientIpHeader, the one the proxy appended, since the client may have sent its own.
+func (a *Api) clientIp(r *http.Request) string {
+	if a.ClientIpHeader != "" {
+		if values := r.Header.Values(a.ClientIpHeader); len(values) > 0 {
+			addrs := strings.Split(values[len(values)-1], ",")
+			if addr := strings.TrimSpace(addrs[len(addrs)-1]); addr != "" {
+				return addr
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 type getAccountResponseModel struct {
@@ -161,24 +442,24 @@ type getAccountResponseModel struct {
 func (a *Api) getAccount(w http.ResponseWriter, r *http.Request) {
 	aid, ok := r.Context().Value("account_id").(string)
 	if !ok {
-		w.WriteHeader(http.StatusInternalServerError)
+		a.writeError(w, r, errInternal)
 		return
 	}
 
 	vars := mux.Vars(r)
 	accountId, ok := vars["id"]
 	if !ok {
-		w.WriteHeader(http.StatusInternalServerError)
+		a.writeError(w, r, errInternal)
 		return
 	}
 	if accountId != aid {
-		w.WriteHeader(http.StatusBadRequest)
+		a.writeError(w, r, errForeignAccount)
 		return
 	}
 
-	links, err := a.LinkUseCases.LoggerGetLinksByAccountId(a.LinkUseCases.GetLinksByAccountId)(aid)
+	links, err := a.LinkUseCases.LoggerGetLinksByAccountId(a.LinkUseCases.GetLinksByAccountId)(r.Context(), aid)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		a.writeError(w, r, err)
 		return
 	}
 
@@ -188,6 +469,13 @@ func (a *Api) getAccount(w http.ResponseWriter, r *http.Request) {
 			LinkId:     l.LinkId,
 			Link:       l.Link,
 			LinkStatus: l.LinkStatus,
+			ExpiresAt:  l.ExpiresAt,
+			MaxClicks:  l.MaxClicks,
+			Clicks:     l.Clicks,
+			Expired:    l.Expired,
+			Protected:  l.Protected,
+			CreatedAt:  l.CreatedAt,
+			UpdatedAt:  l.UpdatedAt,
 		})
 	}
 
@@ -198,37 +486,48 @@ func (a *Api) getAccount(w http.ResponseWriter, r *http.Request) {
 }
 
 type postAccountLinkRequestModel struct {
-	Link string `json:"link"`
+	Link      string     `json:"link"`
+	Alias     string     `json:"alias"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxClicks *int64     `json:"max_clicks"`
+	Password  string     `json:"password"`
+	Reuse     bool       `json:"reuse"`
 }
 
 // postCreateUserLink handles request for creating short link from specific user
 func (a *Api) postCreateUserLink(w http.ResponseWriter, r *http.Request) {
 	aid, ok := r.Context().Value("account_id").(string)
 	if !ok {
-		w.WriteHeader(http.StatusInternalServerError)
+		a.writeError(w, r, errInternal)
 		return
 	}
 
 	vars := mux.Vars(r)
 	accountId, ok := vars["id"]
 	if !ok {
-		w.WriteHeader(http.StatusInternalServerError)
+		a.writeError(w, r, errInternal)
 		return
 	}
 	if accountId != aid {
-		w.WriteHeader(http.StatusBadRequest)
+		a.writeError(w, r, errForeignAccount)
 		return
 	}
 
 	var m postAccountLinkRequestModel
 	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		a.writeError(w, r, errInvalidJson)
 		return
 	}
 
-	shortLink, err := a.LinkUseCases.LoggerCutLink(a.LinkUseCases.CutLink)(m.Link, &aid)
+	shortLink, err := a.LinkUseCases.LoggerCutLink(a.LinkUseCases.CutLink)(r.Context(), m.Link, &aid, link.CutLinkOptions{
+		Alias:     m.Alias,
+		ExpiresAt: m.ExpiresAt,
+		MaxClicks: m.MaxClicks,
+		Password:  m.Password,
+		Reuse:     m.Reuse,
+	})
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		a.writeError(w, r, err)
 		return
 	}
 
@@ -242,30 +541,96 @@ func (a *Api) postCreateUserLink(w http.ResponseWriter, r *http.Request) {
 func (a *Api) getDeleteLink(w http.ResponseWriter, r *http.Request) {
 	aid, ok := r.Context().Value("account_id").(string)
 	if !ok {
-		w.WriteHeader(http.StatusInternalServerError)
+		a.writeError(w, r, errInternal)
 		return
 	}
 
 	vars := mux.Vars(r)
 	accountId, ok := vars["id"]
 	if !ok {
-		w.WriteHeader(http.StatusInternalServerError)
+		a.writeError(w, r, errInternal)
 		return
 	}
 	if accountId != aid {
-		w.WriteHeader(http.StatusBadRequest)
+		a.writeError(w, r, errForeignAccount)
 		return
 	}
 	linkId, ok := vars["link_id"]
 	if !ok {
-		w.WriteHeader(http.StatusInternalServerError)
+		a.writeError(w, r, errInternal)
 		return
 	}
 
-	err := a.LinkUseCases.LoggerDeleteLink(a.LinkUseCases.DeleteLink)(linkId, aid)
+	err := a.LinkUseCases.LoggerDeleteLink(a.LinkUseCases.DeleteLink)(r.Context(), linkId, aid)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		a.writeError(w, r, err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
+
+type getLinkStatsResponseModel struct {
+	TotalClicks    int                `json:"total_clicks"`
+	UniqueVisitors int                `json:"unique_visitors"`
+	PerDay         []statsBucketModel `json:"per_day"`
+	PerHour        []statsBucketModel `json:"per_hour"`
+}
+
+type statsBucketModel struct {
+	Time   time.Time `json:"time"`
+	Clicks int       `json:"clicks"`
+}
+
+// getLinkStats handles request for click statistics of user's link
+func (a *Api) getLinkStats(w http.ResponseWriter, r *http.Request) {
+	aid, ok := r.Context().Value("account_id").(string)
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountId, ok := vars["id"]
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+	if accountId != aid {
+		a.writeError(w, r, errForeignAccount)
+		return
+	}
+	linkId, ok := vars["link_id"]
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	a.writeLinkStats(w, r, linkId, aid)
+}
+
+func (a *Api) writeLinkStats(w http.ResponseWriter, r *http.Request, linkId, accountId string) {
+	stats, err := a.ClickUseCases.LoggerGetLinkStats(a.ClickUseCases.GetLinkStats)(r.Context(), linkId, accountId)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	ret := getLinkStatsResponseModel{
+		TotalClicks:    stats.TotalClicks,
+		UniqueVisitors: stats.UniqueVisitors,
+		PerDay:         toStatsBucketModels(stats.PerDay),
+		PerHour:        toStatsBucketModels(stats.PerHour),
+	}
+	if err := json.NewEncoder(w).Encode(ret); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func toStatsBucketModels(buckets []click.Bucket) []statsBucketModel {
+	res := make([]statsBucketModel, 0, len(buckets))
+	for _, b := range buckets {
+		res = append(res, statsBucketModel{Time: b.Time, Clicks: b.Clicks})
+	}
+	return res
+}
@@ -1,141 +1,590 @@
 package httpapi
 
-// todo: implement tests
-//
-//import (
-//	"bytes"
-//	"encoding/json"
-//	"errors"
-//	account2 "github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/account"
-//	"net/http"
-//	"net/http/httptest"
-//	"testing"
-//)
-//
-//type AccountUseCasesMock struct {}
-//
-//func (AccountUseCasesMock) CreateAccount(login, password string) (account2.Account, error) {
-//	switch login {
-//	case "alice":
-//		return account2.Account{
-//			Id: "1",
-//		}, nil
-//	default:
-//		return account2.Account{}, errors.New("failed to create an account")
-//	}
-//}
-//
-//func (AccountUseCasesMock) GetAccountById(id string) (account2.Account, error) {
-//	panic("implement me")
-//}
-//
-//func (AccountUseCasesMock) LoginToAccount(login, password string) (string, error) {
-//	if login == "alice" && password == "123" {
-//		return "token", nil
-//	}
-//	return "", errors.New("invalid login or password")
-//}
-//
-//func Test_postSignup(t *testing.T) {
-//	service := NewApi(&AccountUseCasesMock{})
-//	router := service.Router()
-//
-//	t.Run("failure on invalid json", func(t *testing.T) {
-//		resp := invalidJsonTest(router, "/signup")
-//		assertStatusCode(t, resp.Code, http.StatusBadRequest)
-//	})
-//	t.Run("failed to create account", func(t *testing.T) {
-//		m := postSignupRequestModel{
-//			Login:    "bob",
-//			Password: "123",
-//		}
-//		b, err := json.Marshal(m)
-//		if err != nil {
-//			t.Fatal("failed to marshal struct")
-//		}
-//		req := httptest.NewRequest(http.MethodPost, "/signup", bytes.NewReader(b))
-//		resp := httptest.NewRecorder()
-//
-//		router.ServeHTTP(resp, req)
-//
-//		assertStatusCode(t, resp.Code, http.StatusInternalServerError)
-//	})
-//
-//	t.Run("successful account creation", func(t *testing.T) {
-//		m := postSignupRequestModel{
-//			Login:    "alice",
-//			Password: "123",
-//		}
-//		b, err := json.Marshal(m)
-//		if err != nil {
-//			t.Fatal("failed to marshal struct")
-//		}
-//		req := httptest.NewRequest(http.MethodPost, "/signup", bytes.NewReader(b))
-//		resp := httptest.NewRecorder()
-//
-//		router.ServeHTTP(resp, req)
-//
-//		assertStatusCode(t, resp.Code, http.StatusCreated)
-//
-//		location := resp.Header().Get("Location")
-//		if  location != "/accounts/1" {
-//			t.Errorf("Server MUST return %s Location header, but %s given", "/accounts/1", location)
-//		}
-//	})
-//}
-//
-//func Test_postSignin(t *testing.T) {
-//	service := NewApi(&AccountUseCasesMock{})
-//	router := service.Router()
-//
-//	t.Run("failure on invalid json", func(t *testing.T) {
-//		resp := invalidJsonTest(router, "/signin")
-//		assertStatusCode(t, resp.Code, http.StatusBadRequest)
-//	})
-//	t.Run("failed login with incorrect login or password", func(t *testing.T) {
-//		m := postSignupRequestModel{
-//			Login:    "bob",
-//			Password: "123",
-//		}
-//		b, err := json.Marshal(m)
-//		if err != nil {
-//			t.Fatal("failed to marshal struct")
-//		}
-//		req := httptest.NewRequest(http.MethodPost, "/signin", bytes.NewReader(b))
-//		resp := httptest.NewRecorder()
-//
-//		router.ServeHTTP(resp, req)
-//
-//		assertStatusCode(t, resp.Code, http.StatusBadRequest)
-//	})
-//	t.Run("successful login with correct password", func(t *testing.T) {
-//		m := postSignupRequestModel{
-//			Login:    "alice",
-//			Password: "123",
-//		}
-//		b, err := json.Marshal(m)
-//		if err != nil {
-//			t.Fatal("failed to marshal struct")
-//		}
-//		req := httptest.NewRequest(http.MethodPost, "/signin", bytes.NewReader(b))
-//		resp := httptest.NewRecorder()
-//
-//		router.ServeHTTP(resp, req)
-//
-//		assertStatusCode(t, resp.Code, http.StatusOK)
-//	})
-//}
-//
-//func assertStatusCode(t *testing.T, expectedCode, actualCode int) {
-//	if expectedCode != actualCode {
-//		t.Errorf("Server MUST return %d (%s) status code, but %d (%s) given",
-//			expectedCode, http.StatusText(expectedCode), actualCode, http.StatusText(actualCode))
-//	}
-//}
-//
-//func invalidJsonTest(router http.Handler, path string) *httptest.ResponseRecorder {
-//	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte("{a:")))
-//	resp := httptest.NewRecorder()
-//	router.ServeHTTP(resp, req)
-//	return resp
-//}
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	domainaccount "github.com/mp-hl-2021/lenkeforkortelse/internal/domain/account"
+	domainlink "github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/loginattempt"
+	memoryaccountrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/accountrepo"
+	memoryapikeyrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/apikeyrepo"
+	memoryclickrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/clickrepo"
+	memorylinkrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/linkrepo"
+	memoryloginattemptrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/loginattemptrepo"
+	memoryrefreshtokenrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/refreshtokenrepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/service/idgen"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/service/token"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/account"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/click"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/link"
+	"image/png"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testPassword = "Secret123"
+
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+)
+
+// testApi is the api backed by memory storages, its tokens are signed with a key generated once per run.
+type testApi struct {
+	*Api
+	router   http.Handler
+	accounts *account.AccountUseCases
+	links    *memorylinkrepo.Memory
+}
+
+func newTestApi(t *testing.T) *testApi {
+	testKeyOnce.Do(func() {
+		var err error
+		if testKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			panic(err)
+		}
+	})
+	refreshTokens := memoryrefreshtokenrepo.NewMemory()
+	auth, err := token.NewJwtHandler([]token.Key{{Id: "test", PrivateKey: testKey}}, time.Hour, refreshTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen, err := idgen.NewRandom(idgen.Base62)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links := memorylinkrepo.NewMemory()
+	accounts := &account.AccountUseCases{
+		AccountStorage:         memoryaccountrepo.NewMemory(),
+		RefreshTokenStorage:    refreshTokens,
+		ApiKeyStorage:          memoryapikeyrepo.NewMemory(),
+		LinkStorage:            links,
+		LoginAttemptStorage:    memoryloginattemptrepo.NewMemory(),
+		Auth:                   auth,
+		RefreshTokenExpiration: time.Hour,
+		LinksOnDelete:          account.DeleteLinks,
+	}
+	clicks := &click.ClickUseCases{
+		ClickStorage: memoryclickrepo.NewMemory(links),
+		LinkStorage:  links,
+		IpSalt:       "test",
+	}
+	api := NewApi(accounts, &link.LinkUseCases{LinkStorage: links, IdGenerator: gen}, clicks)
+	return &testApi{Api: api, router: api.Router(), accounts: accounts, links: links}
+}
+
+// signIn creates an account and returns its id with an access token.
+func (s *testApi) signIn(t *testing.T, login string) (string, string) {
+	ctx := context.Background()
+	acc, err := s.accounts.CreateAccount(ctx, login, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := s.accounts.LoginToAccount(ctx, login, testPassword, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return acc.Id, tokens.AccessToken
+}
+
+// do serves a request with body encoded as json, authorization is sent as is unless it's empty.
+func (s *testApi) do(t *testing.T, method, path, authorization string, body interface{}) *httptest.ResponseRecorder {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			t.Fatal("failed to marshal struct")
+		}
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp := httptest.NewRecorder()
+	s.router.ServeHTTP(resp, req)
+	return resp
+}
+
+func assertStatusCode(t *testing.T, expectedCode, actualCode int) {
+	t.Helper()
+	if expectedCode != actualCode {
+		t.Errorf("Server MUST return %d (%s) status code, but %d (%s) given",
+			expectedCode, http.StatusText(expectedCode), actualCode, http.StatusText(actualCode))
+	}
+}
+
+// assertError checks the status code and the json error of the response.
+func assertError(t *testing.T, resp *httptest.ResponseRecorder, expectedCode int, code, field string) {
+	t.Helper()
+	assertStatusCode(t, expectedCode, resp.Code)
+	var m errorResponseModel
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		t.Fatalf("error response is not json: %v", err)
+	}
+	if m.Code != code || m.Field != field {
+		t.Errorf("got error %q of field %q, want %q of field %q", m.Code, m.Field, code, field)
+	}
+}
+
+// TestPostLinkAlias checks that aliases are validated and a taken one is refused with 409.
+func TestPostLinkAlias(t *testing.T) {
+	s := newTestApi(t)
+	_, accessToken := s.signIn(t, "alice")
+	bearer := "Bearer " + accessToken
+
+	t.Run("alias is given out", func(t *testing.T) {
+		resp := s.do(t, http.MethodPost, "/api/v1/links", bearer, postLinkRequestModel{Link: "https://a.example", Alias: "my-alias"})
+		assertStatusCode(t, http.StatusCreated, resp.Code)
+		if location := resp.Header().Get("Location"); location != "/api/v1/links/my-alias" {
+			t.Errorf("got Location %q, want /api/v1/links/my-alias", location)
+		}
+	})
+	t.Run("taken alias", func(t *testing.T) {
+		resp := s.do(t, http.MethodPost, "/api/v1/links", bearer, postLinkRequestModel{Link: "https://b.example", Alias: "my-alias"})
+		assertError(t, resp, http.StatusConflict, "alias_taken", "alias")
+	})
+	t.Run("taken alias of an anonymous link", func(t *testing.T) {
+		resp := s.do(t, http.MethodPost, "/links", "", postLinkRequestModel{Link: "https://b.example", Alias: "my-alias"})
+		assertError(t, resp, http.StatusConflict, "alias_taken", "alias")
+	})
+	t.Run("invalid alias", func(t *testing.T) {
+		resp := s.do(t, http.MethodPost, "/api/v1/links", bearer, postLinkRequestModel{Link: "https://a.example", Alias: "a/b"})
+		assertError(t, resp, http.StatusBadRequest, "invalid_characters", "alias")
+	})
+	t.Run("reserved alias", func(t *testing.T) {
+		resp := s.do(t, http.MethodPost, "/api/v1/links", bearer, postLinkRequestModel{Link: "https://a.example", Alias: "SignUp"})
+		assertError(t, resp, http.StatusBadRequest, "reserved_alias", "alias")
+	})
+}
+
+// TestGetPageExpired checks that an expired link or one out of clicks responds with 410.
+func TestGetPageExpired(t *testing.T) {
+	s := newTestApi(t)
+	past := time.Now().Add(-time.Second)
+	if _, err := s.links.StoreLink(context.Background(), domainlink.Link{LinkId: "expired", Link: "https://a.example", ExpiresAt: &past}); err != nil {
+		t.Fatal(err)
+	}
+	resp := s.do(t, http.MethodGet, "/link/expired", "", nil)
+	assertError(t, resp, http.StatusGone, "link_expired", "")
+
+	maxClicks := int64(1)
+	resp = s.do(t, http.MethodPost, "/links", "", postLinkRequestModel{Link: "https://a.example", Alias: "once", MaxClicks: &maxClicks})
+	assertStatusCode(t, http.StatusOK, resp.Code)
+	resp = s.do(t, http.MethodGet, "/link/once", "", nil)
+	assertStatusCode(t, http.StatusSeeOther, resp.Code)
+	if location := resp.Header().Get("Location"); location != "https://a.example" {
+		t.Errorf("redirected to %q, want https://a.example", location)
+	}
+	resp = s.do(t, http.MethodGet, "/link/once", "", nil)
+	assertError(t, resp, http.StatusGone, "link_expired", "")
+}
+
+// TestLinksV1 goes through the life of a link under /api/v1 and checks links of others can't be touched.
+func TestLinksV1(t *testing.T) {
+	s := newTestApi(t)
+	_, accessToken := s.signIn(t, "alice")
+	bearer := "Bearer " + accessToken
+	_, otherToken := s.signIn(t, "mallory")
+	other := "Bearer " + otherToken
+	if _, err := s.links.StoreLink(context.Background(), domainlink.Link{LinkId: "anonymous", Link: "https://a.example"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := s.do(t, http.MethodPost, "/api/v1/links", bearer, postLinkRequestModel{Link: "https://a.example", Alias: "mine"})
+	assertStatusCode(t, http.StatusCreated, resp.Code)
+	var l linkResponseModel
+	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+		t.Fatal(err)
+	}
+	if l.Id != "mine" || l.Target != "https://a.example" || l.ShortUrl != "http://example.com/link/mine" {
+		t.Errorf("got link %+v", l)
+	}
+
+	resp = s.do(t, http.MethodGet, "/api/v1/links", bearer, nil)
+	assertStatusCode(t, http.StatusOK, resp.Code)
+	var links getLinksResponseModel
+	if err := json.NewDecoder(resp.Body).Decode(&links); err != nil {
+		t.Fatal(err)
+	}
+	if len(links.Links) != 1 || links.Links[0].Id != "mine" {
+		t.Errorf("got links %+v, want just mine", links.Links)
+	}
+
+	target := "https://b.example"
+	resp = s.do(t, http.MethodPatch, "/api/v1/links/mine", bearer, patchLinkRequestModel{Link: &target})
+	assertStatusCode(t, http.StatusOK, resp.Code)
+
+	t.Run("link of another account", func(t *testing.T) {
+		assertError(t, s.do(t, http.MethodGet, "/api/v1/links/mine", other, nil), http.StatusForbidden, "access_denied", "")
+		assertError(t, s.do(t, http.MethodPatch, "/api/v1/links/mine", other, patchLinkRequestModel{Link: &target}), http.StatusForbidden, "access_denied", "")
+		assertError(t, s.do(t, http.MethodDelete, "/api/v1/links/mine", other, nil), http.StatusForbidden, "access_denied", "")
+	})
+	t.Run("anonymous link", func(t *testing.T) {
+		assertError(t, s.do(t, http.MethodDelete, "/api/v1/links/anonymous", bearer, nil), http.StatusForbidden, "access_denied", "")
+	})
+	t.Run("missing link", func(t *testing.T) {
+		assertError(t, s.do(t, http.MethodDelete, "/api/v1/links/missing", bearer, nil), http.StatusNotFound, "link_not_found", "")
+	})
+
+	resp = s.do(t, http.MethodDelete, "/api/v1/links/mine", bearer, nil)
+	assertStatusCode(t, http.StatusNoContent, resp.Code)
+	assertError(t, s.do(t, http.MethodGet, "/api/v1/links/mine", bearer, nil), http.StatusNotFound, "link_not_found", "")
+}
+
+// TestDeleteLinkLegacy checks that the legacy route deletes only own links and points to its successor.
+func TestDeleteLinkLegacy(t *testing.T) {
+	s := newTestApi(t)
+	id, accessToken := s.signIn(t, "alice")
+	bearer := "Bearer " + accessToken
+	if _, err := s.links.StoreLink(context.Background(), domainlink.Link{LinkId: "anonymous", Link: "https://a.example"}); err != nil {
+		t.Fatal(err)
+	}
+	resp := s.do(t, http.MethodPost, "/accounts/"+id+"/", bearer, postAccountLinkRequestModel{Link: "https://a.example", Alias: "mine"})
+	assertStatusCode(t, http.StatusOK, resp.Code)
+
+	resp = s.do(t, http.MethodGet, "/accounts/"+id+"/delete/anonymous", bearer, nil)
+	assertError(t, resp, http.StatusForbidden, "access_denied", "")
+	if link := resp.Header().Get("Link"); link != `</api/v1/links/anonymous>; rel="successor-version"` {
+		t.Errorf("got Link header %q", link)
+	}
+	assertError(t, s.do(t, http.MethodGet, "/accounts/"+id+"/delete/missing", bearer, nil), http.StatusNotFound, "link_not_found", "")
+	assertError(t, s.do(t, http.MethodGet, "/accounts/other/delete/mine", bearer, nil), http.StatusForbidden, "access_denied", "")
+	assertStatusCode(t, http.StatusOK, s.do(t, http.MethodGet, "/accounts/"+id+"/delete/mine", bearer, nil).Code)
+}
+
+// downAccounts fails every lookup of an account, like a storage which is unreachable.
+type downAccounts struct {
+	domainaccount.Interface
+}
+
+func (downAccounts) GetAccountById(ctx context.Context, id string) (domainaccount.Account, error) {
+	return domainaccount.Account{}, errors.New("storage is down")
+}
+
+// TestErrorModel checks that errors are json with the request id, and that only token
+// errors are answered with 401 while a failing storage is a 500.
+func TestErrorModel(t *testing.T) {
+	s := newTestApi(t)
+	_, accessToken := s.signIn(t, "alice")
+
+	t.Run("request id", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/signup", bytes.NewReader([]byte("{a:")))
+		req.Header.Set(requestIdHeader, "abc")
+		resp := httptest.NewRecorder()
+		s.router.ServeHTTP(resp, req)
+
+		assertStatusCode(t, http.StatusBadRequest, resp.Code)
+		if contentType := resp.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("got Content-Type %q, want application/json", contentType)
+		}
+		var m errorResponseModel
+		if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Code != "invalid_json" || m.Message != errInvalidJson.Error() || m.RequestId != "abc" {
+			t.Errorf("got %+v", m)
+		}
+		if id := resp.Header().Get(requestIdHeader); id != "abc" {
+			t.Errorf("got %s %q, want abc", requestIdHeader, id)
+		}
+	})
+	t.Run("field of a validation error", func(t *testing.T) {
+		resp := s.do(t, http.MethodPost, "/signup", "", postSignupRequestModel{Login: "bobby", Password: "secret1"})
+		assertError(t, resp, http.StatusBadRequest, "no_capital_letters", "password")
+	})
+	t.Run("missing token", func(t *testing.T) {
+		assertError(t, s.do(t, http.MethodGet, "/api/v1/links", "", nil), http.StatusUnauthorized, "missing_token", "")
+	})
+	t.Run("invalid token", func(t *testing.T) {
+		assertError(t, s.do(t, http.MethodGet, "/api/v1/links", "Bearer garbage", nil), http.StatusUnauthorized, "invalid_token", "")
+	})
+	t.Run("failing storage", func(t *testing.T) {
+		storage := s.accounts.AccountStorage
+		s.accounts.AccountStorage = downAccounts{storage}
+		defer func() { s.accounts.AccountStorage = storage }()
+
+		resp := s.do(t, http.MethodGet, "/api/v1/links", "Bearer "+accessToken, nil)
+		assertError(t, resp, http.StatusInternalServerError, "internal_error", "")
+	})
+}
+
+// TestPostPage checks the password form of a protected link up to the lockout after failed attempts.
+func TestPostPage(t *testing.T) {
+	s := newTestApi(t)
+	resp := s.do(t, http.MethodPost, "/links", "", postLinkRequestModel{Link: "https://a.example", Alias: "locked", Password: "secret"})
+	assertStatusCode(t, http.StatusOK, resp.Code)
+
+	unlock := func(password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/link/locked", strings.NewReader(url.Values{"password": {password}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp := httptest.NewRecorder()
+		s.router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp = s.do(t, http.MethodGet, "/link/locked", "", nil)
+	assertStatusCode(t, http.StatusOK, resp.Code)
+	if !strings.Contains(resp.Body.String(), `name="password"`) {
+		t.Error("password form isn't shown")
+	}
+	resp = unlock("secret")
+	assertStatusCode(t, http.StatusSeeOther, resp.Code)
+	if location := resp.Header().Get("Location"); location != "https://a.example" {
+		t.Errorf("redirected to %q, want https://a.example", location)
+	}
+	for i := 0; i < 5; i++ {
+		assertStatusCode(t, http.StatusUnauthorized, unlock("wrong").Code)
+	}
+	assertStatusCode(t, http.StatusTooManyRequests, unlock("secret").Code)
+}
+
+// TestGetQrCode checks the formats of the qr code and that its options are validated.
+func TestGetQrCode(t *testing.T) {
+	s := newTestApi(t)
+	resp := s.do(t, http.MethodPost, "/links", "", postLinkRequestModel{Link: "https://a.example", Alias: "coded"})
+	assertStatusCode(t, http.StatusOK, resp.Code)
+
+	resp = s.do(t, http.MethodGet, "/link/coded/qr?size=128", "", nil)
+	assertStatusCode(t, http.StatusOK, resp.Code)
+	img, err := png.Decode(resp.Body)
+	if err != nil {
+		t.Fatalf("qr code isn't a png: %v", err)
+	}
+	if size := img.Bounds().Dx(); size != 128 {
+		t.Errorf("got %d pixels wide qr code, want 128", size)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/link/coded/qr", nil)
+	req.Header.Set("Accept", "image/svg+xml")
+	resp = httptest.NewRecorder()
+	s.router.ServeHTTP(resp, req)
+	assertStatusCode(t, http.StatusOK, resp.Code)
+	if contentType := resp.Header().Get("Content-Type"); contentType != "image/svg+xml" || !strings.Contains(resp.Body.String(), "<svg") {
+		t.Errorf("got %s instead of svg", contentType)
+	}
+
+	assertError(t, s.do(t, http.MethodGet, "/link/coded/qr?size=big", "", nil), http.StatusBadRequest, "invalid_size", "size")
+	assertError(t, s.do(t, http.MethodGet, "/link/coded/qr?size=100000", "", nil), http.StatusBadRequest, "invalid_size", "size")
+	assertError(t, s.do(t, http.MethodGet, "/link/coded/qr?level=x", "", nil), http.StatusBadRequest, "invalid_level", "level")
+	assertError(t, s.do(t, http.MethodGet, "/link/coded/qr?format=gif", "", nil), http.StatusBadRequest, "invalid_format", "format")
+	assertError(t, s.do(t, http.MethodGet, "/link/missing/qr", "", nil), http.StatusNotFound, "link_not_found", "")
+}
+
+// TestShortUrl checks that short urls use the configured public url and don't trust
+// X-Forwarded-Proto unless the server is behind a proxy.
+func TestShortUrl(t *testing.T) {
+	s := newTestApi(t)
+	req := httptest.NewRequest(http.MethodGet, "/link/coded/qr", nil)
+	req.Host = "forged.example"
+	req.Header.Set("X-Forwarded-Proto", "https")
+
+	if u := s.shortUrl(req, "coded"); u != "http://forged.example/link/coded" {
+		t.Errorf("without a proxy got %s, want http", u)
+	}
+	s.ClientIpHeader = "X-Forwarded-For"
+	if u := s.shortUrl(req, "coded"); u != "https://forged.example/link/coded" {
+		t.Errorf("behind a proxy got %s, want https", u)
+	}
+	s.PublicUrl = "https://lenke.example/"
+	if u := s.shortUrl(req, "coded"); u != "https://lenke.example/link/coded" {
+		t.Errorf("with a public url got %s, want https://lenke.example/link/coded", u)
+	}
+}
+
+// TestRefreshTokenReuse checks the refresh of tokens and the response to a reused refresh token.
+func TestRefreshTokenReuse(t *testing.T) {
+	s := newTestApi(t)
+	if _, err := s.accounts.CreateAccount(context.Background(), "alice", testPassword); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/signin", strings.NewReader(`{"login": "alice", "password": "`+testPassword+`"}`))
+	req.Header.Set("Accept", "application/json")
+	resp := httptest.NewRecorder()
+	s.router.ServeHTTP(resp, req)
+	assertStatusCode(t, http.StatusOK, resp.Code)
+	var signin tokensResponseModel
+	if err := json.NewDecoder(resp.Body).Decode(&signin); err != nil {
+		t.Fatal(err)
+	}
+	if signin.TokenType != "Bearer" || signin.RefreshToken == "" || signin.ExpiresIn != int64(time.Hour/time.Second) {
+		t.Errorf("got tokens %+v", signin)
+	}
+
+	resp = s.do(t, http.MethodPost, "/token/refresh", "", refreshTokenRequestModel{RefreshToken: signin.RefreshToken})
+	assertStatusCode(t, http.StatusOK, resp.Code)
+	resp = s.do(t, http.MethodPost, "/token/refresh", "", refreshTokenRequestModel{RefreshToken: signin.RefreshToken})
+	assertError(t, resp, http.StatusUnauthorized, "refresh_token_reused", "refresh_token")
+	resp = s.do(t, http.MethodGet, "/api/v1/links", "Bearer "+signin.AccessToken, nil)
+	assertError(t, resp, http.StatusUnauthorized, "invalid_token", "")
+}
+
+// TestGetJwks checks that the published key verifies the tokens, found by their kid.
+func TestGetJwks(t *testing.T) {
+	s := newTestApi(t)
+	resp := s.do(t, http.MethodGet, "/.well-known/jwks.json", "", nil)
+	assertStatusCode(t, http.StatusOK, resp.Code)
+	if cacheControl := resp.Header().Get("Cache-Control"); cacheControl != "public, max-age=300" {
+		t.Errorf("got Cache-Control %q", cacheControl)
+	}
+	var jwks jwksResponseModel
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 1 {
+		t.Fatalf("got %d keys, want 1", len(jwks.Keys))
+	}
+	k := jwks.Keys[0]
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	if k.Kty != "RSA" || k.Alg != "RS256" || k.Use != "sig" || !publicKey.Equal(&testKey.PublicKey) {
+		t.Fatalf("got key %+v", k)
+	}
+
+	_, accessToken := s.signIn(t, "alice")
+	parsed, err := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != k.Kid {
+			return nil, fmt.Errorf("token is signed by %v, not %s", token.Header["kid"], k.Kid)
+		}
+		return publicKey, nil
+	})
+	if err != nil || !parsed.Valid {
+		t.Errorf("token isn't verified by the published key: %v", err)
+	}
+}
+
+// TestApiKeyScopes checks that an api key is let only into the routes of its scopes and never manages keys.
+func TestApiKeyScopes(t *testing.T) {
+	s := newTestApi(t)
+	_, accessToken := s.signIn(t, "alice")
+	bearer := "Bearer " + accessToken
+
+	resp := s.do(t, http.MethodPost, "/api/v1/keys", bearer, postApiKeyRequestModel{Name: "ci", Scopes: []string{"links:admin"}})
+	assertError(t, resp, http.StatusBadRequest, "invalid_scope", "scopes")
+
+	resp = s.do(t, http.MethodPost, "/api/v1/keys", bearer, postApiKeyRequestModel{Name: "ci", Scopes: []string{account.ScopeLinksRead}})
+	assertStatusCode(t, http.StatusCreated, resp.Code)
+	var k apiKeyResponseModel
+	if err := json.NewDecoder(resp.Body).Decode(&k); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(k.Key, k.Prefix) || len(k.Scopes) != 1 || k.Scopes[0] != account.ScopeLinksRead {
+		t.Fatalf("got key %+v", k)
+	}
+	apiKey := "ApiKey " + k.Key
+
+	assertStatusCode(t, http.StatusOK, s.do(t, http.MethodGet, "/api/v1/links", apiKey, nil).Code)
+	resp = s.do(t, http.MethodPost, "/api/v1/links", apiKey, postLinkRequestModel{Link: "https://a.example"})
+	assertError(t, resp, http.StatusForbidden, "insufficient_scope", "")
+	resp = s.do(t, http.MethodPost, "/api/v1/keys", apiKey, postApiKeyRequestModel{Name: "more"})
+	assertError(t, resp, http.StatusForbidden, "session_required", "")
+	resp = s.do(t, http.MethodDelete, "/api/v1/account", apiKey, deleteAccountRequestModel{Password: testPassword})
+	assertError(t, resp, http.StatusForbidden, "session_required", "")
+
+	resp = s.do(t, http.MethodGet, "/api/v1/keys", bearer, nil)
+	assertStatusCode(t, http.StatusOK, resp.Code)
+	var keys getApiKeysResponseModel
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys.Keys) != 1 || keys.Keys[0].Key != "" || keys.Keys[0].LastUsedAt == nil {
+		t.Errorf("got keys %+v, want the used key without its secret", keys.Keys)
+	}
+
+	assertStatusCode(t, http.StatusNoContent, s.do(t, http.MethodDelete, "/api/v1/keys/"+k.Id, bearer, nil).Code)
+	resp = s.do(t, http.MethodGet, "/api/v1/links", apiKey, nil)
+	assertError(t, resp, http.StatusUnauthorized, "invalid_api_key", "")
+}
+
+// TestAccountV1 checks the responses of the password change and the account deletion.
+func TestAccountV1(t *testing.T) {
+	s := newTestApi(t)
+	_, accessToken := s.signIn(t, "alice")
+	bearer := "Bearer " + accessToken
+
+	resp := s.do(t, http.MethodPut, "/api/v1/account/password", bearer, putPasswordRequestModel{CurrentPassword: "Wrong123", NewPassword: "Secret456"})
+	assertError(t, resp, http.StatusForbidden, "wrong_password", "current_password")
+	resp = s.do(t, http.MethodPut, "/api/v1/account/password", bearer, putPasswordRequestModel{CurrentPassword: testPassword, NewPassword: "Secret456"})
+	assertStatusCode(t, http.StatusNoContent, resp.Code)
+	assertError(t, s.do(t, http.MethodGet, "/api/v1/links", bearer, nil), http.StatusUnauthorized, "invalid_token", "")
+
+	tokens, err := s.accounts.LoginToAccount(context.Background(), "alice", "Secret456", "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer = "Bearer " + tokens.AccessToken
+	resp = s.do(t, http.MethodDelete, "/api/v1/account", bearer, deleteAccountRequestModel{Password: testPassword})
+	assertError(t, resp, http.StatusForbidden, "wrong_password", "password")
+	resp = s.do(t, http.MethodDelete, "/api/v1/account", bearer, deleteAccountRequestModel{Password: "Secret456"})
+	assertStatusCode(t, http.StatusNoContent, resp.Code)
+	assertError(t, s.do(t, http.MethodGet, "/api/v1/links", bearer, nil), http.StatusUnauthorized, "invalid_token", "")
+}
+
+// TestSigninLockout checks that a locked sign in is answered with 429 and Retry-After,
+// and that behind a proxy the address it appended is the one locked.
+func TestSigninLockout(t *testing.T) {
+	s := newTestApi(t)
+	s.ClientIpHeader = "X-Forwarded-For"
+	if _, err := s.accounts.CreateAccount(context.Background(), "alice", testPassword); err != nil {
+		t.Fatal(err)
+	}
+	signin := func(password, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/signin", strings.NewReader(`{"login": "alice", "password": "`+password+`"}`))
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		resp := httptest.NewRecorder()
+		s.router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	for i := 0; i < 6; i++ {
+		assertError(t, signin("Wrong123", "203.0.113.7"), http.StatusUnauthorized, "invalid_credentials", "")
+	}
+	// the lock of the failures is a second long, a longer one keeps the test away from its end
+	ctx := context.Background()
+	prev, err := s.accounts.LoginAttemptStorage.GetAttempts(ctx, "login:alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := prev
+	until := time.Now().Add(time.Minute)
+	next.LockedUntil = &until
+	if ok, err := s.accounts.LoginAttemptStorage.SwapAttempts(ctx, "login:alice", prev.Failures, next); err != nil || !ok {
+		t.Fatalf("lock isn't set: %v, %v", ok, err)
+	}
+	resp := signin(testPassword, "203.0.113.7")
+	if retryAfter := resp.Header().Get("Retry-After"); retryAfter != "60" {
+		t.Errorf("got Retry-After %q, want 60", retryAfter)
+	}
+	assertError(t, resp, http.StatusTooManyRequests, "too_many_attempts", "")
+
+	if err := s.accounts.LoginAttemptStorage.ResetAttempts(ctx, "login:alice"); err != nil {
+		t.Fatal(err)
+	}
+	until = time.Now().Add(time.Minute)
+	if ok, err := s.accounts.LoginAttemptStorage.SwapAttempts(ctx, "ip:203.0.113.7", 6, loginattempt.Attempts{Failures: 6, LockedUntil: &until}); err != nil || !ok {
+		t.Fatalf("lock isn't set: %v, %v", ok, err)
+	}
+	assertError(t, signin(testPassword, "198.51.100.1, 203.0.113.7"), http.StatusTooManyRequests, "too_many_attempts", "")
+	// the first address is sent by the client and can't be trusted
+	assertStatusCode(t, http.StatusOK, signin(testPassword, "203.0.113.7, 198.51.100.1").Code)
+}
@@ -0,0 +1,439 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/account"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/link"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type linkResponseModel struct {
+	Id        string     `json:"id"`
+	ShortUrl  string     `json:"short_url"`
+	Target    string     `json:"target"`
+	Status    string     `json:"status"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxClicks *int64     `json:"max_clicks,omitempty"`
+	Clicks    int64      `json:"clicks"`
+	Expired   bool       `json:"expired"`
+	Protected bool       `json:"protected"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+type getLinksResponseModel struct {
+	Links []linkResponseModel `json:"links"`
+}
+
+type patchLinkRequestModel struct {
+	Link      *string    `json:"link"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	MaxClicks *int64     `json:"max_clicks"`
+}
+
+// postLinkV1 handles creation of a short link owned by the caller.
+func (a *Api) postLinkV1(w http.ResponseWriter, r *http.Request) {
+	aid, ok := r.Context().Value("account_id").(string)
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	var m postLinkRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		a.writeError(w, r, errInvalidJson)
+		return
+	}
+
+	linkId, err := a.LinkUseCases.LoggerCutLink(a.LinkUseCases.CutLink)(r.Context(), m.Link, &aid, link.CutLinkOptions{
+		Alias:     m.Alias,
+		ExpiresAt: m.ExpiresAt,
+		MaxClicks: m.MaxClicks,
+		Password:  m.Password,
+		Reuse:     m.Reuse,
+	})
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	l, err := a.LinkUseCases.LoggerGetLink(a.LinkUseCases.GetLink)(r.Context(), linkId, aid)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/links/%s", linkId))
+	writeJson(w, http.StatusCreated, a.toLinkResponseModel(r, l))
+}
+
+// getLinksV1 handles request for all links of the caller.
+func (a *Api) getLinksV1(w http.ResponseWriter, r *http.Request) {
+	aid, ok := r.Context().Value("account_id").(string)
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	links, err := a.LinkUseCases.LoggerGetLinksByAccountId(a.LinkUseCases.GetLinksByAccountId)(r.Context(), aid)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	ret := getLinksResponseModel{Links: make([]linkResponseModel, 0, len(links))}
+	for _, l := range links {
+		ret.Links = append(ret.Links, a.toLinkResponseModel(r, l))
+	}
+	writeJson(w, http.StatusOK, ret)
+}
+
+// getLinkV1 handles request for a single link of the caller.
+func (a *Api) getLinkV1(w http.ResponseWriter, r *http.Request) {
+	aid, ok := r.Context().Value("account_id").(string)
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+	linkId, ok := mux.Vars(r)["link_id"]
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	l, err := a.LinkUseCases.LoggerGetLink(a.LinkUseCases.GetLink)(r.Context(), linkId, aid)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	writeJson(w, http.StatusOK, a.toLinkResponseModel(r, l))
+}
+
+// patchLinkV1 handles changing attributes of a link of the caller.
+func (a *Api) patchLinkV1(w http.ResponseWriter, r *http.Request) {
+	aid, ok := r.Context().Value("account_id").(string)
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+	linkId, ok := mux.Vars(r)["link_id"]
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	var m patchLinkRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		a.writeError(w, r, errInvalidJson)
+		return
+	}
+
+	l, err := a.LinkUseCases.LoggerUpdateLink(a.LinkUseCases.UpdateLink)(r.Context(), linkId, aid, link.LinkUpdate{
+		Link:      m.Link,
+		ExpiresAt: m.ExpiresAt,
+		MaxClicks: m.MaxClicks,
+	})
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	writeJson(w, http.StatusOK, a.toLinkResponseModel(r, l))
+}
+
+// deleteLinkV1 handles deletion of a link of the caller.
+func (a *Api) deleteLinkV1(w http.ResponseWriter, r *http.Request) {
+	aid, ok := r.Context().Value("account_id").(string)
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+	linkId, ok := mux.Vars(r)["link_id"]
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	if err := a.LinkUseCases.LoggerDeleteLink(a.LinkUseCases.DeleteLink)(r.Context(), linkId, aid); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getLinkStatsV1 handles request for click statistics of a link of the caller.
+func (a *Api) getLinkStatsV1(w http.ResponseWriter, r *http.Request) {
+	aid, ok := r.Context().Value("account_id").(string)
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+	linkId, ok := mux.Vars(r)["link_id"]
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	a.writeLinkStats(w, r, linkId, aid)
+}
+
+type revisionResponseModel struct {
+	Id        int64     `json:"id"`
+	Target    string    `json:"target"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+type getLinkRevisionsResponseModel struct {
+	Revisions []revisionResponseModel `json:"revisions"`
+}
+
+// getLinkRevisionsV1 handles request for previous destinations of a link of the caller.
+func (a *Api) getLinkRevisionsV1(w http.ResponseWriter, r *http.Request) {
+	aid, ok := r.Context().Value("account_id").(string)
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+	linkId, ok := mux.Vars(r)["link_id"]
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	revisions, err := a.LinkUseCases.LoggerGetLinkRevisions(a.LinkUseCases.GetLinkRevisions)(r.Context(), linkId, aid)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	ret := getLinkRevisionsResponseModel{Revisions: make([]revisionResponseModel, 0, len(revisions))}
+	for _, rev := range revisions {
+		ret.Revisions = append(ret.Revisions, revisionResponseModel{
+			Id:        rev.Id,
+			Target:    rev.Link,
+			ChangedAt: rev.ChangedAt,
+		})
+	}
+	writeJson(w, http.StatusOK, ret)
+}
+
+// postRollbackLinkV1 handles restoring a previous destination of a link of the caller.
+func (a *Api) postRollbackLinkV1(w http.ResponseWriter, r *http.Request) {
+	aid, ok := r.Context().Value("account_id").(string)
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+	vars := mux.Vars(r)
+	linkId, ok := vars["link_id"]
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+	revisionId, err := strconv.ParseInt(vars["revision_id"], 10, 64)
+	if err != nil {
+		a.writeError(w, r, link.ErrRevisionNotFound)
+		return
+	}
+
+	l, err := a.LinkUseCases.LoggerRollbackLink(a.LinkUseCases.RollbackLink)(r.Context(), linkId, aid, revisionId)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	writeJson(w, http.StatusOK, a.toLinkResponseModel(r, l))
+}
+
+type postApiKeyRequestModel struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type apiKeyResponseModel struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// Key is only sent once, in the response to the creation.
+	Key string `json:"key,omitempty"`
+}
+
+type getApiKeysResponseModel struct {
+	Keys []apiKeyResponseModel `json:"keys"`
+}
+
+// postApiKeyV1 handles creation of an api key of the caller, the key is shown only in this response.
+func (a *Api) postApiKeyV1(w http.ResponseWriter, r *http.Request) {
+	aid, ok := r.Context().Value("account_id").(string)
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	var m postApiKeyRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		a.writeError(w, r, errInvalidJson)
+		return
+	}
+
+	k, err := a.AccountUseCases.LoggerCreateApiKey(a.AccountUseCases.CreateApiKey)(r.Context(), aid, m.Name, m.Scopes, m.ExpiresAt)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	ret := toApiKeyResponseModel(k.ApiKey)
+	ret.Key = k.Key
+	w.Header().Set("Cache-Control", "no-store")
+	writeJson(w, http.StatusCreated, ret)
+}
+
+// getApiKeysV1 handles request for the api keys of the caller.
+func (a *Api) getApiKeysV1(w http.ResponseWriter, r *http.Request) {
+	aid, ok := r.Context().Value("account_id").(string)
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	keys, err := a.AccountUseCases.LoggerGetApiKeys(a.AccountUseCases.GetApiKeys)(r.Context(), aid)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+
+	ret := getApiKeysResponseModel{Keys: make([]apiKeyResponseModel, 0, len(keys))}
+	for _, k := range keys {
+		ret.Keys = append(ret.Keys, toApiKeyResponseModel(k))
+	}
+	writeJson(w, http.StatusOK, ret)
+}
+
+// deleteApiKeyV1 handles revocation of an api key of the caller.
+func (a *Api) deleteApiKeyV1(w http.ResponseWriter, r *http.Request) {
+	aid, ok := r.Context().Value("account_id").(string)
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+	keyId, ok := mux.Vars(r)["key_id"]
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	if err := a.AccountUseCases.LoggerRevokeApiKey(a.AccountUseCases.RevokeApiKey)(r.Context(), aid, keyId); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type putPasswordRequestModel struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type deleteAccountRequestModel struct {
+	Password string `json:"password"`
+}
+
+// putPasswordV1 handles changing the password of the caller, who has to sign in again afterwards.
+func (a *Api) putPasswordV1(w http.ResponseWriter, r *http.Request) {
+	aid, ok := r.Context().Value("account_id").(string)
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	var m putPasswordRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		a.writeError(w, r, errInvalidJson)
+		return
+	}
+
+	err := a.AccountUseCases.LoggerUpdatePassword(a.AccountUseCases.UpdatePassword)(r.Context(), aid, m.CurrentPassword, m.NewPassword)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteAccountV1 handles deletion of the account of the caller, confirmed by its password.
+func (a *Api) deleteAccountV1(w http.ResponseWriter, r *http.Request) {
+	aid, ok := r.Context().Value("account_id").(string)
+	if !ok {
+		a.writeError(w, r, errInternal)
+		return
+	}
+
+	var m deleteAccountRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		a.writeError(w, r, errInvalidJson)
+		return
+	}
+
+	if err := a.AccountUseCases.LoggerDeleteAccount(a.AccountUseCases.DeleteAccount)(r.Context(), aid, m.Password); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toApiKeyResponseModel(k account.ApiKey) apiKeyResponseModel {
+	return apiKeyResponseModel{
+		Id:         k.Id,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		CreatedAt:  k.CreatedAt,
+	}
+}
+
+func (a *Api) toLinkResponseModel(r *http.Request, l link.Link) linkResponseModel {
+	return linkResponseModel{
+		Id:        l.LinkId,
+		ShortUrl:  a.shortUrl(r, l.LinkId),
+		Target:    l.Link,
+		Status:    l.LinkStatus.String(),
+		ExpiresAt: l.ExpiresAt,
+		MaxClicks: l.MaxClicks,
+		Clicks:    l.Clicks,
+		Expired:   l.Expired,
+		Protected: l.Protected,
+		CreatedAt: l.CreatedAt,
+		UpdatedAt: l.UpdatedAt,
+	}
+}
+
+// shortUrl builds the absolute redirect url of the link from PublicUrl. Without it the url is
+// built from the request host, and X-Forwarded-Proto is only trusted behind a configured proxy.
+func (a *Api) shortUrl(r *http.Request, linkId string) string {
+	if a.PublicUrl != "" {
+		return fmt.Sprintf("%s/link/%s", strings.TrimSuffix(a.PublicUrl, "/"), linkId)
+	}
+	scheme := "http"
+	if r.TLS != nil || a.ClientIpHeader != "" && r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/link/%s", scheme, r.Host, linkId)
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,137 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	domainaccount "github.com/mp-hl-2021/lenkeforkortelse/internal/domain/account"
+	domainapikey "github.com/mp-hl-2021/lenkeforkortelse/internal/domain/apikey"
+	domainlink "github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/service/qr"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/service/token"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/account"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/link"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	errInvalidJson    = errors.New("malformed json body")
+	errMissingToken   = errors.New("missing bearer token or api key")
+	errForeignAccount = errors.New("account doesn't match the token")
+	errInternal       = errors.New("internal error")
+	errInvalidFormat  = errors.New("unsupported image format")
+	errMissingScope   = errors.New("api key lacks the scope required by the route")
+	errSessionOnly    = errors.New("api keys can't be used here, sign in instead")
+)
+
+type errorResponseModel struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Field     string `json:"field,omitempty"`
+	RequestId string `json:"request_id"`
+}
+
+// apiError describes how an error is presented to clients.
+type apiError struct {
+	status int
+	code   string
+	field  string
+	// message replaces the text of the error if it's too vague for clients
+	message string
+}
+
+// apiErrors maps every error a client can cause to its response, anything else is a 500.
+// The first target the error matches wins, so an error wrapping another one goes before it.
+var apiErrors = []struct {
+	target error
+	apiError
+}{
+	{errInvalidJson, apiError{http.StatusBadRequest, "invalid_json", "", ""}},
+	{errMissingToken, apiError{http.StatusUnauthorized, "missing_token", "", ""}},
+	{token.ErrInvalidToken, apiError{http.StatusUnauthorized, "invalid_token", "", ""}},
+	{token.ErrRevokedToken, apiError{http.StatusUnauthorized, "invalid_token", "", "invalid or expired token"}},
+	{errForeignAccount, apiError{http.StatusForbidden, "access_denied", "", ""}},
+	{errMissingScope, apiError{http.StatusForbidden, "insufficient_scope", "", ""}},
+	{errSessionOnly, apiError{http.StatusForbidden, "session_required", "", ""}},
+
+	{account.ErrInvalidLoginString, apiError{http.StatusBadRequest, "invalid_characters", "login", ""}},
+	{account.ErrInvalidPasswordString, apiError{http.StatusBadRequest, "invalid_characters", "password", ""}},
+	{account.ErrTooShortString, apiError{http.StatusBadRequest, "too_short", "", ""}},
+	{account.ErrTooLongString, apiError{http.StatusBadRequest, "too_long", "", ""}},
+	{account.ErrNoCapitalLetters, apiError{http.StatusBadRequest, "no_capital_letters", "password", ""}},
+	{account.ErrNoDigits, apiError{http.StatusBadRequest, "no_digits", "password", ""}},
+	{account.ErrInvalidCredentials, apiError{http.StatusUnauthorized, "invalid_credentials", "", ""}},
+	{account.ErrInvalidRefreshToken, apiError{http.StatusUnauthorized, "invalid_refresh_token", "refresh_token", ""}},
+	{account.ErrRefreshTokenReused, apiError{http.StatusUnauthorized, "refresh_token_reused", "refresh_token", ""}},
+	{account.ErrInvalidScope, apiError{http.StatusBadRequest, "invalid_scope", "scopes", ""}},
+	{account.ErrExpirationInPast, apiError{http.StatusBadRequest, "expiration_in_past", "expires_at", ""}},
+	{account.ErrInvalidApiKey, apiError{http.StatusUnauthorized, "invalid_api_key", "", ""}},
+	{account.ErrWrongPassword, apiError{http.StatusForbidden, "wrong_password", "", ""}},
+	{account.ErrTooManyAttempts, apiError{http.StatusTooManyRequests, "too_many_attempts", "", ""}},
+	{domainaccount.ErrAlreadyExist, apiError{http.StatusConflict, "login_taken", "login", "login is already taken"}},
+	{domainaccount.ErrNotFound, apiError{http.StatusNotFound, "account_not_found", "", "account not found"}},
+	{domainapikey.ErrNotFound, apiError{http.StatusNotFound, "api_key_not_found", "", "api key not found"}},
+
+	{qr.ErrInvalidSize, apiError{http.StatusBadRequest, "invalid_size", "size", ""}},
+	{qr.ErrInvalidMargin, apiError{http.StatusBadRequest, "invalid_margin", "margin", ""}},
+	{qr.ErrInvalidLevel, apiError{http.StatusBadRequest, "invalid_level", "level", ""}},
+	{errInvalidFormat, apiError{http.StatusBadRequest, "invalid_format", "format", ""}},
+
+	{link.ErrEmptyUrl, apiError{http.StatusBadRequest, "empty_link", "link", ""}},
+	{link.ErrTooLongUrl, apiError{http.StatusBadRequest, "too_long", "link", ""}},
+	{link.ErrInvalidUrl, apiError{http.StatusBadRequest, "invalid_url", "link", ""}},
+	{link.ErrUnsupportedUrlScheme, apiError{http.StatusBadRequest, "unsupported_scheme", "link", ""}},
+	{link.ErrMissingUrlHost, apiError{http.StatusBadRequest, "missing_host", "link", ""}},
+	{link.ErrInvalidUrlHost, apiError{http.StatusBadRequest, "invalid_host", "link", ""}},
+	{link.ErrInvalidAlias, apiError{http.StatusBadRequest, "invalid_characters", "alias", ""}},
+	{link.ErrTooShortAlias, apiError{http.StatusBadRequest, "too_short", "alias", ""}},
+	{link.ErrTooLongAlias, apiError{http.StatusBadRequest, "too_long", "alias", ""}},
+	{link.ErrReservedAlias, apiError{http.StatusBadRequest, "reserved_alias", "alias", ""}},
+	{link.ErrExpirationInPast, apiError{http.StatusBadRequest, "expiration_in_past", "expires_at", ""}},
+	{link.ErrInvalidMaxClicks, apiError{http.StatusBadRequest, "invalid_max_clicks", "max_clicks", ""}},
+	{link.ErrPasswordRequired, apiError{http.StatusUnauthorized, "password_required", "password", ""}},
+	{link.ErrWrongPassword, apiError{http.StatusUnauthorized, "wrong_password", "password", ""}},
+	{link.ErrTooLongPassword, apiError{http.StatusBadRequest, "too_long", "password", ""}},
+	{link.ErrTooManyAttempts, apiError{http.StatusTooManyRequests, "too_many_attempts", "", ""}},
+	{link.ErrRevisionNotFound, apiError{http.StatusNotFound, "revision_not_found", "", ""}},
+	{domainlink.ErrAlreadyExist, apiError{http.StatusConflict, "alias_taken", "alias", "alias is already taken"}},
+	{domainlink.ErrAccessDenied, apiError{http.StatusForbidden, "access_denied", "", ""}},
+	{domainlink.ErrNotFound, apiError{http.StatusNotFound, "link_not_found", "", "link not found"}},
+	{domainlink.ErrExpired, apiError{http.StatusGone, "link_expired", "", "link has expired"}},
+}
+
+// writeError responds with the json representation of err.
+func (a *Api) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	resp := errorResponseModel{
+		Code:      "internal_error",
+		Message:   errInternal.Error(),
+		RequestId: requestId(r),
+	}
+	status := http.StatusInternalServerError
+	for _, e := range apiErrors {
+		if errors.Is(err, e.target) {
+			status = e.status
+			resp.Code = e.code
+			resp.Message = e.target.Error()
+			if e.message != "" {
+				resp.Message = e.message
+			}
+			resp.Field = e.field
+			break
+		}
+	}
+	var fieldErr *account.FieldError
+	if errors.As(err, &fieldErr) {
+		resp.Field = fieldErr.Field
+	}
+	var throttledErr *account.ThrottledError
+	if errors.As(err, &throttledErr) {
+		// whole seconds, rounded up so that a client waiting exactly that long isn't turned away
+		w.Header().Set("Retry-After", strconv.Itoa(int((throttledErr.RetryAfter+time.Second-1)/time.Second)))
+	}
+	if status == http.StatusInternalServerError {
+		fmt.Printf("request-id: %s; error: %v;\n", resp.RequestId, err)
+	}
+	writeJson(w, status, resp)
+}
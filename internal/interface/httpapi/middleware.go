@@ -2,24 +2,40 @@ package httpapi
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"github.com/gorilla/mux"
 	"net/http"
 	"strings"
 	"time"
 )
 
+// authenticate resolves the account of a bearer token or an api key, "Authorization: ApiKey <key>".
+// Scopes of the api key are kept in the context for requireScope.
 func (a *Api) authenticate(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		bearHeader := r.Header.Get("Authorization")
 		strArr := strings.Split(bearHeader, " ")
 		if len(strArr) != 2 {
-			w.WriteHeader(http.StatusBadRequest)
+			a.writeError(w, r, errMissingToken)
 			return
 		}
 		token := strArr[1]
-		id, err := a.AccountUseCases.LoggerAuthenticate(a.AccountUseCases.Authenticate)(token)
+		if strings.EqualFold(strArr[0], "ApiKey") {
+			p, err := a.AccountUseCases.LoggerAuthenticateApiKey(a.AccountUseCases.AuthenticateApiKey)(r.Context(), token)
+			if err != nil {
+				a.writeError(w, r, err)
+				return
+			}
+			ctx := context.WithValue(r.Context(), "account_id", p.AccountId)
+			ctx = context.WithValue(ctx, "api_key_scopes", p.Scopes)
+			handler(w, r.WithContext(ctx))
+			return
+		}
+		id, err := a.AccountUseCases.LoggerAuthenticate(a.AccountUseCases.Authenticate)(r.Context(), token)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+			a.writeError(w, r, err)
 			return
 		}
 		ctx := context.WithValue(r.Context(), "account_id", id)
@@ -27,9 +43,80 @@ func (a *Api) authenticate(handler http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// requireScope lets an api key through only if it's granted the scope, a signed in user has every scope.
+func (a *Api) requireScope(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scopes, ok := r.Context().Value("api_key_scopes").([]string)
+		if ok && !hasScope(scopes, scope) {
+			a.writeError(w, r, errMissingScope)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// requireSession turns api keys away, so that a leaked key can't be used to create more.
+func (a *Api) requireSession(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value("api_key_scopes").([]string); ok {
+			a.writeError(w, r, errSessionOnly)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// requestIdHeader carries the id which ties a response to the server logs.
+const requestIdHeader = "X-Request-Id"
+
+// tagRequest assigns an id to every request, reusing the one sent by the client or a proxy.
+func (a *Api) tagRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIdHeader)
+		if id == "" || len(id) > 64 {
+			b := make([]byte, 8)
+			if _, err := rand.Read(b); err != nil {
+				fmt.Println(err)
+			}
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(requestIdHeader, id)
+		ctx := context.WithValue(r.Context(), "request_id", id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func requestId(r *http.Request) string {
+	id, _ := r.Context().Value("request_id").(string)
+	return id
+}
+
+// deprecated marks responses of a legacy route, pointing clients to its successor.
+// Route variables in the successor template are filled from the request.
+func deprecated(successor string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		location := successor
+		for k, v := range mux.Vars(r) {
+			location = strings.ReplaceAll(location, "{"+k+"}", v)
+		}
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", location))
+		handler(w, r)
+	}
+}
+
 type responseWriterObserver struct {
 	http.ResponseWriter
-	status int
+	status      int
 	wroteHeader bool
 }
 
@@ -54,7 +141,7 @@ func (a *Api) logger(next http.Handler) http.Handler {
 		start := time.Now()
 		o := &responseWriterObserver{ResponseWriter: w}
 		next.ServeHTTP(o, r)
-		fmt.Printf("method: %s; status-code: %d; url: %s; remote-addr: %s; request call time: %v; duration: %v;\n",
-			r.Method, o.StatusCode(), r.URL.String(), r.RemoteAddr, start, time.Since(start))
+		fmt.Printf("method: %s; status-code: %d; url: %s; remote-addr: %s; request-id: %s; request call time: %v; duration: %v;\n",
+			r.Method, o.StatusCode(), r.URL.String(), r.RemoteAddr, requestId(r), start, time.Since(start))
 	})
-}
\ No newline at end of file
+}
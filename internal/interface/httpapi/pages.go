@@ -0,0 +1,34 @@
+package httpapi
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+var passwordFormTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Protected link</title>
+</head>
+<body>
+	<form method="post">
+		<p>This link is protected by password.</p>
+		{{if .}}<p>{{.}}</p>{{end}}
+		<input type="password" name="password" autofocus required>
+		<button type="submit">Open</button>
+	</form>
+</body>
+</html>
+`))
+
+// writePasswordForm renders the page asking for the password of a protected link.
+func writePasswordForm(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := passwordFormTemplate.Execute(w, message); err != nil {
+		fmt.Println(err)
+	}
+}
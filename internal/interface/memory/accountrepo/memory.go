@@ -1,6 +1,7 @@
 package accountrepo
 
 import (
+	"context"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/account"
 	"strconv"
 	"sync"
@@ -21,7 +22,7 @@ func NewMemory() *Memory {
 	}
 }
 
-func (m *Memory) CreateAccount(cred account.Credentials) (account.Account, error) {
+func (m *Memory) CreateAccount(ctx context.Context, cred account.Credentials) (account.Account, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if _, ok := m.accountsByLogin[cred.Login]; ok {
@@ -37,7 +38,7 @@ func (m *Memory) CreateAccount(cred account.Credentials) (account.Account, error
 	return a, nil
 }
 
-func (m *Memory) GetAccountById(id string) (account.Account, error) {
+func (m *Memory) GetAccountById(ctx context.Context, id string) (account.Account, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	a, ok := m.accountsById[id]
@@ -47,7 +48,7 @@ func (m *Memory) GetAccountById(id string) (account.Account, error) {
 	return a, nil
 }
 
-func (m *Memory) GetAccountByLogin(login string) (account.Account, error) {
+func (m *Memory) GetAccountByLogin(ctx context.Context, login string) (account.Account, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	a, ok := m.accountsByLogin[login]
@@ -56,3 +57,29 @@ func (m *Memory) GetAccountByLogin(login string) (account.Account, error) {
 	}
 	return a, nil
 }
+
+func (m *Memory) UpdatePassword(ctx context.Context, id, password string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.accountsById[id]
+	if !ok {
+		return account.ErrNotFound
+	}
+	a.Password = password
+	m.accountsById[a.Id] = a
+	m.accountsByLogin[a.Login] = a
+	return nil
+}
+
+// DeleteAccount leaves links to the link storage, memory storages aren't bound by foreign keys.
+func (m *Memory) DeleteAccount(ctx context.Context, id string, disownLinks bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.accountsById[id]
+	if !ok {
+		return account.ErrNotFound
+	}
+	delete(m.accountsById, a.Id)
+	delete(m.accountsByLogin, a.Login)
+	return nil
+}
@@ -0,0 +1,93 @@
+package apikeyrepo
+
+import (
+	"context"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/apikey"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Memory struct {
+	keysById  map[string]apikey.ApiKey
+	idsByHash map[string]string
+	mu        *sync.Mutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		keysById:  make(map[string]apikey.ApiKey),
+		idsByHash: make(map[string]string),
+		mu:        &sync.Mutex{},
+	}
+}
+
+func (m *Memory) StoreApiKey(ctx context.Context, k apikey.ApiKey) (apikey.ApiKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k.CreatedAt = time.Now()
+	m.keysById[k.Id] = k
+	m.idsByHash[k.Hash] = k.Id
+	return k, nil
+}
+
+func (m *Memory) GetApiKeyByHash(ctx context.Context, hash string) (apikey.ApiKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, ok := m.idsByHash[hash]
+	if !ok {
+		return apikey.ApiKey{}, apikey.ErrNotFound
+	}
+	return m.keysById[id], nil
+}
+
+func (m *Memory) GetApiKeysByAccountId(ctx context.Context, accountId string) ([]apikey.ApiKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []apikey.ApiKey
+	for _, k := range m.keysById {
+		if k.AccountId == accountId {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (m *Memory) DeleteApiKey(ctx context.Context, id, accountId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.keysById[id]
+	if !ok || k.AccountId != accountId {
+		return apikey.ErrNotFound
+	}
+	delete(m.keysById, id)
+	delete(m.idsByHash, k.Hash)
+	return nil
+}
+
+func (m *Memory) DeleteApiKeysByAccountId(ctx context.Context, accountId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, k := range m.keysById {
+		if k.AccountId == accountId {
+			delete(m.keysById, id)
+			delete(m.idsByHash, k.Hash)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) TouchApiKey(ctx context.Context, id string, usedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.keysById[id]
+	if !ok {
+		return apikey.ErrNotFound
+	}
+	k.LastUsedAt = &usedAt
+	m.keysById[id] = k
+	return nil
+}
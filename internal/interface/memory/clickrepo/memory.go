@@ -0,0 +1,107 @@
+package clickrepo
+
+import (
+	"context"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/click"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Links is the storage of the links clicks are made on.
+type Links interface {
+	CheckIfLinkExists(ctx context.Context, linkId string) (bool, error)
+	OnDelete(f func(linkIds []string))
+}
+
+// Memory keeps clicks of the links stored in links, like the foreign key of the sql storages
+// clicks of unknown links aren't stored and clicks of a deleted link are deleted with it.
+type Memory struct {
+	clicksByLinkId map[string][]click.Click
+	links          Links
+	mu             *sync.Mutex
+}
+
+func NewMemory(links Links) *Memory {
+	m := &Memory{
+		clicksByLinkId: make(map[string][]click.Click),
+		links:          links,
+		mu:             &sync.Mutex{},
+	}
+	links.OnDelete(m.deleteClicks)
+	return m
+}
+
+func (m *Memory) StoreClick(ctx context.Context, c click.Click) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ok, err := m.links.CheckIfLinkExists(ctx, c.LinkId)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return link.ErrNotFound
+	}
+	m.clicksByLinkId[c.LinkId] = append(m.clicksByLinkId[c.LinkId], c)
+	return nil
+}
+
+// StoreClicks skips clicks of links deleted while the click was waiting in a batch.
+func (m *Memory) StoreClicks(ctx context.Context, clicks []click.Click) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range clicks {
+		ok, err := m.links.CheckIfLinkExists(ctx, c.LinkId)
+		if err != nil {
+			return err
+		}
+		if ok {
+			m.clicksByLinkId[c.LinkId] = append(m.clicksByLinkId[c.LinkId], c)
+		}
+	}
+	return nil
+}
+
+// deleteClicks is called by the link storage after links are deleted. Clicks are checked
+// and stored under the lock, so a click of a link that existed at the check is deleted here.
+func (m *Memory) deleteClicks(linkIds []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, linkId := range linkIds {
+		delete(m.clicksByLinkId, linkId)
+	}
+}
+
+func (m *Memory) CountClicks(ctx context.Context, linkId string) (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	visitors := make(map[string]struct{})
+	for _, c := range m.clicksByLinkId[linkId] {
+		visitors[c.IpHash] = struct{}{}
+	}
+	return len(m.clicksByLinkId[linkId]), len(visitors), nil
+}
+
+func (m *Memory) CountClicksPer(ctx context.Context, linkId string, period click.Period) ([]click.Bucket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[time.Time]int)
+	for _, c := range m.clicksByLinkId[linkId] {
+		t := c.ClickedAt.UTC()
+		if period == click.Day {
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		} else {
+			t = t.Truncate(time.Hour)
+		}
+		counts[t]++
+	}
+	buckets := make([]click.Bucket, 0, len(counts))
+	for t, n := range counts {
+		buckets = append(buckets, click.Bucket{Time: t, Clicks: n})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Time.Before(buckets[j].Time)
+	})
+	return buckets, nil
+}
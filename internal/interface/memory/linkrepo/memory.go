@@ -1,37 +1,49 @@
 package linkrepo
 
 import (
+	"context"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/status"
 	"sync"
+	"time"
 )
 
 type Memory struct {
-	linkByLinkId     map[string]link.Link
-	linksByAccountId map[string]map[string]link.Link
-	mu               *sync.Mutex
+	linkByLinkId      map[string]link.Link
+	linksByAccountId  map[string]map[string]link.Link
+	linkIdsByTarget   map[string]map[string]struct{}
+	revisionsByLinkId map[string][]link.Revision
+	nextRevisionId    int64
+	onDelete          []func(linkIds []string)
+	mu                *sync.Mutex
 }
 
 func NewMemory() *Memory {
 	return &Memory{
-		linkByLinkId:     make(map[string]link.Link),
-		linksByAccountId: make(map[string]map[string]link.Link),
-		mu:               &sync.Mutex{},
+		linkByLinkId:      make(map[string]link.Link),
+		linksByAccountId:  make(map[string]map[string]link.Link),
+		linkIdsByTarget:   make(map[string]map[string]struct{}),
+		revisionsByLinkId: make(map[string][]link.Revision),
+		nextRevisionId:    1,
+		mu:                &sync.Mutex{},
 	}
 }
 
-func (m *Memory) CheckIfLinkExists(linkId string) bool {
+func (m *Memory) CheckIfLinkExists(ctx context.Context, linkId string) (bool, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	_, ok := m.linkByLinkId[linkId]
-	return ok
+	return ok, nil
 }
 
-func (m *Memory) StoreLink(lnk link.Link) (link.Link, error) {
+func (m *Memory) StoreLink(ctx context.Context, lnk link.Link) (link.Link, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if _, ok := m.linkByLinkId[lnk.LinkId]; ok {
 		return link.Link{}, link.ErrAlreadyExist
 	}
+	lnk.CreatedAt = time.Now()
+	lnk.UpdatedAt = lnk.CreatedAt
 	m.linkByLinkId[lnk.LinkId] = lnk
 	if lnk.AccountId != nil {
 		links, ok := m.linksByAccountId[*lnk.AccountId]
@@ -41,22 +53,103 @@ func (m *Memory) StoreLink(lnk link.Link) (link.Link, error) {
 		links[lnk.LinkId] = lnk
 		m.linksByAccountId[*lnk.AccountId] = links
 	}
+	m.indexTarget(lnk.Link, lnk.LinkId)
 	return lnk, nil
 }
 
-func (m *Memory) DeleteLink(lnk string) error {
+func (m *Memory) UpdateLink(ctx context.Context, lnk link.Link) (link.Link, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	l, ok := m.linkByLinkId[lnk.LinkId]
+	if !ok {
+		return link.Link{}, link.ErrNotFound
+	}
+	now := time.Now()
+	if l.Link != lnk.Link {
+		m.revisionsByLinkId[l.LinkId] = append(m.revisionsByLinkId[l.LinkId], link.Revision{
+			Id:        m.nextRevisionId,
+			LinkId:    l.LinkId,
+			Link:      l.Link,
+			ChangedAt: now,
+		})
+		m.nextRevisionId++
+		m.unindexTarget(l.Link, l.LinkId)
+		m.indexTarget(lnk.Link, l.LinkId)
+	}
+	l.Link = lnk.Link
+	l.LinkStatus = lnk.LinkStatus
+	l.ExpiresAt = lnk.ExpiresAt
+	l.MaxClicks = lnk.MaxClicks
+	l.UpdatedAt = now
+	m.linkByLinkId[l.LinkId] = l
+	if l.AccountId != nil {
+		m.linksByAccountId[*l.AccountId][l.LinkId] = l
+	}
+	return l, nil
+}
+
+// OnDelete registers f to be called with the ids of deleted links, the way the sql storages
+// cascade deletes to rows referencing a link. f is called after the storage is unlocked.
+func (m *Memory) OnDelete(f func(linkIds []string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onDelete = append(m.onDelete, f)
+}
+
+func (m *Memory) DeleteLink(ctx context.Context, lnk string) error {
+	m.mu.Lock()
 	l, ok := m.linkByLinkId[lnk]
 	if !ok {
+		m.mu.Unlock()
 		return link.ErrNotFound
 	}
 	delete(m.linkByLinkId, lnk)
-	delete(m.linksByAccountId[*l.AccountId], lnk)
+	delete(m.revisionsByLinkId, lnk)
+	m.unindexTarget(l.Link, lnk)
+	if l.AccountId != nil {
+		delete(m.linksByAccountId[*l.AccountId], lnk)
+	}
+	onDelete := m.onDelete
+	m.mu.Unlock()
+	for _, f := range onDelete {
+		f([]string{lnk})
+	}
 	return nil
 }
 
-func (m *Memory) GetLinkByLinkId(lnk string) (link.Link, error) {
+func (m *Memory) DeleteLinksByAccountId(ctx context.Context, accountId string) error {
+	m.mu.Lock()
+	linkIds := make([]string, 0, len(m.linksByAccountId[accountId]))
+	for linkId, l := range m.linksByAccountId[accountId] {
+		delete(m.linkByLinkId, linkId)
+		delete(m.revisionsByLinkId, linkId)
+		m.unindexTarget(l.Link, linkId)
+		linkIds = append(linkIds, linkId)
+	}
+	delete(m.linksByAccountId, accountId)
+	onDelete := m.onDelete
+	m.mu.Unlock()
+	if len(linkIds) > 0 {
+		for _, f := range onDelete {
+			f(linkIds)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) DisownLinksByAccountId(ctx context.Context, accountId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for linkId := range m.linksByAccountId[accountId] {
+		l := m.linkByLinkId[linkId]
+		l.AccountId = nil
+		m.linkByLinkId[linkId] = l
+	}
+	delete(m.linksByAccountId, accountId)
+	return nil
+}
+
+func (m *Memory) GetLinkByLinkId(ctx context.Context, lnk string) (link.Link, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	l, ok := m.linkByLinkId[lnk]
@@ -66,7 +159,7 @@ func (m *Memory) GetLinkByLinkId(lnk string) (link.Link, error) {
 	return l, nil
 }
 
-func (m *Memory) GetLinksByAccountId(accountId string) ([]link.Link, error) {
+func (m *Memory) GetLinksByAccountId(ctx context.Context, accountId string) ([]link.Link, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	lnks, ok := m.linksByAccountId[accountId]
@@ -80,3 +173,85 @@ func (m *Memory) GetLinksByAccountId(accountId string) ([]link.Link, error) {
 	}
 	return links, nil
 }
+
+func (m *Memory) GetLinksByTarget(ctx context.Context, target string, accountId *string) ([]link.Link, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	links := make([]link.Link, 0)
+	for linkId := range m.linkIdsByTarget[target] {
+		l := m.linkByLinkId[linkId]
+		if accountId == nil && l.AccountId == nil || accountId != nil && l.AccountId != nil && *accountId == *l.AccountId {
+			links = append(links, l)
+		}
+	}
+	return links, nil
+}
+
+func (m *Memory) indexTarget(target, linkId string) {
+	ids, ok := m.linkIdsByTarget[target]
+	if !ok {
+		ids = make(map[string]struct{})
+		m.linkIdsByTarget[target] = ids
+	}
+	ids[linkId] = struct{}{}
+}
+
+func (m *Memory) unindexTarget(target, linkId string) {
+	delete(m.linkIdsByTarget[target], linkId)
+	if len(m.linkIdsByTarget[target]) == 0 {
+		delete(m.linkIdsByTarget, target)
+	}
+}
+
+func (m *Memory) UpdateLinkStatusByLinkId(ctx context.Context, lnk string, linkStatus status.LinkStatus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.linkByLinkId[lnk]
+	if !ok {
+		return link.ErrNotFound
+	}
+	l.LinkStatus = linkStatus
+	m.linkByLinkId[lnk] = l
+	if l.AccountId != nil {
+		m.linksByAccountId[*l.AccountId][lnk] = l
+	}
+	return nil
+}
+
+func (m *Memory) GetAllUserLinks(ctx context.Context) ([]link.Link, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	links := make([]link.Link, 0)
+	for _, l := range m.linkByLinkId {
+		if l.AccountId != nil {
+			links = append(links, l)
+		}
+	}
+	return links, nil
+}
+
+func (m *Memory) IncrementLinkClicks(ctx context.Context, lnk string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.linkByLinkId[lnk]
+	if !ok {
+		return link.ErrNotFound
+	}
+	if l.MaxClicks != nil && l.Clicks >= *l.MaxClicks {
+		return link.ErrExpired
+	}
+	l.Clicks++
+	m.linkByLinkId[lnk] = l
+	if l.AccountId != nil {
+		m.linksByAccountId[*l.AccountId][lnk] = l
+	}
+	return nil
+}
+
+func (m *Memory) GetLinkRevisions(ctx context.Context, lnk string) ([]link.Revision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	revisions := make([]link.Revision, len(m.revisionsByLinkId[lnk]))
+	copy(revisions, m.revisionsByLinkId[lnk])
+	return revisions, nil
+}
@@ -0,0 +1,54 @@
+package loginattemptrepo
+
+import (
+	"context"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/loginattempt"
+	"sync"
+	"time"
+)
+
+type Memory struct {
+	attemptsByKey map[string]loginattempt.Attempts
+	mu            *sync.Mutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		attemptsByKey: make(map[string]loginattempt.Attempts),
+		mu:            &sync.Mutex{},
+	}
+}
+
+func (m *Memory) GetAttempts(ctx context.Context, key string) (loginattempt.Attempts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.attemptsByKey[key], nil
+}
+
+func (m *Memory) SwapAttempts(ctx context.Context, key string, prevFailures int, next loginattempt.Attempts) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.attemptsByKey[key].Failures != prevFailures {
+		return false, nil
+	}
+	m.attemptsByKey[key] = next
+	return true, nil
+}
+
+func (m *Memory) ResetAttempts(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attemptsByKey, key)
+	return nil
+}
+
+func (m *Memory) DeleteStaleAttempts(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, a := range m.attemptsByKey {
+		if a.LastFailureAt.Before(before) {
+			delete(m.attemptsByKey, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package refreshtokenrepo
+
+import (
+	"context"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/refreshtoken"
+	"sync"
+	"time"
+)
+
+type Memory struct {
+	tokensByHash      map[string]refreshtoken.RefreshToken
+	hashesByFamilyId  map[string][]string
+	hashByAccessToken map[string]string
+	mu                *sync.Mutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		tokensByHash:      make(map[string]refreshtoken.RefreshToken),
+		hashesByFamilyId:  make(map[string][]string),
+		hashByAccessToken: make(map[string]string),
+		mu:                &sync.Mutex{},
+	}
+}
+
+func (m *Memory) StoreRefreshToken(ctx context.Context, t refreshtoken.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t.CreatedAt = time.Now()
+	m.tokensByHash[t.Hash] = t
+	m.hashesByFamilyId[t.FamilyId] = append(m.hashesByFamilyId[t.FamilyId], t.Hash)
+	m.hashByAccessToken[t.AccessTokenId] = t.Hash
+	return nil
+}
+
+func (m *Memory) GetRefreshToken(ctx context.Context, hash string) (refreshtoken.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokensByHash[hash]
+	if !ok {
+		return refreshtoken.RefreshToken{}, refreshtoken.ErrNotFound
+	}
+	return t, nil
+}
+
+func (m *Memory) UseRefreshToken(ctx context.Context, hash string, usedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.tokensByHash[hash]
+	if !ok {
+		return refreshtoken.ErrNotFound
+	}
+	if t.UsedAt != nil {
+		return refreshtoken.ErrAlreadyUsed
+	}
+	t.UsedAt = &usedAt
+	m.tokensByHash[hash] = t
+	return nil
+}
+
+func (m *Memory) RevokeFamily(ctx context.Context, familyId string, revokedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, hash := range m.hashesByFamilyId[familyId] {
+		t := m.tokensByHash[hash]
+		if t.RevokedAt == nil {
+			t.RevokedAt = &revokedAt
+			m.tokensByHash[hash] = t
+		}
+	}
+	return nil
+}
+
+func (m *Memory) RevokeAccount(ctx context.Context, accountId string, revokedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hash, t := range m.tokensByHash {
+		if t.AccountId == accountId && t.RevokedAt == nil {
+			t.RevokedAt = &revokedAt
+			m.tokensByHash[hash] = t
+		}
+	}
+	return nil
+}
+
+func (m *Memory) IsAccessTokenRevoked(ctx context.Context, accessTokenId string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hash, ok := m.hashByAccessToken[accessTokenId]
+	if !ok {
+		return false, nil
+	}
+	return m.tokensByHash[hash].RevokedAt != nil, nil
+}
+
+func (m *Memory) DeleteExpiredFamilies(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for familyId, hashes := range m.hashesByFamilyId {
+		expired := true
+		for _, hash := range hashes {
+			if !m.tokensByHash[hash].ExpiresAt.Before(before) {
+				expired = false
+				break
+			}
+		}
+		if !expired {
+			continue
+		}
+		for _, hash := range hashes {
+			delete(m.hashByAccessToken, m.tokensByHash[hash].AccessTokenId)
+			delete(m.tokensByHash, hash)
+		}
+		delete(m.hashesByFamilyId, familyId)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package sequencerepo
+
+import (
+	"context"
+	"sync"
+)
+
+type Memory struct {
+	values map[string]int64
+	mu     *sync.Mutex
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		values: make(map[string]int64),
+		mu:     &sync.Mutex{},
+	}
+}
+
+func (m *Memory) Reserve(ctx context.Context, name string, n int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	first := m.values[name]
+	m.values[name] = first + n
+	return first, nil
+}
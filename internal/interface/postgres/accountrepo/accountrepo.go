@@ -1,12 +1,17 @@
 package accountrepo
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"github.com/lib/pq"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/account"
 	"strconv"
 )
 
+// uniqueViolation is the postgres error code of a unique constraint violation.
+const uniqueViolation = "23505"
+
 type Postgres struct {
 	conn *sql.DB
 }
@@ -26,21 +31,26 @@ const queryCreateAccount = `
 	RETURNING id	
 `
 
-func (p *Postgres) CreateAccount(cred account.Credentials) (account.Account, error) {
+func (p *Postgres) CreateAccount(ctx context.Context, cred account.Credentials) (account.Account, error) {
 	a := account.Account{Credentials: cred}
-	row := p.conn.QueryRow(queryCreateAccount, cred.Login, cred.Password)
+	row := p.conn.QueryRowContext(ctx, queryCreateAccount, cred.Login, cred.Password)
 	err := row.Scan(&a.Id)
-	if err != nil && err == sql.ErrNoRows {
+	if err != nil && (err == sql.ErrNoRows || isUniqueViolation(err)) {
 		return account.Account{}, account.ErrAlreadyExist
 	}
 	return a, err
 }
 
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
 const queryGetAccountById = `
 	select id, login, password from accounts where id = $1
 `
 
-func (p *Postgres) GetAccountById(id string) (account.Account, error) {
+func (p *Postgres) GetAccountById(ctx context.Context, id string) (account.Account, error) {
 	a := account.Account{}
 
 	intId, err := strconv.Atoi(id)
@@ -48,7 +58,7 @@ func (p *Postgres) GetAccountById(id string) (account.Account, error) {
 		return a, ErrConversion
 	}
 
-	row := p.conn.QueryRow(queryGetAccountById, intId)
+	row := p.conn.QueryRowContext(ctx, queryGetAccountById, intId)
 
 	accountId := -1
 	err = row.Scan(&accountId, &a.Login, &a.Password)
@@ -64,12 +74,72 @@ const queryGetAccountByLogin = `
 	select id, login, password from accounts where login = $1
 `
 
-func (p *Postgres) GetAccountByLogin(login string) (account.Account, error) {
+func (p *Postgres) GetAccountByLogin(ctx context.Context, login string) (account.Account, error) {
 	a := account.Account{}
-	row := p.conn.QueryRow(queryGetAccountByLogin, login)
+	row := p.conn.QueryRowContext(ctx, queryGetAccountByLogin, login)
 	err := row.Scan(&a.Id, &a.Login, &a.Password)
 	if err != nil && err == sql.ErrNoRows {
 		return a, account.ErrNotFound
 	}
 	return a, err
 }
+
+const queryUpdatePassword = `
+	update accounts set password = $2, updatedAt = now() where id = $1
+`
+
+func (p *Postgres) UpdatePassword(ctx context.Context, id, password string) error {
+	intId, err := strconv.Atoi(id)
+	if err != nil {
+		return ErrConversion
+	}
+	res, err := p.conn.ExecContext(ctx, queryUpdatePassword, intId, password)
+	return affectedOne(res, err)
+}
+
+const queryDisownLinks = `
+	update links set accountId = null where accountId = $1
+`
+
+const queryDeleteAccount = `
+	delete from accounts where id = $1
+`
+
+// DeleteAccount deletes the account in a transaction with disowning its links, the rest
+// goes with the account by foreign keys: links with their clicks, sessions and api keys.
+func (p *Postgres) DeleteAccount(ctx context.Context, id string, disownLinks bool) error {
+	intId, err := strconv.Atoi(id)
+	if err != nil {
+		return ErrConversion
+	}
+	tx, err := p.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if disownLinks {
+		if _, err := tx.ExecContext(ctx, queryDisownLinks, intId); err != nil {
+			return err
+		}
+	}
+	if err := affectedOne(tx.ExecContext(ctx, queryDeleteAccount, intId)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// affectedOne turns a statement which changed no rows into ErrNotFound.
+func affectedOne(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return account.ErrNotFound
+	}
+	return nil
+}
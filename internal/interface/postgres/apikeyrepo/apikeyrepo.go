@@ -0,0 +1,126 @@
+package apikeyrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/apikey"
+	"strings"
+	"time"
+)
+
+type Postgres struct {
+	conn *sql.DB
+}
+
+func New(conn *sql.DB) *Postgres {
+	return &Postgres{conn: conn}
+}
+
+const queryCreateApiKey = `
+	insert into api_keys(id, accountId, name, hash, prefix, scopes, expiresAt) values ($1, $2, $3, $4, $5, $6, $7)
+	returning createdAt
+`
+
+func (p *Postgres) StoreApiKey(ctx context.Context, k apikey.ApiKey) (apikey.ApiKey, error) {
+	row := p.conn.QueryRowContext(ctx, queryCreateApiKey,
+		k.Id, k.AccountId, k.Name, k.Hash, k.Prefix, strings.Join(k.Scopes, " "), k.ExpiresAt)
+	err := row.Scan(&k.CreatedAt)
+	return k, err
+}
+
+const queryGetApiKeyByHash = `
+	select id, accountId, name, hash, prefix, scopes, expiresAt, lastUsedAt, createdAt from api_keys where hash = $1
+`
+
+func (p *Postgres) GetApiKeyByHash(ctx context.Context, hash string) (apikey.ApiKey, error) {
+	k, err := scanApiKey(p.conn.QueryRowContext(ctx, queryGetApiKeyByHash, hash))
+	if err == sql.ErrNoRows {
+		return k, apikey.ErrNotFound
+	}
+	return k, err
+}
+
+const queryGetApiKeysByAccountId = `
+	select id, accountId, name, hash, prefix, scopes, expiresAt, lastUsedAt, createdAt from api_keys
+	where accountId = $1 order by createdAt
+`
+
+func (p *Postgres) GetApiKeysByAccountId(ctx context.Context, accountId string) ([]apikey.ApiKey, error) {
+	rows, err := p.conn.QueryContext(ctx, queryGetApiKeysByAccountId, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []apikey.ApiKey
+	for rows.Next() {
+		k, err := scanApiKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+const queryDeleteApiKey = `
+	delete from api_keys where id = $1 and accountId = $2
+`
+
+func (p *Postgres) DeleteApiKey(ctx context.Context, id, accountId string) error {
+	res, err := p.conn.ExecContext(ctx, queryDeleteApiKey, id, accountId)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return apikey.ErrNotFound
+	}
+	return nil
+}
+
+const queryDeleteApiKeysByAccountId = `
+	delete from api_keys where accountId = $1
+`
+
+func (p *Postgres) DeleteApiKeysByAccountId(ctx context.Context, accountId string) error {
+	_, err := p.conn.ExecContext(ctx, queryDeleteApiKeysByAccountId, accountId)
+	return err
+}
+
+const queryTouchApiKey = `
+	update api_keys set lastUsedAt = $2 where id = $1
+`
+
+func (p *Postgres) TouchApiKey(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := p.conn.ExecContext(ctx, queryTouchApiKey, id, usedAt)
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanApiKey reads a row of (id, accountId, name, hash, prefix, scopes, expiresAt, lastUsedAt, createdAt),
+// scopes are kept space separated.
+func scanApiKey(row scanner) (apikey.ApiKey, error) {
+	k := apikey.ApiKey{}
+	var (
+		scopes                string
+		expiresAt, lastUsedAt sql.NullTime
+	)
+	if err := row.Scan(&k.Id, &k.AccountId, &k.Name, &k.Hash, &k.Prefix, &scopes, &expiresAt, &lastUsedAt, &k.CreatedAt); err != nil {
+		return k, err
+	}
+	k.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	return k, nil
+}
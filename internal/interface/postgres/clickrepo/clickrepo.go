@@ -0,0 +1,99 @@
+package clickrepo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/click"
+	"strings"
+	"time"
+)
+
+// maxClicksPerInsert keeps a batch insert below the postgres limit of 65535 query parameters.
+const maxClicksPerInsert = 1000
+
+type Postgres struct {
+	conn *sql.DB
+}
+
+func New(conn *sql.DB) *Postgres {
+	return &Postgres{conn: conn}
+}
+
+const queryCreateClick = `
+	insert into clicks(linkId, clickedAt, referrer, userAgent, ipHash) values ($1, $2, $3, $4, $5)
+`
+
+func (p *Postgres) StoreClick(ctx context.Context, c click.Click) error {
+	_, err := p.conn.ExecContext(ctx, queryCreateClick, c.LinkId, c.ClickedAt, c.Referrer, c.UserAgent, c.IpHash)
+	return err
+}
+
+// queryCreateClicks is completed with a row of values per click. Clicks of links deleted
+// while the click was waiting in a batch are skipped instead of failing the whole insert.
+const queryCreateClicks = `
+	insert into clicks(linkId, clickedAt, referrer, userAgent, ipHash)
+	select v.linkId, v.clickedAt, v.referrer, v.userAgent, v.ipHash
+	from links join (values %s) as v(linkId, clickedAt, referrer, userAgent, ipHash) on links.linkId = v.linkId
+`
+
+func (p *Postgres) StoreClicks(ctx context.Context, clicks []click.Click) error {
+	for len(clicks) > 0 {
+		n := len(clicks)
+		if n > maxClicksPerInsert {
+			n = maxClicksPerInsert
+		}
+		if err := p.storeClicks(ctx, clicks[:n]); err != nil {
+			return err
+		}
+		clicks = clicks[n:]
+	}
+	return nil
+}
+
+func (p *Postgres) storeClicks(ctx context.Context, clicks []click.Click) error {
+	rows := make([]string, 0, len(clicks))
+	args := make([]interface{}, 0, 5*len(clicks))
+	for i, c := range clicks {
+		rows = append(rows, fmt.Sprintf("($%d, $%d::timestamptz, $%d, $%d, $%d)", 5*i+1, 5*i+2, 5*i+3, 5*i+4, 5*i+5))
+		args = append(args, c.LinkId, c.ClickedAt, c.Referrer, c.UserAgent, c.IpHash)
+	}
+	_, err := p.conn.ExecContext(ctx, fmt.Sprintf(queryCreateClicks, strings.Join(rows, ", ")), args...)
+	return err
+}
+
+const queryCountClicks = `
+	select count(*), count(distinct ipHash) from clicks where linkId = $1
+`
+
+func (p *Postgres) CountClicks(ctx context.Context, linkId string) (total, unique int, err error) {
+	err = p.conn.QueryRowContext(ctx, queryCountClicks, linkId).Scan(&total, &unique)
+	return total, unique, err
+}
+
+// queryCountClicksPer truncates to the period in UTC, date_trunc takes the period names of click.Period.
+const queryCountClicksPer = `
+	select date_trunc($2, clickedAt at time zone 'UTC') as period, count(*) from clicks
+	where linkId = $1
+	group by period
+	order by period
+`
+
+func (p *Postgres) CountClicksPer(ctx context.Context, linkId string, period click.Period) ([]click.Bucket, error) {
+	rows, err := p.conn.QueryContext(ctx, queryCountClicksPer, linkId, string(period))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := make([]click.Bucket, 0)
+	for rows.Next() {
+		b := click.Bucket{}
+		if err := rows.Scan(&b.Time, &b.Clicks); err != nil {
+			return nil, err
+		}
+		b.Time = time.Date(b.Time.Year(), b.Time.Month(), b.Time.Day(), b.Time.Hour(), 0, 0, 0, time.UTC)
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
@@ -1,11 +1,17 @@
 package linkrepo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"github.com/lib/pq"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/status"
 )
 
+// uniqueViolation is the postgres error code of a unique constraint violation.
+const uniqueViolation = "23505"
+
 type Postgres struct {
 	conn *sql.DB
 }
@@ -14,55 +20,132 @@ func New(conn *sql.DB) *Postgres {
 	return &Postgres{conn: conn}
 }
 
-const queryGetLinkFieldById = `
-	select link from links where linkid = $1
+const queryLinkExists = `
+	select exists(select 1 from links where linkid = $1)
 `
 
-func (p *Postgres) CheckIfLinkExists(linkId string) bool {
-	l := link.Link{}
-	row := p.conn.QueryRow(queryGetLinkFieldById, linkId)
-	err := row.Scan(&l.Link, &l.AccountId)
-	if err != nil {
-		// todo: this function should return (bool, error)
-		return false
-	}
-	return true
+func (p *Postgres) CheckIfLinkExists(ctx context.Context, linkId string) (bool, error) {
+	var exists bool
+	err := p.conn.QueryRowContext(ctx, queryLinkExists, linkId).Scan(&exists)
+	return exists, err
 }
 
 const queryCreateLink = `
-	insert into  links(linkId, link, accountId) VALUES ($1, $2, $3)
-	returning linkid
+	insert into  links(linkId, link, accountId, password, expiresAt, maxClicks) VALUES ($1, $2, $3, $4, $5, $6)
+	returning createdAt, updatedAt
 `
 
-func (p *Postgres) StoreLink(lnk link.Link) (link.Link, error) {
+func (p *Postgres) StoreLink(ctx context.Context, lnk link.Link) (link.Link, error) {
 	// todo: StoreLink should return just (error)
-	accountId := ""
-	if lnk.AccountId != nil {
-		accountId = *lnk.AccountId
-	}
-	row := p.conn.QueryRow(queryCreateLink, lnk.LinkId, lnk.Link, accountId)
-	tmp := ""
-	err := row.Scan(&tmp)
-	if err != nil && err == sql.ErrNoRows {
+	row := p.conn.QueryRowContext(ctx, queryCreateLink, lnk.LinkId, lnk.Link, lnk.AccountId, lnk.Password, lnk.ExpiresAt, lnk.MaxClicks)
+	err := row.Scan(&lnk.CreatedAt, &lnk.UpdatedAt)
+	if err != nil && isUniqueViolation(err) {
 		return lnk, link.ErrAlreadyExist
 	}
 	return lnk, err
 }
 
+const queryUpdateLink = `
+	update links
+	set link = $2, linkStatus = $3, expiresAt = $4, maxClicks = $5, updatedAt = now()
+	where linkid = $1
+	returning linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt
+`
+
+// queryArchiveLink locks the link and keeps its destination as a revision if it is about to change.
+const queryArchiveLink = `
+	with old as (
+		select linkId, link from links where linkid = $1 for update
+	)
+	insert into link_revisions(linkId, link)
+	select linkId, link from old where link <> $2
+`
+
+func (p *Postgres) UpdateLink(ctx context.Context, lnk link.Link) (link.Link, error) {
+	tx, err := p.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return link.Link{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, queryArchiveLink, lnk.LinkId, lnk.Link); err != nil {
+		return link.Link{}, err
+	}
+	row := tx.QueryRowContext(ctx, queryUpdateLink, lnk.LinkId, lnk.Link, lnk.LinkStatus, lnk.ExpiresAt, lnk.MaxClicks)
+	l, err := scanLink(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return l, link.ErrNotFound
+		}
+		return l, err
+	}
+	return l, tx.Commit()
+}
+
+const queryLinkRevisions = `
+	select id, linkId, link, changedAt from link_revisions where linkid = $1 order by id
+`
+
+func (p *Postgres) GetLinkRevisions(ctx context.Context, linkId string) ([]link.Revision, error) {
+	rows, err := p.conn.QueryContext(ctx, queryLinkRevisions, linkId)
+	if err != nil {
+		return []link.Revision{}, err
+	}
+	defer rows.Close()
+
+	revisions := make([]link.Revision, 0)
+	for rows.Next() {
+		r := link.Revision{}
+		if err := rows.Scan(&r.Id, &r.LinkId, &r.Link, &r.ChangedAt); err != nil {
+			return []link.Revision{}, err
+		}
+		revisions = append(revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return []link.Revision{}, err
+	}
+	return revisions, nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
 const queryDeleteLink = `
 	delete from links where linkid = $1
 `
 
-func (p *Postgres) DeleteLink(linkId string) error {
-	_, err := p.conn.Exec(queryDeleteLink, linkId)
+func (p *Postgres) DeleteLink(ctx context.Context, linkId string) error {
+	_, err := p.conn.ExecContext(ctx, queryDeleteLink, linkId)
 	return err
 }
 
-func (p *Postgres) GetLinkByLinkId(linkId string) (link.Link, error) {
-	// this function doesn't fill accountId field
-	l := link.Link{}
-	row := p.conn.QueryRow(queryGetLinkFieldById, linkId)
-	err := row.Scan(&l.Link)
+const queryDeleteLinksByAccountId = `
+	delete from links where accountId = $1
+`
+
+func (p *Postgres) DeleteLinksByAccountId(ctx context.Context, accountId string) error {
+	_, err := p.conn.ExecContext(ctx, queryDeleteLinksByAccountId, accountId)
+	return err
+}
+
+const queryDisownLinksByAccountId = `
+	update links set accountId = null, updatedAt = now() where accountId = $1
+`
+
+func (p *Postgres) DisownLinksByAccountId(ctx context.Context, accountId string) error {
+	_, err := p.conn.ExecContext(ctx, queryDisownLinksByAccountId, accountId)
+	return err
+}
+
+const queryGetLinkById = `
+	select linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt from links where linkid = $1
+`
+
+func (p *Postgres) GetLinkByLinkId(ctx context.Context, linkId string) (link.Link, error) {
+	row := p.conn.QueryRowContext(ctx, queryGetLinkById, linkId)
+	l, err := scanLink(row)
 	if err != nil && err == sql.ErrNoRows {
 		return l, link.ErrNotFound
 	}
@@ -70,11 +153,11 @@ func (p *Postgres) GetLinkByLinkId(linkId string) (link.Link, error) {
 }
 
 const queryLinksByAccount = `
-	select linkId, link, linkStatus from links where accountid = $1
+	select linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt from links where accountid = $1
 `
 
-func (p *Postgres) GetLinksByAccountId(accountId string) ([]link.Link, error) {
-	rows, err := p.conn.Query(queryLinksByAccount, accountId)
+func (p *Postgres) GetLinksByAccountId(ctx context.Context, accountId string) ([]link.Link, error) {
+	rows, err := p.conn.QueryContext(ctx, queryLinksByAccount, accountId)
 	if err != nil {
 		return []link.Link{}, err
 	}
@@ -82,8 +165,48 @@ func (p *Postgres) GetLinksByAccountId(accountId string) ([]link.Link, error) {
 
 	links := make([]link.Link, 0)
 	for rows.Next() {
-		lnk := link.Link{}
-		if err := rows.Scan(&lnk.LinkId, &lnk.Link, &lnk.LinkStatus); err != nil {
+		lnk, err := scanLink(rows)
+		if err != nil {
+			return []link.Link{}, err
+		}
+		links = append(links, lnk)
+	}
+	if err := rows.Err(); err != nil {
+		return []link.Link{}, err
+	}
+	return links, nil
+}
+
+// queryLinksByTarget compares md5 first so the lookup uses the (accountId, md5(link)) index.
+const queryLinksByTarget = `
+	select linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt from links
+	where accountid = $1 and md5(link) = md5($2) and link = $2
+`
+
+const queryAnonymousLinksByTarget = `
+	select linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt from links
+	where accountid is null and md5(link) = md5($1) and link = $1
+`
+
+func (p *Postgres) GetLinksByTarget(ctx context.Context, target string, accountId *string) ([]link.Link, error) {
+	var (
+		rows *sql.Rows
+		err  error
+	)
+	if accountId != nil {
+		rows, err = p.conn.QueryContext(ctx, queryLinksByTarget, *accountId, target)
+	} else {
+		rows, err = p.conn.QueryContext(ctx, queryAnonymousLinksByTarget, target)
+	}
+	if err != nil {
+		return []link.Link{}, err
+	}
+	defer rows.Close()
+
+	links := make([]link.Link, 0)
+	for rows.Next() {
+		lnk, err := scanLink(rows)
+		if err != nil {
 			return []link.Link{}, err
 		}
 		links = append(links, lnk)
@@ -100,17 +223,17 @@ const queryUpdateLinkStatus = `
 	where linkid = $1
 `
 
-func (p *Postgres) UpdateLinkStatusByLinkId(linkId string, linkStatus status.LinkStatus) error {
-	_, err := p.conn.Exec(queryUpdateLinkStatus, linkId, linkStatus)
+func (p *Postgres) UpdateLinkStatusByLinkId(ctx context.Context, linkId string, linkStatus status.LinkStatus) error {
+	_, err := p.conn.ExecContext(ctx, queryUpdateLinkStatus, linkId, linkStatus)
 	return err
 }
 
 const queryGetAllUserLinks = `
-	select linkId, link, linkStatus from links where accountid != ''
+	select linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt from links where accountid is not null
 `
 
-func (p *Postgres) GetAllUserLinks() ([]link.Link, error) {
-	rows, err := p.conn.Query(queryGetAllUserLinks)
+func (p *Postgres) GetAllUserLinks(ctx context.Context) ([]link.Link, error) {
+	rows, err := p.conn.QueryContext(ctx, queryGetAllUserLinks)
 	if err != nil {
 		return []link.Link{}, err
 	}
@@ -118,8 +241,8 @@ func (p *Postgres) GetAllUserLinks() ([]link.Link, error) {
 
 	links := make([]link.Link, 0)
 	for rows.Next() {
-		lnk := link.Link{}
-		if err := rows.Scan(&lnk.LinkId, &lnk.Link, &lnk.LinkStatus); err != nil {
+		lnk, err := scanLink(rows)
+		if err != nil {
 			return []link.Link{}, err
 		}
 		links = append(links, lnk)
@@ -129,3 +252,51 @@ func (p *Postgres) GetAllUserLinks() ([]link.Link, error) {
 	}
 	return links, nil
 }
+
+const queryIncrementLinkClicks = `
+	update links
+	set clicks = clicks + 1
+	where linkid = $1 and (maxClicks is null or clicks < maxClicks)
+`
+
+func (p *Postgres) IncrementLinkClicks(ctx context.Context, linkId string) error {
+	res, err := p.conn.ExecContext(ctx, queryIncrementLinkClicks, linkId)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return link.ErrExpired
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanLink reads a row of (linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt).
+func scanLink(row scanner) (link.Link, error) {
+	l := link.Link{}
+	var (
+		accountId sql.NullString
+		expiresAt sql.NullTime
+		maxClicks sql.NullInt64
+	)
+	if err := row.Scan(&l.LinkId, &l.Link, &l.LinkStatus, &accountId, &l.Password, &expiresAt, &maxClicks, &l.Clicks, &l.CreatedAt, &l.UpdatedAt); err != nil {
+		return link.Link{}, err
+	}
+	if accountId.Valid {
+		l.AccountId = &accountId.String
+	}
+	if expiresAt.Valid {
+		l.ExpiresAt = &expiresAt.Time
+	}
+	if maxClicks.Valid {
+		l.MaxClicks = &maxClicks.Int64
+	}
+	return l, nil
+}
@@ -0,0 +1,85 @@
+package loginattemptrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/loginattempt"
+	"time"
+)
+
+type Postgres struct {
+	conn *sql.DB
+}
+
+func New(conn *sql.DB) *Postgres {
+	return &Postgres{conn: conn}
+}
+
+const queryGetAttempts = `
+	select failures, lastFailureAt, lockedUntil from login_attempts where attemptKey = $1
+`
+
+func (p *Postgres) GetAttempts(ctx context.Context, key string) (loginattempt.Attempts, error) {
+	a := loginattempt.Attempts{}
+	var lockedUntil sql.NullTime
+	err := p.conn.QueryRowContext(ctx, queryGetAttempts, key).Scan(&a.Failures, &a.LastFailureAt, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return loginattempt.Attempts{}, nil
+	}
+	if lockedUntil.Valid {
+		a.LockedUntil = &lockedUntil.Time
+	}
+	return a, err
+}
+
+// queryInsertAttempts stores the first failure of a key, or of a key whose failures were all given back.
+const queryInsertAttempts = `
+	insert into login_attempts(attemptKey, failures, lastFailureAt, lockedUntil) values ($1, $2, $3, $4)
+	on conflict (attemptKey) do update
+	set failures      = excluded.failures,
+	    lastFailureAt = excluded.lastFailureAt,
+	    lockedUntil   = excluded.lockedUntil
+	where login_attempts.failures = 0
+`
+
+const queryUpdateAttempts = `
+	update login_attempts set failures = $2, lastFailureAt = $3, lockedUntil = $4
+	where attemptKey = $1 and failures = $5
+`
+
+// SwapAttempts changes the row only if its failures are still prevFailures, so that concurrent
+// attempts of several instances don't overwrite each other's counts and locks.
+func (p *Postgres) SwapAttempts(ctx context.Context, key string, prevFailures int, next loginattempt.Attempts) (bool, error) {
+	var (
+		res sql.Result
+		err error
+	)
+	if prevFailures == 0 {
+		res, err = p.conn.ExecContext(ctx, queryInsertAttempts, key, next.Failures, next.LastFailureAt, next.LockedUntil)
+	} else {
+		res, err = p.conn.ExecContext(ctx, queryUpdateAttempts, key, next.Failures, next.LastFailureAt, next.LockedUntil, prevFailures)
+	}
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+const queryResetAttempts = `
+	delete from login_attempts where attemptKey = $1
+`
+
+func (p *Postgres) ResetAttempts(ctx context.Context, key string) error {
+	_, err := p.conn.ExecContext(ctx, queryResetAttempts, key)
+	return err
+}
+
+const queryDeleteStaleAttempts = `
+	delete from login_attempts where lastFailureAt < $1
+`
+
+func (p *Postgres) DeleteStaleAttempts(ctx context.Context, before time.Time) error {
+	_, err := p.conn.ExecContext(ctx, queryDeleteStaleAttempts, before)
+	return err
+}
@@ -0,0 +1,116 @@
+package refreshtokenrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/refreshtoken"
+	"time"
+)
+
+type Postgres struct {
+	conn *sql.DB
+}
+
+func New(conn *sql.DB) *Postgres {
+	return &Postgres{conn: conn}
+}
+
+const queryCreateRefreshToken = `
+	insert into refresh_tokens(hash, familyId, accountId, accessTokenId, expiresAt) values ($1, $2, $3, $4, $5)
+`
+
+func (p *Postgres) StoreRefreshToken(ctx context.Context, t refreshtoken.RefreshToken) error {
+	_, err := p.conn.ExecContext(ctx, queryCreateRefreshToken, t.Hash, t.FamilyId, t.AccountId, t.AccessTokenId, t.ExpiresAt)
+	return err
+}
+
+const queryGetRefreshToken = `
+	select hash, familyId, accountId, accessTokenId, expiresAt, usedAt, revokedAt, createdAt from refresh_tokens where hash = $1
+`
+
+func (p *Postgres) GetRefreshToken(ctx context.Context, hash string) (refreshtoken.RefreshToken, error) {
+	t := refreshtoken.RefreshToken{}
+	var usedAt, revokedAt sql.NullTime
+	row := p.conn.QueryRowContext(ctx, queryGetRefreshToken, hash)
+	err := row.Scan(&t.Hash, &t.FamilyId, &t.AccountId, &t.AccessTokenId, &t.ExpiresAt, &usedAt, &revokedAt, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return t, refreshtoken.ErrNotFound
+	}
+	if usedAt.Valid {
+		t.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return t, err
+}
+
+const queryUseRefreshToken = `
+	update refresh_tokens set usedAt = $2 where hash = $1 and usedAt is null
+`
+
+const queryRefreshTokenExists = `
+	select exists(select 1 from refresh_tokens where hash = $1)
+`
+
+func (p *Postgres) UseRefreshToken(ctx context.Context, hash string, usedAt time.Time) error {
+	res, err := p.conn.ExecContext(ctx, queryUseRefreshToken, hash, usedAt)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	var exists bool
+	if err := p.conn.QueryRowContext(ctx, queryRefreshTokenExists, hash).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return refreshtoken.ErrNotFound
+	}
+	return refreshtoken.ErrAlreadyUsed
+}
+
+const queryRevokeFamily = `
+	update refresh_tokens set revokedAt = $2 where familyId = $1 and revokedAt is null
+`
+
+func (p *Postgres) RevokeFamily(ctx context.Context, familyId string, revokedAt time.Time) error {
+	_, err := p.conn.ExecContext(ctx, queryRevokeFamily, familyId, revokedAt)
+	return err
+}
+
+const queryRevokeAccount = `
+	update refresh_tokens set revokedAt = $2 where accountId = $1 and revokedAt is null
+`
+
+func (p *Postgres) RevokeAccount(ctx context.Context, accountId string, revokedAt time.Time) error {
+	_, err := p.conn.ExecContext(ctx, queryRevokeAccount, accountId, revokedAt)
+	return err
+}
+
+const queryAccessTokenRevoked = `
+	select exists(select 1 from refresh_tokens where accessTokenId = $1 and revokedAt is not null)
+`
+
+func (p *Postgres) IsAccessTokenRevoked(ctx context.Context, accessTokenId string) (bool, error) {
+	var revoked bool
+	err := p.conn.QueryRowContext(ctx, queryAccessTokenRevoked, accessTokenId).Scan(&revoked)
+	return revoked, err
+}
+
+// queryDeleteExpiredFamilies finds families through the tokens expired before the time
+// and keeps those with a token that's still valid.
+const queryDeleteExpiredFamilies = `
+	delete from refresh_tokens where familyId in (
+		select familyId from refresh_tokens where expiresAt < $1
+		except
+		select familyId from refresh_tokens where expiresAt >= $1
+	)
+`
+
+func (p *Postgres) DeleteExpiredFamilies(ctx context.Context, before time.Time) error {
+	_, err := p.conn.ExecContext(ctx, queryDeleteExpiredFamilies, before)
+	return err
+}
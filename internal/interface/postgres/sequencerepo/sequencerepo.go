@@ -0,0 +1,28 @@
+package sequencerepo
+
+import (
+	"context"
+	"database/sql"
+)
+
+type Postgres struct {
+	conn *sql.DB
+}
+
+func New(conn *sql.DB) *Postgres {
+	return &Postgres{conn: conn}
+}
+
+// queryReserve advances the counter in a single statement, so that concurrent
+// instances never get the same values. A counter starts at zero on first use.
+const queryReserve = `
+	insert into sequences(name, value) values ($1, $2)
+	on conflict (name) do update set value = sequences.value + excluded.value
+	returning value - $2
+`
+
+func (p *Postgres) Reserve(ctx context.Context, name string, n int64) (int64, error) {
+	var first int64
+	err := p.conn.QueryRowContext(ctx, queryReserve, name, n).Scan(&first)
+	return first, err
+}
@@ -0,0 +1,137 @@
+package accountrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/account"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"strconv"
+)
+
+type Sqlite struct {
+	conn *sql.DB
+}
+
+var (
+	ErrConversion = errors.New("cant typecast string account id to int")
+)
+
+func New(conn *sql.DB) *Sqlite {
+	return &Sqlite{conn: conn}
+}
+
+const queryCreateAccount = `
+	insert into accounts(login, password) values (?, ?)
+	returning id
+`
+
+func (s *Sqlite) CreateAccount(ctx context.Context, cred account.Credentials) (account.Account, error) {
+	a := account.Account{Credentials: cred}
+	row := s.conn.QueryRowContext(ctx, queryCreateAccount, cred.Login, cred.Password)
+	err := row.Scan(&a.Id)
+	if err != nil && isUniqueViolation(err) {
+		return account.Account{}, account.ErrAlreadyExist
+	}
+	return a, err
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+const queryGetAccountById = `
+	select id, login, password from accounts where id = ?
+`
+
+func (s *Sqlite) GetAccountById(ctx context.Context, id string) (account.Account, error) {
+	a := account.Account{}
+
+	intId, err := strconv.Atoi(id)
+	if err != nil {
+		return a, ErrConversion
+	}
+
+	row := s.conn.QueryRowContext(ctx, queryGetAccountById, intId)
+	err = row.Scan(&a.Id, &a.Login, &a.Password)
+	if err == sql.ErrNoRows {
+		return a, account.ErrNotFound
+	}
+	return a, err
+}
+
+const queryGetAccountByLogin = `
+	select id, login, password from accounts where login = ?
+`
+
+func (s *Sqlite) GetAccountByLogin(ctx context.Context, login string) (account.Account, error) {
+	a := account.Account{}
+	row := s.conn.QueryRowContext(ctx, queryGetAccountByLogin, login)
+	err := row.Scan(&a.Id, &a.Login, &a.Password)
+	if err == sql.ErrNoRows {
+		return a, account.ErrNotFound
+	}
+	return a, err
+}
+
+const queryUpdatePassword = `
+	update accounts set password = ?2, updatedAt = strftime('%Y-%m-%d %H:%M:%f', 'now') where id = ?1
+`
+
+func (s *Sqlite) UpdatePassword(ctx context.Context, id, password string) error {
+	intId, err := strconv.Atoi(id)
+	if err != nil {
+		return ErrConversion
+	}
+	res, err := s.conn.ExecContext(ctx, queryUpdatePassword, intId, password)
+	return affectedOne(res, err)
+}
+
+const queryDisownLinks = `
+	update links set accountId = null where accountId = ?
+`
+
+const queryDeleteAccount = `
+	delete from accounts where id = ?
+`
+
+// DeleteAccount deletes the account in a transaction with disowning its links, the rest
+// goes with the account by foreign keys: links with their clicks, sessions and api keys.
+func (s *Sqlite) DeleteAccount(ctx context.Context, id string, disownLinks bool) error {
+	intId, err := strconv.Atoi(id)
+	if err != nil {
+		return ErrConversion
+	}
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if disownLinks {
+		if _, err := tx.ExecContext(ctx, queryDisownLinks, intId); err != nil {
+			return err
+		}
+	}
+	if err := affectedOne(tx.ExecContext(ctx, queryDeleteAccount, intId)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// affectedOne turns a statement which changed no rows into ErrNotFound.
+func affectedOne(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return account.ErrNotFound
+	}
+	return nil
+}
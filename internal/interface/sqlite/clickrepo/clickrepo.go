@@ -0,0 +1,116 @@
+package clickrepo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/click"
+	"strings"
+	"time"
+)
+
+// maxClicksPerInsert keeps a batch insert below the sqlite limit of 32766 query parameters.
+const maxClicksPerInsert = 1000
+
+type Sqlite struct {
+	conn *sql.DB
+}
+
+func New(conn *sql.DB) *Sqlite {
+	return &Sqlite{conn: conn}
+}
+
+const queryCreateClick = `
+	insert into clicks(linkId, clickedAt, referrer, userAgent, ipHash) values (?, ?, ?, ?, ?)
+`
+
+func (s *Sqlite) StoreClick(ctx context.Context, c click.Click) error {
+	_, err := s.conn.ExecContext(ctx, queryCreateClick, c.LinkId, c.ClickedAt.UTC(), c.Referrer, c.UserAgent, c.IpHash)
+	return err
+}
+
+// queryCreateClicks is completed with a row of values per click. Clicks of links deleted
+// while the click was waiting in a batch are skipped instead of failing the whole insert.
+const queryCreateClicks = `
+	with v(linkId, clickedAt, referrer, userAgent, ipHash) as (values %s)
+	insert into clicks(linkId, clickedAt, referrer, userAgent, ipHash)
+	select v.linkId, v.clickedAt, v.referrer, v.userAgent, v.ipHash
+	from v join links on links.linkId = v.linkId
+`
+
+func (s *Sqlite) StoreClicks(ctx context.Context, clicks []click.Click) error {
+	for len(clicks) > 0 {
+		n := len(clicks)
+		if n > maxClicksPerInsert {
+			n = maxClicksPerInsert
+		}
+		if err := s.storeClicks(ctx, clicks[:n]); err != nil {
+			return err
+		}
+		clicks = clicks[n:]
+	}
+	return nil
+}
+
+func (s *Sqlite) storeClicks(ctx context.Context, clicks []click.Click) error {
+	rows := make([]string, 0, len(clicks))
+	args := make([]interface{}, 0, 5*len(clicks))
+	for _, c := range clicks {
+		rows = append(rows, "(?, ?, ?, ?, ?)")
+		args = append(args, c.LinkId, c.ClickedAt.UTC(), c.Referrer, c.UserAgent, c.IpHash)
+	}
+	_, err := s.conn.ExecContext(ctx, fmt.Sprintf(queryCreateClicks, strings.Join(rows, ", ")), args...)
+	return err
+}
+
+const queryCountClicks = `
+	select count(*), count(distinct ipHash) from clicks where linkId = ?
+`
+
+func (s *Sqlite) CountClicks(ctx context.Context, linkId string) (total, unique int, err error) {
+	err = s.conn.QueryRowContext(ctx, queryCountClicks, linkId).Scan(&total, &unique)
+	return total, unique, err
+}
+
+// periodLayouts are the prefixes of a stored UTC time that name its day and hour.
+var periodLayouts = map[click.Period]string{
+	click.Day:  "2006-01-02",
+	click.Hour: "2006-01-02 15",
+}
+
+// queryCountClicksPer groups by a prefix of clickedAt, times are stored as UTC text starting
+// with the date and the hour, which strftime can't parse in the format the driver writes.
+const queryCountClicksPer = `
+	select substr(clickedAt, 1, ?2) as period, count(*) from clicks
+	where linkId = ?1
+	group by period
+	order by period
+`
+
+func (s *Sqlite) CountClicksPer(ctx context.Context, linkId string, period click.Period) ([]click.Bucket, error) {
+	layout, ok := periodLayouts[period]
+	if !ok {
+		return nil, fmt.Errorf("unknown period %q", period)
+	}
+	rows, err := s.conn.QueryContext(ctx, queryCountClicksPer, linkId, len(layout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := make([]click.Bucket, 0)
+	for rows.Next() {
+		var (
+			b     click.Bucket
+			start string
+		)
+		if err := rows.Scan(&start, &b.Clicks); err != nil {
+			return nil, err
+		}
+		if b.Time, err = time.Parse(layout, start); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
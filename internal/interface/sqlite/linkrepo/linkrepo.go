@@ -0,0 +1,267 @@
+package linkrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/status"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type Sqlite struct {
+	conn *sql.DB
+}
+
+func New(conn *sql.DB) *Sqlite {
+	return &Sqlite{conn: conn}
+}
+
+const queryLinkExists = `
+	select exists(select 1 from links where linkid = ?)
+`
+
+func (s *Sqlite) CheckIfLinkExists(ctx context.Context, linkId string) (bool, error) {
+	var exists bool
+	err := s.conn.QueryRowContext(ctx, queryLinkExists, linkId).Scan(&exists)
+	return exists, err
+}
+
+const queryCreateLink = `
+	insert into links(linkId, link, accountId, password, expiresAt, maxClicks) values (?, ?, ?, ?, ?, ?)
+	returning createdAt, updatedAt
+`
+
+func (s *Sqlite) StoreLink(ctx context.Context, lnk link.Link) (link.Link, error) {
+	row := s.conn.QueryRowContext(ctx, queryCreateLink, lnk.LinkId, lnk.Link, lnk.AccountId, lnk.Password, lnk.ExpiresAt, lnk.MaxClicks)
+	err := row.Scan(&lnk.CreatedAt, &lnk.UpdatedAt)
+	if err != nil && isUniqueViolation(err) {
+		return lnk, link.ErrAlreadyExist
+	}
+	return lnk, err
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code()
+	return code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || code == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+const queryUpdateLink = `
+	update links
+	set link = ?2, linkStatus = ?3, expiresAt = ?4, maxClicks = ?5, updatedAt = strftime('%Y-%m-%d %H:%M:%f', 'now')
+	where linkid = ?1
+	returning linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt
+`
+
+// queryArchiveLink keeps the destination as a revision if it is about to change,
+// sqlite has a single writer so the transaction already keeps the link from changing meanwhile.
+const queryArchiveLink = `
+	insert into link_revisions(linkId, link)
+	select linkId, link from links where linkid = ?1 and link <> ?2
+`
+
+func (s *Sqlite) UpdateLink(ctx context.Context, lnk link.Link) (link.Link, error) {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return link.Link{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, queryArchiveLink, lnk.LinkId, lnk.Link); err != nil {
+		return link.Link{}, err
+	}
+	row := tx.QueryRowContext(ctx, queryUpdateLink, lnk.LinkId, lnk.Link, lnk.LinkStatus, lnk.ExpiresAt, lnk.MaxClicks)
+	l, err := scanLink(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return l, link.ErrNotFound
+		}
+		return l, err
+	}
+	return l, tx.Commit()
+}
+
+const queryLinkRevisions = `
+	select id, linkId, link, changedAt from link_revisions where linkid = ? order by id
+`
+
+func (s *Sqlite) GetLinkRevisions(ctx context.Context, linkId string) ([]link.Revision, error) {
+	rows, err := s.conn.QueryContext(ctx, queryLinkRevisions, linkId)
+	if err != nil {
+		return []link.Revision{}, err
+	}
+	defer rows.Close()
+
+	revisions := make([]link.Revision, 0)
+	for rows.Next() {
+		r := link.Revision{}
+		if err := rows.Scan(&r.Id, &r.LinkId, &r.Link, &r.ChangedAt); err != nil {
+			return []link.Revision{}, err
+		}
+		revisions = append(revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return []link.Revision{}, err
+	}
+	return revisions, nil
+}
+
+const queryDeleteLink = `
+	delete from links where linkid = ?
+`
+
+func (s *Sqlite) DeleteLink(ctx context.Context, linkId string) error {
+	_, err := s.conn.ExecContext(ctx, queryDeleteLink, linkId)
+	return err
+}
+
+const queryDeleteLinksByAccountId = `
+	delete from links where accountId = ?
+`
+
+func (s *Sqlite) DeleteLinksByAccountId(ctx context.Context, accountId string) error {
+	_, err := s.conn.ExecContext(ctx, queryDeleteLinksByAccountId, accountId)
+	return err
+}
+
+const queryDisownLinksByAccountId = `
+	update links set accountId = null, updatedAt = strftime('%Y-%m-%d %H:%M:%f', 'now') where accountId = ?
+`
+
+func (s *Sqlite) DisownLinksByAccountId(ctx context.Context, accountId string) error {
+	_, err := s.conn.ExecContext(ctx, queryDisownLinksByAccountId, accountId)
+	return err
+}
+
+const queryGetLinkById = `
+	select linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt from links where linkid = ?
+`
+
+func (s *Sqlite) GetLinkByLinkId(ctx context.Context, linkId string) (link.Link, error) {
+	row := s.conn.QueryRowContext(ctx, queryGetLinkById, linkId)
+	l, err := scanLink(row)
+	if err == sql.ErrNoRows {
+		return l, link.ErrNotFound
+	}
+	return l, err
+}
+
+const queryLinksByAccount = `
+	select linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt from links where accountid = ?
+`
+
+func (s *Sqlite) GetLinksByAccountId(ctx context.Context, accountId string) ([]link.Link, error) {
+	return s.queryLinks(ctx, queryLinksByAccount, accountId)
+}
+
+const queryLinksByTarget = `
+	select linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt from links
+	where accountid = ? and link = ?
+`
+
+const queryAnonymousLinksByTarget = `
+	select linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt from links
+	where accountid is null and link = ?
+`
+
+func (s *Sqlite) GetLinksByTarget(ctx context.Context, target string, accountId *string) ([]link.Link, error) {
+	if accountId != nil {
+		return s.queryLinks(ctx, queryLinksByTarget, *accountId, target)
+	}
+	return s.queryLinks(ctx, queryAnonymousLinksByTarget, target)
+}
+
+const queryUpdateLinkStatus = `
+	update links
+	set linkstatus = ?
+	where linkid = ?
+`
+
+func (s *Sqlite) UpdateLinkStatusByLinkId(ctx context.Context, linkId string, linkStatus status.LinkStatus) error {
+	_, err := s.conn.ExecContext(ctx, queryUpdateLinkStatus, linkStatus, linkId)
+	return err
+}
+
+const queryGetAllUserLinks = `
+	select linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt from links where accountid is not null
+`
+
+func (s *Sqlite) GetAllUserLinks(ctx context.Context) ([]link.Link, error) {
+	return s.queryLinks(ctx, queryGetAllUserLinks)
+}
+
+const queryIncrementLinkClicks = `
+	update links
+	set clicks = clicks + 1
+	where linkid = ? and (maxClicks is null or clicks < maxClicks)
+`
+
+func (s *Sqlite) IncrementLinkClicks(ctx context.Context, linkId string) error {
+	res, err := s.conn.ExecContext(ctx, queryIncrementLinkClicks, linkId)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return link.ErrExpired
+	}
+	return nil
+}
+
+// queryLinks reads all links selected by query, the rows are read out before returning
+// since the only connection is busy until then.
+func (s *Sqlite) queryLinks(ctx context.Context, query string, args ...interface{}) ([]link.Link, error) {
+	rows, err := s.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return []link.Link{}, err
+	}
+	defer rows.Close()
+
+	links := make([]link.Link, 0)
+	for rows.Next() {
+		lnk, err := scanLink(rows)
+		if err != nil {
+			return []link.Link{}, err
+		}
+		links = append(links, lnk)
+	}
+	if err := rows.Err(); err != nil {
+		return []link.Link{}, err
+	}
+	return links, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanLink reads a row of (linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt).
+func scanLink(row scanner) (link.Link, error) {
+	l := link.Link{}
+	var (
+		accountId sql.NullString
+		expiresAt sql.NullTime
+		maxClicks sql.NullInt64
+	)
+	if err := row.Scan(&l.LinkId, &l.Link, &l.LinkStatus, &accountId, &l.Password, &expiresAt, &maxClicks, &l.Clicks, &l.CreatedAt, &l.UpdatedAt); err != nil {
+		return link.Link{}, err
+	}
+	if accountId.Valid {
+		l.AccountId = &accountId.String
+	}
+	if expiresAt.Valid {
+		l.ExpiresAt = &expiresAt.Time
+	}
+	if maxClicks.Valid {
+		l.MaxClicks = &maxClicks.Int64
+	}
+	return l, nil
+}
@@ -0,0 +1,116 @@
+package refreshtokenrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/refreshtoken"
+	"time"
+)
+
+type Sqlite struct {
+	conn *sql.DB
+}
+
+func New(conn *sql.DB) *Sqlite {
+	return &Sqlite{conn: conn}
+}
+
+const queryCreateRefreshToken = `
+	insert into refresh_tokens(hash, familyId, accountId, accessTokenId, expiresAt) values (?, ?, ?, ?, ?)
+`
+
+func (s *Sqlite) StoreRefreshToken(ctx context.Context, t refreshtoken.RefreshToken) error {
+	_, err := s.conn.ExecContext(ctx, queryCreateRefreshToken, t.Hash, t.FamilyId, t.AccountId, t.AccessTokenId, t.ExpiresAt.UTC())
+	return err
+}
+
+const queryGetRefreshToken = `
+	select hash, familyId, accountId, accessTokenId, expiresAt, usedAt, revokedAt, createdAt from refresh_tokens where hash = ?
+`
+
+func (s *Sqlite) GetRefreshToken(ctx context.Context, hash string) (refreshtoken.RefreshToken, error) {
+	t := refreshtoken.RefreshToken{}
+	var usedAt, revokedAt sql.NullTime
+	row := s.conn.QueryRowContext(ctx, queryGetRefreshToken, hash)
+	err := row.Scan(&t.Hash, &t.FamilyId, &t.AccountId, &t.AccessTokenId, &t.ExpiresAt, &usedAt, &revokedAt, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return t, refreshtoken.ErrNotFound
+	}
+	if usedAt.Valid {
+		t.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return t, err
+}
+
+const queryUseRefreshToken = `
+	update refresh_tokens set usedAt = ?2 where hash = ?1 and usedAt is null
+`
+
+const queryRefreshTokenExists = `
+	select exists(select 1 from refresh_tokens where hash = ?)
+`
+
+func (s *Sqlite) UseRefreshToken(ctx context.Context, hash string, usedAt time.Time) error {
+	res, err := s.conn.ExecContext(ctx, queryUseRefreshToken, hash, usedAt)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+	var exists bool
+	if err := s.conn.QueryRowContext(ctx, queryRefreshTokenExists, hash).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return refreshtoken.ErrNotFound
+	}
+	return refreshtoken.ErrAlreadyUsed
+}
+
+const queryRevokeFamily = `
+	update refresh_tokens set revokedAt = ?2 where familyId = ?1 and revokedAt is null
+`
+
+func (s *Sqlite) RevokeFamily(ctx context.Context, familyId string, revokedAt time.Time) error {
+	_, err := s.conn.ExecContext(ctx, queryRevokeFamily, familyId, revokedAt)
+	return err
+}
+
+const queryRevokeAccount = `
+	update refresh_tokens set revokedAt = ?2 where accountId = ?1 and revokedAt is null
+`
+
+func (s *Sqlite) RevokeAccount(ctx context.Context, accountId string, revokedAt time.Time) error {
+	_, err := s.conn.ExecContext(ctx, queryRevokeAccount, accountId, revokedAt)
+	return err
+}
+
+const queryAccessTokenRevoked = `
+	select exists(select 1 from refresh_tokens where accessTokenId = ? and revokedAt is not null)
+`
+
+func (s *Sqlite) IsAccessTokenRevoked(ctx context.Context, accessTokenId string) (bool, error) {
+	var revoked bool
+	err := s.conn.QueryRowContext(ctx, queryAccessTokenRevoked, accessTokenId).Scan(&revoked)
+	return revoked, err
+}
+
+// queryDeleteExpiredFamilies finds families through the tokens expired before the time
+// and keeps those with a token that's still valid.
+const queryDeleteExpiredFamilies = `
+	delete from refresh_tokens where familyId in (
+		select familyId from refresh_tokens where expiresAt < ?1
+		except
+		select familyId from refresh_tokens where expiresAt >= ?1
+	)
+`
+
+func (s *Sqlite) DeleteExpiredFamilies(ctx context.Context, before time.Time) error {
+	_, err := s.conn.ExecContext(ctx, queryDeleteExpiredFamilies, before.UTC())
+	return err
+}
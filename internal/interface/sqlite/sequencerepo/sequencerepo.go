@@ -0,0 +1,28 @@
+package sequencerepo
+
+import (
+	"context"
+	"database/sql"
+)
+
+type Sqlite struct {
+	conn *sql.DB
+}
+
+func New(conn *sql.DB) *Sqlite {
+	return &Sqlite{conn: conn}
+}
+
+// queryReserve advances the counter in a single statement, so that concurrent
+// processes never get the same values. A counter starts at zero on first use.
+const queryReserve = `
+	insert into sequences(name, value) values (?1, ?2)
+	on conflict (name) do update set value = sequences.value + excluded.value
+	returning value - ?2
+`
+
+func (s *Sqlite) Reserve(ctx context.Context, name string, n int64) (int64, error) {
+	var first int64
+	err := s.conn.QueryRowContext(ctx, queryReserve, name, n).Scan(&first)
+	return first, err
+}
@@ -0,0 +1,256 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("applied migration differs from the embedded one")
+	ErrUnknownVersion   = errors.New("database has a migration unknown to this build")
+	ErrInvalidSteps     = errors.New("number of steps must be positive")
+)
+
+// fileName matches migration files like 0001_initial.up.sql.
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// Checksum is a sha256 of Up, it guards against editing migrations that are already applied.
+	Checksum string
+}
+
+// State is a migration with the moment it was applied, nil if it's pending.
+type State struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Dialect holds what differs between databases: the embedded migrations,
+// the bookkeeping queries and the way to keep other processes out.
+type Dialect struct {
+	Name  string
+	Files fs.FS
+
+	CreateTable   string
+	ListApplied   string
+	InsertApplied string
+	DeleteApplied string
+
+	// Lock blocks until no other process migrates the database, unlock must be called on conn.
+	Lock func(ctx context.Context, conn *sql.Conn) (unlock func() error, err error)
+}
+
+type applied struct {
+	version   int64
+	checksum  string
+	appliedAt time.Time
+}
+
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+func New(db *sql.DB, dialect Dialect) (*Migrator, error) {
+	migrations, err := load(dialect.Files)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// load reads migrations from the root of files, every version needs both up and down.
+func load(files fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		b, err := fs.ReadFile(files, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has files with different names", version)
+		}
+		if m[3] == "up" {
+			mig.Up = string(b)
+			sum := sha256.Sum256(b)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mig.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %d needs both up and down files", mig.Version)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up applies all pending migrations in order and returns them.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, states []State) error {
+		for _, s := range states {
+			if s.AppliedAt != nil {
+				continue
+			}
+			if err := m.apply(ctx, conn, s.Migration.Up, m.dialect.InsertApplied, s.Version, s.Name, s.Checksum); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", s.Version, s.Name, err)
+			}
+			done = append(done, s.Migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the last steps applied migrations and returns them.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps <= 0 {
+		return nil, ErrInvalidSteps
+	}
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, states []State) error {
+		for i := len(states) - 1; i >= 0 && len(done) < steps; i-- {
+			s := states[i]
+			if s.AppliedAt == nil {
+				continue
+			}
+			if err := m.apply(ctx, conn, s.Migration.Down, m.dialect.DeleteApplied, s.Version); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", s.Version, s.Name, err)
+			}
+			done = append(done, s.Migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status returns every known migration and whether it's applied.
+func (m *Migrator) Status(ctx context.Context) ([]State, error) {
+	var res []State
+	err := m.locked(ctx, func(conn *sql.Conn, states []State) error {
+		res = states
+		return nil
+	})
+	return res, err
+}
+
+// locked runs fn holding the migration lock, after checking applied migrations against the embedded ones.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, states []State) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := m.dialect.Lock(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := unlock(); err == nil {
+			err = unlockErr
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, m.dialect.CreateTable); err != nil {
+		return err
+	}
+	states, err := m.states(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, states)
+}
+
+func (m *Migrator) states(ctx context.Context, conn *sql.Conn) ([]State, error) {
+	rows, err := conn.QueryContext(ctx, m.dialect.ListApplied)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appliedByVersion := make(map[int64]applied)
+	for rows.Next() {
+		a := applied{}
+		if err := rows.Scan(&a.version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		appliedByVersion[a.version] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	states := make([]State, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := State{Migration: mig}
+		if a, ok := appliedByVersion[mig.Version]; ok {
+			if a.checksum != mig.Checksum {
+				return nil, fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, ErrChecksumMismatch)
+			}
+			s.AppliedAt = &a.appliedAt
+			delete(appliedByVersion, mig.Version)
+		}
+		states = append(states, s)
+	}
+	for version := range appliedByVersion {
+		return nil, fmt.Errorf("migration %d: %w", version, ErrUnknownVersion)
+	}
+	return states, nil
+}
+
+// apply runs the migration script and records it in a single transaction.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, script, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
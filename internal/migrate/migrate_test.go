@@ -0,0 +1,162 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// initdb is the schema created by the first version of initdb.sql, before the migrations.
+const initdb = `
+create table accounts
+(
+    id        serial primary key,
+    login     varchar(255) not null,
+    password  varchar(255) not null,
+
+    createdAt timestamp without time zone default now(),
+    updatedAt timestamp without time zone default now(),
+
+    unique (login)
+);
+
+create table links
+(
+    linkId varchar(255) primary key,
+    link text,
+    linkStatus int default 0,
+    accountId varchar(255)
+);
+`
+
+// postgresSchema returns a connection to an empty schema of the LENKE_TEST_POSTGRES_DSN database,
+// the schema is dropped when the test ends.
+func postgresSchema(t *testing.T) *sql.DB {
+	dsn := os.Getenv("LENKE_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("LENKE_TEST_POSTGRES_DSN is not set")
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	// search_path is set per session, a single connection keeps every query in the schema
+	conn.SetMaxOpenConns(1)
+
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	if _, err := conn.Exec(`create schema ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := conn.Exec(`drop schema ` + schema + ` cascade`); err != nil {
+			t.Error(err)
+		}
+	})
+	if _, err := conn.Exec(`set search_path to ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// TestUpFromInitdb migrates a database created by initdb.sql and checks its rows end up in the current schema.
+func TestUpFromInitdb(t *testing.T) {
+	ctx := context.Background()
+	conn := postgresSchema(t)
+	if _, err := conn.Exec(initdb); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`insert into accounts(login, password) values ('alice', 'hash')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`insert into links(linkId, link, accountId) values ('owned', 'https://a.example', '1'), ('anonymous', 'https://b.example', '')`); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(conn, Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != len(m.migrations) {
+		t.Fatalf("applied %d migrations, want %d", len(done), len(m.migrations))
+	}
+
+	var (
+		accountId sql.NullInt64
+		password  string
+		clicks    int64
+		maxClicks sql.NullInt64
+		createdAt time.Time
+	)
+	row := conn.QueryRow(`select accountId, password, clicks, maxClicks, createdAt from links where linkId = 'owned'`)
+	if err := row.Scan(&accountId, &password, &clicks, &maxClicks, &createdAt); err != nil {
+		t.Fatal(err)
+	}
+	if !accountId.Valid || accountId.Int64 != 1 || password != "" || clicks != 0 || maxClicks.Valid {
+		t.Errorf("owned link migrated to accountId %v, password %q, clicks %d, maxClicks %v", accountId, password, clicks, maxClicks)
+	}
+	if err := conn.QueryRow(`select accountId from links where linkId = 'anonymous'`).Scan(&accountId); err != nil {
+		t.Fatal(err)
+	}
+	if accountId.Valid {
+		t.Errorf("anonymous link migrated to accountId %d", accountId.Int64)
+	}
+
+	var indexes int
+	err = conn.QueryRow(`select count(*) from pg_indexes where schemaname = current_schema() and indexname = 'links_accountid_link_idx'`).Scan(&indexes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexes != 1 {
+		t.Error("links_accountid_link_idx is missing")
+	}
+
+	if _, err := m.Down(ctx, len(m.migrations)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestUpDownSqlite applies every migration, reverts them all and applies them again.
+func TestUpDownSqlite(t *testing.T) {
+	ctx := context.Background()
+	conn, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "lenke.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+
+	m, err := New(conn, Sqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := m.Up(ctx); err != nil {
+			t.Fatal(err)
+		}
+		done, err := m.Down(ctx, len(m.migrations))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(done) != len(m.migrations) {
+			t.Fatalf("reverted %d migrations, want %d", len(done), len(m.migrations))
+		}
+	}
+	var tables int
+	if err := conn.QueryRow(`select count(*) from sqlite_master where type = 'table' and name <> 'schema_migrations' and name not like 'sqlite_%'`).Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Errorf("%d tables left after reverting every migration", tables)
+	}
+}
@@ -0,0 +1,51 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io/fs"
+)
+
+// postgresLockId identifies the advisory lock taken while migrating.
+const postgresLockId = 7_241_936_054
+
+//go:embed postgres/*.sql
+var postgresFiles embed.FS
+
+var Postgres = Dialect{
+	Name:  "postgres",
+	Files: sub(postgresFiles, "postgres"),
+
+	CreateTable: `
+		create table if not exists schema_migrations
+		(
+			version   bigint primary key,
+			name      text not null,
+			checksum  varchar(64) not null,
+			appliedAt timestamp with time zone not null default now()
+		)
+	`,
+	ListApplied:   `select version, checksum, appliedAt from schema_migrations order by version`,
+	InsertApplied: `insert into schema_migrations(version, name, checksum) values ($1, $2, $3)`,
+	DeleteApplied: `delete from schema_migrations where version = $1`,
+
+	Lock: func(ctx context.Context, conn *sql.Conn) (func() error, error) {
+		if _, err := conn.ExecContext(ctx, `select pg_advisory_lock($1)`, postgresLockId); err != nil {
+			return nil, err
+		}
+		return func() error {
+			// the session may outlive ctx, so unlocking must not depend on it
+			_, err := conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, postgresLockId)
+			return err
+		}, nil
+	},
+}
+
+func sub(files fs.FS, dir string) fs.FS {
+	res, err := fs.Sub(files, dir)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
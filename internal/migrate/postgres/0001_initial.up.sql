@@ -0,0 +1,49 @@
+-- The schema formerly created by initdb.sql. Databases created by its first version have links
+-- with only four columns, the rest are added separately so that they get the same schema.
+create table if not exists accounts
+(
+    id        serial primary key,
+    login     varchar(255) not null,
+    password  varchar(255) not null,
+
+    createdAt timestamp without time zone default now(),
+    updatedAt timestamp without time zone default now(),
+
+    unique (login)
+);
+
+create table if not exists links
+(
+    linkId     varchar(255) primary key,
+    link       text,
+    linkStatus int default 0,
+    accountId  varchar(255)
+);
+alter table links
+    add column if not exists password  varchar(255) not null default '',
+    add column if not exists expiresAt timestamp with time zone,
+    add column if not exists maxClicks bigint,
+    add column if not exists clicks    bigint not null default 0,
+    add column if not exists createdAt timestamp with time zone not null default now(),
+    add column if not exists updatedAt timestamp with time zone not null default now();
+create index if not exists links_accountid_link_idx on links (accountId, md5(link));
+
+create table if not exists clicks
+(
+    id        serial primary key,
+    linkId    varchar(255) not null references links (linkId) on delete cascade,
+    clickedAt timestamp with time zone not null default now(),
+    referrer  text not null default '',
+    userAgent text not null default '',
+    ipHash    varchar(64) not null default ''
+);
+create index if not exists clicks_linkid_idx on clicks (linkId);
+
+create table if not exists link_revisions
+(
+    id        serial primary key,
+    linkId    varchar(255) not null references links (linkId) on delete cascade,
+    link      text not null,
+    changedAt timestamp with time zone not null default now()
+);
+create index if not exists link_revisions_linkid_idx on link_revisions (linkId);
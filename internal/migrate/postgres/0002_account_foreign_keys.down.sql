@@ -0,0 +1,12 @@
+alter table accounts
+    alter column createdAt drop not null,
+    alter column createdAt type timestamp without time zone,
+    alter column updatedAt drop not null,
+    alter column updatedAt type timestamp without time zone;
+
+alter table links
+    alter column linkStatus drop not null;
+
+alter table links
+    drop constraint links_accountid_fkey,
+    alter column accountId type varchar(255) using coalesce(accountId::text, '');
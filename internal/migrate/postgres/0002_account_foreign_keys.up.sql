@@ -0,0 +1,20 @@
+-- Links reference their owner instead of keeping its id as text, anonymous links have null owner.
+update links
+set accountId = null
+where accountId !~ '^[0-9]+$'
+   or not exists(select 1 from accounts where accounts.id::text = links.accountId);
+
+alter table links
+    alter column accountId type integer using accountId::integer,
+    add constraint links_accountid_fkey foreign key (accountId) references accounts (id) on delete cascade;
+
+update links set linkStatus = 0 where linkStatus is null;
+alter table links
+    alter column linkStatus set not null;
+
+-- timestamps were written by now() in the session time zone
+alter table accounts
+    alter column createdAt type timestamp with time zone,
+    alter column createdAt set not null,
+    alter column updatedAt type timestamp with time zone,
+    alter column updatedAt set not null;
@@ -0,0 +1,15 @@
+-- Refresh tokens are stored hashed, tokens rotated from the same sign in share a family.
+-- Access tokens are revoked through the refresh token they were issued with.
+create table refresh_tokens
+(
+    hash          varchar(64) primary key,
+    familyId      varchar(32) not null,
+    accountId     integer     not null references accounts (id) on delete cascade,
+    accessTokenId varchar(32) not null,
+    expiresAt     timestamp with time zone not null,
+    usedAt        timestamp with time zone,
+    revokedAt     timestamp with time zone,
+    createdAt     timestamp with time zone not null default now()
+);
+create index refresh_tokens_familyid_idx on refresh_tokens (familyId);
+create index refresh_tokens_accesstokenid_idx on refresh_tokens (accessTokenId);
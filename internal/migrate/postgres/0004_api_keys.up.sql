@@ -0,0 +1,15 @@
+-- Api keys let scripts act on behalf of an account, they are stored hashed.
+-- Scopes are space separated.
+create table api_keys
+(
+    id         varchar(32) primary key,
+    accountId  integer     not null references accounts (id) on delete cascade,
+    name       text        not null,
+    hash       varchar(64) not null unique,
+    prefix     text        not null,
+    scopes     text        not null,
+    expiresAt  timestamp with time zone,
+    lastUsedAt timestamp with time zone,
+    createdAt  timestamp with time zone not null default now()
+);
+create index api_keys_accountid_idx on api_keys (accountId);
@@ -0,0 +1,9 @@
+-- Failed sign ins per login and per ip address, shared by all instances.
+create table login_attempts
+(
+    attemptKey    text primary key,
+    failures      integer                  not null,
+    lastFailureAt timestamp with time zone not null,
+    lockedUntil   timestamp with time zone
+);
+create index login_attempts_lastfailureat_idx on login_attempts (lastFailureAt);
@@ -0,0 +1,6 @@
+-- Named counters shared by all instances, the counter id generator reserves its values here.
+create table sequences
+(
+    name  varchar(255) primary key,
+    value bigint not null
+);
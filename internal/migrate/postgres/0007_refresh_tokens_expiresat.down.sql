@@ -0,0 +1 @@
+drop index refresh_tokens_expiresat_idx;
@@ -0,0 +1,2 @@
+-- Expired refresh tokens are deleted by families, starting from the tokens expired before a time.
+create index refresh_tokens_expiresat_idx on refresh_tokens (expiresAt);
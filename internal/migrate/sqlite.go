@@ -0,0 +1,41 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+)
+
+//go:embed sqlite/*.sql
+var sqliteFiles embed.FS
+
+var Sqlite = Dialect{
+	Name:  "sqlite",
+	Files: sub(sqliteFiles, "sqlite"),
+
+	CreateTable: `
+		create table if not exists schema_migrations
+		(
+			version   integer primary key,
+			name      text not null,
+			checksum  text not null,
+			appliedAt timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+		)
+	`,
+	ListApplied:   `select version, checksum, appliedAt from schema_migrations order by version`,
+	InsertApplied: `insert into schema_migrations(version, name, checksum) values (?, ?, ?)`,
+	DeleteApplied: `delete from schema_migrations where version = ?`,
+
+	// sqlite has no advisory locks, a concurrent migration fails on the schema_migrations
+	// primary key instead of applying a version twice. Foreign keys are off while migrating, since sqlite changes a table by
+	// rebuilding it and dropping the old one would cascade to the referencing rows.
+	Lock: func(ctx context.Context, conn *sql.Conn) (func() error, error) {
+		if _, err := conn.ExecContext(ctx, `pragma foreign_keys = off`); err != nil {
+			return nil, err
+		}
+		return func() error {
+			_, err := conn.ExecContext(context.Background(), `pragma foreign_keys = on`)
+			return err
+		}, nil
+	},
+}
@@ -0,0 +1,4 @@
+drop table if exists link_revisions;
+drop table if exists clicks;
+drop table if exists links;
+drop table if exists accounts;
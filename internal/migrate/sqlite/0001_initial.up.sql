@@ -0,0 +1,46 @@
+-- Mirrors the postgres schema of the same version, so both backends share migration versions.
+create table if not exists accounts
+(
+    id        integer primary key autoincrement,
+    login     text not null unique,
+    password  text not null,
+
+    createdAt timestamp default (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updatedAt timestamp default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+create table if not exists links
+(
+    linkId     text primary key,
+    link       text,
+    linkStatus integer default 0,
+    accountId  text,
+    password   text not null default '',
+    expiresAt  timestamp,
+    maxClicks  integer,
+    clicks     integer not null default 0,
+
+    createdAt  timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updatedAt  timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+create index if not exists links_accountid_link_idx on links (accountId, link);
+
+create table if not exists clicks
+(
+    id        integer primary key autoincrement,
+    linkId    text not null references links (linkId) on delete cascade,
+    clickedAt timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    referrer  text not null default '',
+    userAgent text not null default '',
+    ipHash    text not null default ''
+);
+create index if not exists clicks_linkid_idx on clicks (linkId);
+
+create table if not exists link_revisions
+(
+    id        integer primary key autoincrement,
+    linkId    text not null references links (linkId) on delete cascade,
+    link      text not null,
+    changedAt timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+create index if not exists link_revisions_linkid_idx on link_revisions (linkId);
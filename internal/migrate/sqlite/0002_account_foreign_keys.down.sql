@@ -0,0 +1,22 @@
+create table links_old
+(
+    linkId     text primary key,
+    link       text,
+    linkStatus integer default 0,
+    accountId  text,
+    password   text not null default '',
+    expiresAt  timestamp,
+    maxClicks  integer,
+    clicks     integer not null default 0,
+
+    createdAt  timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updatedAt  timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+insert into links_old(linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt)
+select linkId, link, linkStatus, coalesce(cast(accountId as text), ''), password, expiresAt, maxClicks, clicks, createdAt, updatedAt
+from links;
+
+drop table links;
+alter table links_old rename to links;
+create index links_accountid_link_idx on links (accountId, link);
@@ -0,0 +1,34 @@
+-- Links reference their owner instead of keeping its id as text, anonymous links have null owner.
+-- sqlite can't add a foreign key to an existing table, so links is rebuilt; foreign keys are
+-- off while migrating, otherwise dropping the old table would delete clicks and revisions.
+create table links_new
+(
+    linkId     text primary key,
+    link       text,
+    linkStatus integer not null default 0,
+    accountId  integer references accounts (id) on delete cascade,
+    password   text not null default '',
+    expiresAt  timestamp,
+    maxClicks  integer,
+    clicks     integer not null default 0,
+
+    createdAt  timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updatedAt  timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+
+insert into links_new(linkId, link, linkStatus, accountId, password, expiresAt, maxClicks, clicks, createdAt, updatedAt)
+select linkId,
+       link,
+       coalesce(linkStatus, 0),
+       (select accounts.id from accounts where cast(accounts.id as text) = links.accountId),
+       password,
+       expiresAt,
+       maxClicks,
+       clicks,
+       createdAt,
+       updatedAt
+from links;
+
+drop table links;
+alter table links_new rename to links;
+create index links_accountid_link_idx on links (accountId, link);
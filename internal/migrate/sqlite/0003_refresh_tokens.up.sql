@@ -0,0 +1,15 @@
+-- Refresh tokens are stored hashed, tokens rotated from the same sign in share a family.
+-- Access tokens are revoked through the refresh token they were issued with.
+create table refresh_tokens
+(
+    hash          text primary key,
+    familyId      text      not null,
+    accountId     integer   not null references accounts (id) on delete cascade,
+    accessTokenId text      not null,
+    expiresAt     timestamp not null,
+    usedAt        timestamp,
+    revokedAt     timestamp,
+    createdAt     timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+create index refresh_tokens_familyid_idx on refresh_tokens (familyId);
+create index refresh_tokens_accesstokenid_idx on refresh_tokens (accessTokenId);
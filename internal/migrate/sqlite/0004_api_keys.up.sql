@@ -0,0 +1,15 @@
+-- Api keys let scripts act on behalf of an account, they are stored hashed.
+-- Scopes are space separated.
+create table api_keys
+(
+    id         text primary key,
+    accountId  integer   not null references accounts (id) on delete cascade,
+    name       text      not null,
+    hash       text      not null unique,
+    prefix     text      not null,
+    scopes     text      not null,
+    expiresAt  timestamp,
+    lastUsedAt timestamp,
+    createdAt  timestamp not null default (strftime('%Y-%m-%d %H:%M:%f', 'now'))
+);
+create index api_keys_accountid_idx on api_keys (accountId);
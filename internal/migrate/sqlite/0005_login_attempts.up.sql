@@ -0,0 +1,9 @@
+-- Failed sign ins per login and per ip address, shared by all processes using the file.
+create table login_attempts
+(
+    attemptKey    text primary key,
+    failures      integer   not null,
+    lastFailureAt timestamp not null,
+    lockedUntil   timestamp
+);
+create index login_attempts_lastfailureat_idx on login_attempts (lastFailureAt);
@@ -0,0 +1,6 @@
+-- Named counters shared by all processes using the file, the counter id generator reserves its values here.
+create table sequences
+(
+    name  text primary key,
+    value integer not null
+);
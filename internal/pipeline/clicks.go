@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/click"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClickBufferFull   = errors.New("click buffer is full")
+	ErrClickBufferClosed = errors.New("click buffer is closed")
+)
+
+var (
+	clicksQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "clicks_queue_depth",
+		Help: "Click events waiting to be written",
+	})
+	clicksDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "clicks_dropped_total",
+		Help: "Click events dropped because the queue was full",
+	})
+	clicksWritten = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "clicks_written_total",
+		Help: "Click events written to storage",
+	})
+	clicksFailed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "clicks_write_failed_total",
+		Help: "Click events lost because a batch write failed",
+	})
+)
+
+// ClickBuffer queues clicks in a bounded channel and writes them to the underlying
+// storage in batches, so redirects don't wait for the database.
+type ClickBuffer struct {
+	storage       click.Interface
+	events        chan click.Click
+	batchSize     int
+	flushInterval time.Duration
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+func NewClickBuffer(storage click.Interface, capacity, batchSize int, flushInterval time.Duration) *ClickBuffer {
+	b := &ClickBuffer{
+		storage:       storage,
+		events:        make(chan click.Click, capacity),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// StoreClick enqueues the click without blocking, the click is dropped if the queue is full.
+func (b *ClickBuffer) StoreClick(ctx context.Context, c click.Click) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		clicksDropped.Inc()
+		return ErrClickBufferClosed
+	}
+	select {
+	case b.events <- c:
+		clicksQueueDepth.Set(float64(len(b.events)))
+		return nil
+	default:
+		clicksDropped.Inc()
+		return ErrClickBufferFull
+	}
+}
+
+func (b *ClickBuffer) StoreClicks(ctx context.Context, clicks []click.Click) error {
+	return b.storage.StoreClicks(ctx, clicks)
+}
+
+func (b *ClickBuffer) CountClicks(ctx context.Context, linkId string) (int, int, error) {
+	return b.storage.CountClicks(ctx, linkId)
+}
+
+func (b *ClickBuffer) CountClicksPer(ctx context.Context, linkId string, period click.Period) ([]click.Bucket, error) {
+	return b.storage.CountClicksPer(ctx, linkId, period)
+}
+
+// Close stops accepting clicks and returns once the queued ones are written,
+// or with the ctx error if it's done first.
+func (b *ClickBuffer) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.events)
+	}
+	b.mu.Unlock()
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *ClickBuffer) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]click.Click, 0, b.batchSize)
+	for {
+		select {
+		case c, ok := <-b.events:
+			if !ok {
+				b.flush(batch)
+				return
+			}
+			clicksQueueDepth.Set(float64(len(b.events)))
+			batch = append(batch, c)
+			if len(batch) >= b.batchSize {
+				batch = b.flush(batch)
+			}
+		case <-ticker.C:
+			batch = b.flush(batch)
+		}
+	}
+}
+
+func (b *ClickBuffer) flush(batch []click.Click) []click.Click {
+	if len(batch) == 0 {
+		return batch
+	}
+	// the batch outlives the requests its clicks came from
+	start := time.Now()
+	if err := b.storage.StoreClicks(context.Background(), batch); err != nil {
+		b.logger("StoreClicks", fmt.Errorf("%d clicks are lost: %w", len(batch), err), start)
+		clicksFailed.Add(float64(len(batch)))
+	} else {
+		clicksWritten.Add(float64(len(batch)))
+	}
+	return batch[:0]
+}
+
+func (b *ClickBuffer) logger(method string, err error, start time.Time) {
+	status := "SUCCESS"
+	if err != nil {
+		status = err.Error()
+	}
+	fmt.Printf("method: %s; status-code: %s; call time: %v; duration: %v;\n",
+		method, status, start, time.Since(start))
+}
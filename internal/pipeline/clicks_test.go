@@ -0,0 +1,178 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/click"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/status"
+	memoryclickrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/clickrepo"
+	memorylinkrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/linkrepo"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"testing"
+	"time"
+)
+
+const (
+	testLinkId = "link"
+	// never is a flush interval longer than any test, batches are only flushed when full.
+	never = time.Hour
+)
+
+var errStorage = errors.New("storage is down")
+
+func newClickStorage(t *testing.T) *memoryclickrepo.Memory {
+	links := memorylinkrepo.NewMemory()
+	_, err := links.StoreLink(context.Background(), link.Link{LinkId: testLinkId, Link: "http://example.com", LinkStatus: status.OK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return memoryclickrepo.NewMemory(links)
+}
+
+// blockingStorage signals every batch write on entered and holds it until release is closed,
+// then fails it with err if it's set.
+type blockingStorage struct {
+	*memoryclickrepo.Memory
+	entered chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func newBlockingStorage(t *testing.T) *blockingStorage {
+	return &blockingStorage{
+		Memory:  newClickStorage(t),
+		entered: make(chan struct{}, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (s *blockingStorage) StoreClicks(ctx context.Context, clicks []click.Click) error {
+	s.entered <- struct{}{}
+	<-s.release
+	if s.err != nil {
+		return s.err
+	}
+	return s.Memory.StoreClicks(ctx, clicks)
+}
+
+func storeClicks(t *testing.T, b *ClickBuffer, n int) {
+	for i := 0; i < n; i++ {
+		if err := b.StoreClick(context.Background(), click.Click{LinkId: testLinkId, ClickedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// waitClicks waits for the storage to count want clicks of the test link.
+func waitClicks(t *testing.T, storage click.Interface, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		total, _, err := storage.CountClicks(context.Background(), testLinkId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d clicks, want %d", total, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestClickBufferBatchSize checks that a batch is written as soon as it's full.
+func TestClickBufferBatchSize(t *testing.T) {
+	storage := newClickStorage(t)
+	b := NewClickBuffer(storage, 10, 3, never)
+	defer b.Close(context.Background())
+
+	storeClicks(t, b, 2)
+	time.Sleep(20 * time.Millisecond)
+	if total, _, _ := storage.CountClicks(context.Background(), testLinkId); total != 0 {
+		t.Errorf("got %d clicks written before the batch is full", total)
+	}
+	storeClicks(t, b, 1)
+	waitClicks(t, storage, 3)
+}
+
+// TestClickBufferFlushInterval checks that a batch which isn't full is written on the interval.
+func TestClickBufferFlushInterval(t *testing.T) {
+	storage := newClickStorage(t)
+	b := NewClickBuffer(storage, 10, 100, 10*time.Millisecond)
+	defer b.Close(context.Background())
+
+	storeClicks(t, b, 2)
+	waitClicks(t, storage, 2)
+}
+
+// TestClickBufferFull checks that clicks are dropped and counted when the queue is full.
+func TestClickBufferFull(t *testing.T) {
+	storage := newBlockingStorage(t)
+	b := NewClickBuffer(storage, 1, 1, never)
+
+	// the first click is held by the write, the second one fills the queue
+	storeClicks(t, b, 1)
+	<-storage.entered
+	storeClicks(t, b, 1)
+	dropped := testutil.ToFloat64(clicksDropped)
+	if err := b.StoreClick(context.Background(), click.Click{LinkId: testLinkId}); err != ErrClickBufferFull {
+		t.Errorf("got %v, want %v", err, ErrClickBufferFull)
+	}
+	if d := testutil.ToFloat64(clicksDropped) - dropped; d != 1 {
+		t.Errorf("dropped counter grew by %v, want 1", d)
+	}
+
+	close(storage.release)
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitClicks(t, storage, 2)
+	if err := b.StoreClick(context.Background(), click.Click{LinkId: testLinkId}); err != ErrClickBufferClosed {
+		t.Errorf("click after close: got %v, want %v", err, ErrClickBufferClosed)
+	}
+}
+
+// TestClickBufferClose checks that closing writes the queued clicks and gives up at the ctx deadline.
+func TestClickBufferClose(t *testing.T) {
+	storage := newClickStorage(t)
+	b := NewClickBuffer(storage, 10, 100, never)
+	storeClicks(t, b, 5)
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitClicks(t, storage, 5)
+
+	blocking := newBlockingStorage(t)
+	b = NewClickBuffer(blocking, 10, 100, never)
+	storeClicks(t, b, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.Close(ctx); err != context.DeadlineExceeded {
+		t.Errorf("close with a write in progress: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(blocking.release)
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitClicks(t, blocking, 1)
+}
+
+// TestClickBufferWriteFailed checks that clicks of a failed batch write are counted as lost.
+func TestClickBufferWriteFailed(t *testing.T) {
+	storage := newBlockingStorage(t)
+	storage.err = errStorage
+	close(storage.release)
+	b := NewClickBuffer(storage, 10, 2, never)
+
+	failed := testutil.ToFloat64(clicksFailed)
+	storeClicks(t, b, 2)
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if f := testutil.ToFloat64(clicksFailed) - failed; f != 2 {
+		t.Errorf("failed counter grew by %v, want 2", f)
+	}
+}
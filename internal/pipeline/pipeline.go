@@ -1,49 +1,62 @@
 package pipeline
 
 import (
+	"context"
 	"fmt"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/status"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/usecases/link"
 	"net/http"
+	"sync"
 	"time"
 )
 
-const workerCount = 4
-
-func LinkStatusUpdater(luc *link.LinkUseCases) {
+// LinkStatusUpdater checks destinations of user links with workerCount workers every interval
+// until ctx is cancelled. The returned channel is closed once the producer and all workers have stopped.
+func LinkStatusUpdater(ctx context.Context, luc *link.LinkUseCases, workerCount int, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		links := make(chan link.Link, workerCount)
 
+		var wg sync.WaitGroup
+		wg.Add(workerCount + 1)
 		go func() {
+			defer wg.Done()
+			defer close(links)
 			for {
-				userLinks, err := luc.LinkStorage.GetAllUserLinks()
+				userLinks, err := luc.LinkStorage.GetAllUserLinks(ctx)
 				if err != nil {
 					fmt.Println("Bad `GetAllUserLinks` request to link database")
 				}
 				for _, lnk := range userLinks {
-					links <- link.Link{LinkId: lnk.LinkId, Link: lnk.Link, LinkStatus: lnk.LinkStatus}
+					select {
+					case links <- link.Link{LinkId: lnk.LinkId, Link: lnk.Link, LinkStatus: lnk.LinkStatus}:
+					case <-ctx.Done():
+						return
+					}
+				}
+				select {
+				case <-time.After(interval):
+				case <-ctx.Done():
+					return
 				}
-				time.Sleep(5 * time.Second)
 			}
 		}()
 
 		for i := 0; i < workerCount; i++ {
 			go func() {
+				defer wg.Done()
 				for {
 					lnk, ok := <-links
 					if !ok {
-						fmt.Println("links channel has been closed and drained")
 						return
 					}
-					res, err := http.Get(lnk.Link)
+					s, err := checkLink(ctx, lnk.Link)
 					if err != nil {
 						fmt.Println(err)
+						continue
 					}
-					s := status.OK
-					if res.StatusCode >= http.StatusBadRequest {
-						s = status.Failed
-					}
-					err = luc.LinkStorage.UpdateLinkStatusByLinkId(lnk.LinkId, s)
+					err = luc.LinkStorage.UpdateLinkStatusByLinkId(ctx, lnk.LinkId, s)
 					if err != nil {
 						fmt.Println(err)
 					}
@@ -54,5 +67,28 @@ func LinkStatusUpdater(luc *link.LinkUseCases) {
 				}
 			}()
 		}
+		wg.Wait()
 	}()
+	return done
+}
+
+// checkLink requests the destination, an unreachable one is reported as failed.
+func checkLink(ctx context.Context, target string) (status.LinkStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return status.Failed, nil
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return status.Unknown, ctx.Err()
+		}
+		fmt.Println(err)
+		return status.Failed, nil
+	}
+	res.Body.Close()
+	if res.StatusCode >= http.StatusBadRequest {
+		return status.Failed, nil
+	}
+	return status.OK, nil
 }
@@ -0,0 +1,109 @@
+package idgen
+
+import (
+	"context"
+	"crypto/sha256"
+	"math/big"
+	"sync"
+)
+
+// multiplier spreads consecutive counter values over the whole id space, it's a prime
+// larger than any alphabet, so it's coprime with the id space size.
+const multiplier = 1580030173
+
+const (
+	// sequenceName is the counter of link ids in the Sequence.
+	sequenceName = "link_ids"
+	// counterBlock values are reserved at once, the unused rest of a block is skipped on restart.
+	counterBlock = 100
+)
+
+// Sequence hands out values of named counters, shared by every instance using the storage.
+type Sequence interface {
+	// Reserve advances the counter by n and returns its previous value, the n values from it belong to the caller.
+	Reserve(ctx context.Context, name string, n int64) (int64, error)
+}
+
+// Counter generates ids from a counter kept in a Sequence. Every value is mapped to the id space
+// of the requested length with n -> (n*m + k) mod size, which is a bijection while
+// m is coprime with size, and encoded with an alphabet shuffled by the salt. Ids are
+// unique until the counter wraps around the id space, yet don't look sequential.
+//
+// The ids are only short, not secret: ids generated one after another differ by the constant
+// m mod size, so anyone holding two of them can derive the ids of other links. Use Random
+// for links that must not be enumerated.
+type Counter struct {
+	alphabet string
+	offset   *big.Int
+	sequence Sequence
+
+	mu sync.Mutex
+	// next is the value to use now, end is past the last reserved one.
+	next, end uint64
+}
+
+// NewCounter creates a generator drawing values from the sequence, the same salt must be kept
+// between restarts for ids to stay unique.
+func NewCounter(alphabet, salt string, sequence Sequence) (*Counter, error) {
+	if err := validateAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(salt))
+	return &Counter{
+		alphabet: shuffle(alphabet, sum[:]),
+		offset:   new(big.Int).SetBytes(sum[:]),
+		sequence: sequence,
+	}, nil
+}
+
+func (g *Counter) Generate(length int) (string, error) {
+	if length <= 0 {
+		return "", ErrInvalidLength
+	}
+	n, err := g.take()
+	if err != nil {
+		return "", err
+	}
+
+	base := big.NewInt(int64(len(g.alphabet)))
+	size := new(big.Int).Exp(base, big.NewInt(int64(length)), nil)
+	x := new(big.Int).SetUint64(n)
+	x.Mul(x, big.NewInt(multiplier))
+	x.Add(x, g.offset)
+	x.Mod(x, size)
+
+	res := make([]byte, length)
+	digit := new(big.Int)
+	for i := length - 1; i >= 0; i-- {
+		x.DivMod(x, base, digit)
+		res[i] = g.alphabet[digit.Int64()]
+	}
+	return string(res), nil
+}
+
+// take returns the next counter value, reserving a new block when the current one is used up.
+func (g *Counter) take() (uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.next == g.end {
+		// Generate has no context, the storage fails a stuck reservation with its own timeouts
+		first, err := g.sequence.Reserve(context.Background(), sequenceName, counterBlock)
+		if err != nil {
+			return 0, err
+		}
+		g.next, g.end = uint64(first), uint64(first)+counterBlock
+	}
+	n := g.next
+	g.next++
+	return n, nil
+}
+
+// shuffle permutes the alphabet with Fisher-Yates driven by the key bytes.
+func shuffle(alphabet string, key []byte) string {
+	res := []byte(alphabet)
+	for i, j := len(res)-1, 0; i > 0; i, j = i-1, j+1 {
+		k := int(key[j%len(key)]) % (i + 1)
+		res[i], res[k] = res[k], res[i]
+	}
+	return string(res)
+}
@@ -0,0 +1,29 @@
+package idgen
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidAlphabet = errors.New("alphabet must have at least two distinct url safe characters")
+	ErrInvalidLength   = errors.New("id length must be positive")
+)
+
+// Base62 is the default alphabet of generated ids.
+const Base62 = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// urlSafe are the characters that don't need escaping in a url path.
+const urlSafe = Base62 + "-_"
+
+func validateAlphabet(alphabet string) error {
+	if len(alphabet) < 2 {
+		return ErrInvalidAlphabet
+	}
+	for i := 0; i < len(alphabet); i++ {
+		if !strings.ContainsRune(urlSafe, rune(alphabet[i])) || strings.IndexByte(alphabet[i+1:], alphabet[i]) >= 0 {
+			return ErrInvalidAlphabet
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package idgen
+
+import (
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/sequencerepo"
+	"strings"
+	"testing"
+)
+
+func newCounter(t *testing.T, salt string, sequence Sequence) *Counter {
+	g, err := NewCounter(Base62, salt, sequence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// TestCounterUnique checks that two instances sharing a sequence never generate the same id,
+// within a reserved block and across the boundaries of the blocks they take turns reserving.
+func TestCounterUnique(t *testing.T) {
+	sequence := sequencerepo.NewMemory()
+	instances := []*Counter{newCounter(t, "salt", sequence), newCounter(t, "salt", sequence)}
+	seen := make(map[string]bool)
+	for i := 0; i < 5*counterBlock+7; i++ {
+		for _, g := range instances {
+			id, err := g.Generate(3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(id) != 3 {
+				t.Fatalf("got id %q, want 3 characters", id)
+			}
+			if seen[id] {
+				t.Fatalf("id %q is generated twice after %d ids", id, len(seen))
+			}
+			seen[id] = true
+		}
+	}
+}
+
+// TestCounterSalt checks that the salt changes the generated ids.
+func TestCounterSalt(t *testing.T) {
+	a := newCounter(t, "a", sequencerepo.NewMemory())
+	b := newCounter(t, "b", sequencerepo.NewMemory())
+	same := 0
+	for i := 0; i < 10; i++ {
+		idA, err := a.Generate(6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idB, err := b.Generate(6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if idA == idB {
+			same++
+		}
+	}
+	if same > 0 {
+		t.Errorf("%d of 10 ids are the same with different salts", same)
+	}
+}
+
+// TestInvalidAlphabet checks that both generators reject alphabets ids can't be built from.
+func TestInvalidAlphabet(t *testing.T) {
+	for _, alphabet := range []string{"", "a", "aba", "ab/", "ab ", "абв"} {
+		if _, err := NewRandom(alphabet); err != ErrInvalidAlphabet {
+			t.Errorf("random with %q: got %v, want %v", alphabet, err, ErrInvalidAlphabet)
+		}
+		if _, err := NewCounter(alphabet, "salt", sequencerepo.NewMemory()); err != ErrInvalidAlphabet {
+			t.Errorf("counter with %q: got %v, want %v", alphabet, err, ErrInvalidAlphabet)
+		}
+	}
+}
+
+// TestRandomAlphabet checks that random ids have the requested length and only characters
+// of the alphabet, including one whose size doesn't divide a byte.
+func TestRandomAlphabet(t *testing.T) {
+	const alphabet = "xyz-_"
+	g, err := NewRandom(alphabet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := make(map[rune]bool)
+	for i := 0; i < 100; i++ {
+		id, err := g.Generate(20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(id) != 20 {
+			t.Fatalf("got id %q, want 20 characters", id)
+		}
+		for _, r := range id {
+			if !strings.ContainsRune(alphabet, r) {
+				t.Fatalf("id %q has %q out of the alphabet", id, r)
+			}
+			used[r] = true
+		}
+	}
+	if len(used) != len(alphabet) {
+		t.Errorf("2000 characters use only %d of the alphabet", len(used))
+	}
+	if _, err := g.Generate(0); err != ErrInvalidLength {
+		t.Errorf("zero length: got %v, want %v", err, ErrInvalidLength)
+	}
+}
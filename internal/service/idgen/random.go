@@ -0,0 +1,47 @@
+package idgen
+
+import (
+	"crypto/rand"
+)
+
+// Random generates ids of uniformly distributed characters read from crypto/rand,
+// so they can't be predicted or enumerated.
+type Random struct {
+	alphabet string
+	// limit is the largest multiple of the alphabet size that fits in a byte,
+	// bytes past it are skipped to avoid modulo bias.
+	limit int
+}
+
+func NewRandom(alphabet string) (*Random, error) {
+	if err := validateAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	return &Random{
+		alphabet: alphabet,
+		limit:    256 - 256%len(alphabet),
+	}, nil
+}
+
+func (g *Random) Generate(length int) (string, error) {
+	if length <= 0 {
+		return "", ErrInvalidLength
+	}
+	res := make([]byte, 0, length)
+	buf := make([]byte, length*2)
+	for len(res) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= g.limit {
+				continue
+			}
+			res = append(res, g.alphabet[int(b)%len(g.alphabet)])
+			if len(res) == length {
+				break
+			}
+		}
+	}
+	return string(res), nil
+}
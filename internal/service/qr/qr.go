@@ -0,0 +1,137 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+
+	"github.com/skip2/go-qrcode"
+)
+
+var (
+	ErrInvalidSize   = errors.New("size is out of range")
+	ErrInvalidMargin = errors.New("margin is out of range")
+	ErrInvalidLevel  = errors.New("unknown error correction level")
+)
+
+const (
+	DefaultSize   = 256
+	DefaultMargin = 4
+	DefaultLevel  = "M"
+
+	minSize   = 64
+	maxSize   = 2048
+	maxMargin = 16
+)
+
+var levels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+type Options struct {
+	// Size is the width and height of the image in pixels.
+	Size int
+	// Margin is the width of the quiet zone around the code in modules.
+	Margin int
+	// Level is the error correction level: L, M, Q or H.
+	Level string
+}
+
+// code is a rendered matrix of modules together with its placement in the image.
+type code struct {
+	modules [][]bool
+	size    int
+	scale   int
+	offset  int
+}
+
+func encode(content string, opts Options) (code, error) {
+	if opts.Size < minSize || opts.Size > maxSize {
+		return code{}, ErrInvalidSize
+	}
+	if opts.Margin < 0 || opts.Margin > maxMargin {
+		return code{}, ErrInvalidMargin
+	}
+	level, ok := levels[opts.Level]
+	if !ok {
+		return code{}, ErrInvalidLevel
+	}
+	q, err := qrcode.New(content, level)
+	if err != nil {
+		return code{}, err
+	}
+	q.DisableBorder = true
+	modules := q.Bitmap()
+
+	// modules are never scaled to fractions of a pixel; the leftover pixels widen the quiet zone
+	scale := opts.Size / (len(modules) + 2*opts.Margin)
+	if scale < 1 {
+		return code{}, ErrInvalidSize
+	}
+	return code{
+		modules: modules,
+		size:    opts.Size,
+		scale:   scale,
+		offset:  (opts.Size - scale*len(modules)) / 2,
+	}, nil
+}
+
+// PNG renders content as a black and white QR code image.
+func PNG(content string, opts Options) ([]byte, error) {
+	c, err := encode(content, opts)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewPaletted(image.Rect(0, 0, c.size, c.size), color.Palette{color.White, color.Black})
+	for y, row := range c.modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for dy := 0; dy < c.scale; dy++ {
+				for dx := 0; dx < c.scale; dx++ {
+					img.SetColorIndex(c.offset+x*c.scale+dx, c.offset+y*c.scale+dy, 1)
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG renders content as a QR code vector image, dark modules are drawn as horizontal runs.
+func SVG(content string, opts Options) ([]byte, error) {
+	c, err := encode(content, opts)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		c.size, c.size, c.size, c.size)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, c.size, c.size)
+	for y, row := range c.modules {
+		for x := 0; x < len(row); {
+			if !row[x] {
+				x++
+				continue
+			}
+			run := 0
+			for x+run < len(row) && row[x+run] {
+				run++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv%dh-%dz", c.offset+x*c.scale, c.offset+y*c.scale, run*c.scale, c.scale, run*c.scale)
+			x += run
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes(), nil
+}
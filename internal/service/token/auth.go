@@ -1,6 +1,34 @@
 package token
 
+import (
+	"context"
+	"crypto/rsa"
+	"time"
+)
+
+// Issued is a signed access token with its unique id, the jti claim.
+type Issued struct {
+	Token     string
+	Id        string
+	ExpiresAt time.Time
+}
+
 type Interface interface {
-	IssueToken(userId string) (string, error)
-	UserIdByToken(token string) (string, error)
+	IssueToken(userId string) (Issued, error)
+	// UserIdByToken fails with ErrInvalidToken for malformed, expired or unverifiable tokens
+	// and with ErrRevokedToken for revoked ones, other errors come from the revocation list.
+	UserIdByToken(ctx context.Context, token string) (string, error)
+	// PublicKeys returns the keys tokens can be verified with, including those about to sign.
+	PublicKeys() []PublicKey
+}
+
+// PublicKey verifies tokens whose kid header is Id.
+type PublicKey struct {
+	Id  string
+	Key *rsa.PublicKey
+}
+
+// RevocationList tells whether a token was revoked before it expired.
+type RevocationList interface {
+	IsAccessTokenRevoked(ctx context.Context, tokenId string) (bool, error)
 }
@@ -3,18 +3,29 @@ package token
 import (
 	"github.com/dgrijalva/jwt-go"
 
-	"crypto/rsa"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 )
 
-// todo: key rotation
+var (
+	ErrInvalidToken = errors.New("invalid or expired token")
+	ErrRevokedToken = errors.New("token is revoked")
+)
+
+// JwtHandler signs tokens with the active key and verifies them with any key that isn't retired,
+// keys can be replaced while the handler is in use.
 type JwtHandler struct {
-	publicKey  *rsa.PublicKey
-	privateKey *rsa.PrivateKey
+	mu   sync.RWMutex
+	keys []Key
 
 	expire time.Duration
+	// revocations is consulted for every token, nil if tokens can't be revoked.
+	revocations RevocationList
 }
 
 type Claims struct {
@@ -22,46 +33,119 @@ type Claims struct {
 	jwt.StandardClaims
 }
 
-func NewJwtHandler(privateBytes, publicBytes []byte, keyExpiration time.Duration) (*JwtHandler, error) {
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateBytes)
-	if err != nil {
-		return nil, err
+func NewJwtHandler(keys []Key, keyExpiration time.Duration, revocations RevocationList) (*JwtHandler, error) {
+	j := &JwtHandler{
+		expire:      keyExpiration,
+		revocations: revocations,
 	}
-	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicBytes)
-	if err != nil {
+	if err := j.SetKeys(keys); err != nil {
 		return nil, err
 	}
-	return &JwtHandler{
-		publicKey:  publicKey,
-		privateKey: privateKey,
-		expire:     keyExpiration,
-	}, nil
+	return j, nil
 }
 
-func (j JwtHandler) IssueToken(userId string) (string, error) {
+// SetKeys replaces the keys, e.g. after they are reloaded from disk.
+func (j *JwtHandler) SetKeys(keys []Key) error {
+	if len(keys) == 0 {
+		return ErrNoKeys
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.keys = append([]Key(nil), keys...)
+	return nil
+}
+
+// KeyStates returns the keys with their current states.
+func (j *JwtHandler) KeyStates() []KeyStatus {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return KeyStates(j.keys, time.Now(), j.expire)
+}
+
+func (j *JwtHandler) PublicKeys() []PublicKey {
+	var res []PublicKey
+	for _, s := range j.KeyStates() {
+		if s.State != KeyRetired {
+			res = append(res, PublicKey{Id: s.Id, Key: &s.PrivateKey.PublicKey})
+		}
+	}
+	return res
+}
+
+func (j *JwtHandler) IssueToken(userId string) (Issued, error) {
+	var key Key
+	for _, s := range j.KeyStates() {
+		if s.State == KeyActive {
+			key = s.Key
+		}
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return Issued{}, err
+	}
+	issued := Issued{
+		Id:        hex.EncodeToString(b),
+		ExpiresAt: time.Now().Add(j.expire),
+	}
 	claims := Claims{
 		Id: userId,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(j.expire).Unix(),
+			Id:        issued.Id,
+			ExpiresAt: issued.ExpiresAt.Unix(),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	return token.SignedString(j.privateKey)
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = key.Id
+	token, err := t.SignedString(key.PrivateKey)
+	if err != nil {
+		return Issued{}, err
+	}
+	issued.Token = token
+	return issued, nil
 }
 
-func (j JwtHandler) UserIdByToken(tokenString string) (string, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected token signing method")
-		}
-		return j.publicKey, nil
-	})
+func (j *JwtHandler) UserIdByToken(ctx context.Context, tokenString string) (string, error) {
+	claims, err := j.parse(tokenString)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
 	}
-	claims, ok := token.Claims.(*Claims)
-	if !ok {
-		return "", errors.New("invalid token claims")
+	if j.revocations != nil && claims.StandardClaims.Id != "" {
+		revoked, err := j.revocations.IsAccessTokenRevoked(ctx, claims.StandardClaims.Id)
+		if err != nil {
+			return "", err
+		}
+		if revoked {
+			return "", ErrRevokedToken
+		}
 	}
 	return claims.Id, nil
 }
+
+// parse verifies the token with the key named by its kid header. Tokens issued
+// before keys had ids are tried with every key that isn't retired.
+func (j *JwtHandler) parse(tokenString string) (*Claims, error) {
+	unverified, _, err := new(jwt.Parser).ParseUnverified(tokenString, &Claims{})
+	if err != nil {
+		return nil, err
+	}
+	kid, _ := unverified.Header["kid"].(string)
+
+	err = ErrUnknownKey
+	for _, key := range j.PublicKeys() {
+		if kid != "" && kid != key.Id {
+			continue
+		}
+		claims := &Claims{}
+		_, err = jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+				return nil, fmt.Errorf("unexpected token signing method")
+			}
+			return key.Key, nil
+		})
+		if err == nil {
+			return claims, nil
+		}
+	}
+	return nil, err
+}
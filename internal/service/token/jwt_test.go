@@ -0,0 +1,157 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"testing"
+	"time"
+)
+
+const testExpiration = time.Hour
+
+func newKey(t *testing.T, id string, activeFrom time.Time) Key {
+	privateKey, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Key{Id: id, PrivateKey: privateKey, ActiveFrom: activeFrom}
+}
+
+func kidOf(t *testing.T, tokenString string) string {
+	unverified, _, err := new(jwt.Parser).ParseUnverified(tokenString, &Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := unverified.Header["kid"].(string)
+	return kid
+}
+
+// revoked is a revocation list of the given token ids.
+type revoked map[string]bool
+
+func (r revoked) IsAccessTokenRevoked(ctx context.Context, tokenId string) (bool, error) {
+	return r[tokenId], nil
+}
+
+// TestJwtHandlerKeyRotation checks that tokens are signed by the active key and stay
+// valid while their key verifies, until it's retired.
+func TestJwtHandlerKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	old := newKey(t, "old", now.Add(-48*time.Hour))
+	next := newKey(t, "next", now.Add(time.Hour))
+	j, err := NewJwtHandler([]Key{next, old}, testExpiration, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := j.PublicKeys(); len(keys) != 2 || keys[0].Id != "old" || keys[1].Id != "next" {
+		t.Fatalf("got public keys %v, want old and the pending next", keys)
+	}
+	before, err := j.IssueToken("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := kidOf(t, before.Token); kid != "old" {
+		t.Errorf("token is signed by %q before the rotation, want old", kid)
+	}
+
+	next.ActiveFrom = now.Add(-time.Minute)
+	if err := j.SetKeys([]Key{old, next}); err != nil {
+		t.Fatal(err)
+	}
+	after, err := j.IssueToken("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := kidOf(t, after.Token); kid != "next" {
+		t.Errorf("token is signed by %q after the rotation, want next", kid)
+	}
+	for _, tt := range []struct {
+		token Issued
+		id    string
+	}{{before, "1"}, {after, "2"}} {
+		if id, err := j.UserIdByToken(ctx, tt.token.Token); err != nil || id != tt.id {
+			t.Errorf("got %s, %v, want %s", id, err, tt.id)
+		}
+	}
+
+	// the old key can't have signed a valid token once the next one signs for longer than tokens live
+	next.ActiveFrom = now.Add(-testExpiration - time.Minute)
+	if err := j.SetKeys([]Key{old, next}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := j.PublicKeys(); len(keys) != 1 || keys[0].Id != "next" {
+		t.Errorf("got public keys %v, want just next", keys)
+	}
+	if _, err := j.UserIdByToken(ctx, before.Token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("token of a retired key: got %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+// TestJwtHandlerUserIdByToken checks tokens which must not be accepted, and the ones
+// issued before keys had ids.
+func TestJwtHandlerUserIdByToken(t *testing.T) {
+	ctx := context.Background()
+	key := newKey(t, "key", time.Time{})
+	list := revoked{}
+	j, err := NewJwtHandler([]Key{key}, testExpiration, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(kid string, signer *rsa.PrivateKey, expiresAt time.Time) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{
+			Id:             "1",
+			StandardClaims: jwt.StandardClaims{ExpiresAt: expiresAt.Unix()},
+		})
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString(signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	stranger := newKey(t, "key", time.Time{})
+	hour := time.Now().Add(time.Hour)
+
+	if id, err := j.UserIdByToken(ctx, sign("", key.PrivateKey, hour)); err != nil || id != "1" {
+		t.Errorf("token without kid: got %s, %v", id, err)
+	}
+	for name, token := range map[string]string{
+		"malformed":        "garbage",
+		"expired":          sign("key", key.PrivateKey, time.Now().Add(-time.Minute)),
+		"unknown kid":      sign("other", key.PrivateKey, hour),
+		"foreign key":      sign("key", stranger.PrivateKey, hour),
+		"foreign, no kid":  sign("", stranger.PrivateKey, hour),
+		"signed with hmac": signHmac(t),
+	} {
+		if _, err := j.UserIdByToken(ctx, token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s token: got %v, want %v", name, err, ErrInvalidToken)
+		}
+	}
+
+	issued, err := j.IssueToken("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list[issued.Id] = true
+	if _, err := j.UserIdByToken(ctx, issued.Token); err != ErrRevokedToken {
+		t.Errorf("revoked token: got %v, want %v", err, ErrRevokedToken)
+	}
+}
+
+// signHmac signs a token with a shared secret, it must not pass for one signed with a private key.
+func signHmac(t *testing.T) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{Id: "1"})
+	token.Header["kid"] = "key"
+	s, err := token.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
@@ -0,0 +1,209 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoKeys          = errors.New("no signing keys")
+	ErrKeyMismatch     = errors.New("public key doesn't match the private key")
+	ErrUnknownKey      = errors.New("token is signed by an unknown or retired key")
+	ErrKeyAlreadyExist = errors.New("key with this id already exists")
+)
+
+const (
+	// KeyIdLayout formats the activation time of generated keys into their ids,
+	// so that ids sort in the order keys take over signing.
+	KeyIdLayout = "20060102T150405Z"
+	keyFileExt  = ".pem"
+	keyBits     = 2048
+)
+
+// Key is an rsa key pair identified by the kid header of tokens signed with it.
+type Key struct {
+	Id         string
+	PrivateKey *rsa.PrivateKey
+	// ActiveFrom is when the key starts signing tokens, until then it's only published.
+	ActiveFrom time.Time
+}
+
+// KeyState tells what a key is used for at some moment.
+type KeyState int
+
+const (
+	// KeyPending is published but doesn't sign tokens yet.
+	KeyPending KeyState = iota
+	// KeyActive signs new tokens.
+	KeyActive
+	// KeyVerifying only verifies tokens signed before a newer key took over.
+	KeyVerifying
+	// KeyRetired can't have signed a token that is still valid, it's neither used nor published.
+	KeyRetired
+)
+
+func (s KeyState) String() string {
+	switch s {
+	case KeyPending:
+		return "pending"
+	case KeyActive:
+		return "active"
+	case KeyVerifying:
+		return "verifying"
+	default:
+		return "retired"
+	}
+}
+
+// KeyStatus is a key with its state and the moment the state ends, zero if it doesn't.
+type KeyStatus struct {
+	Key
+	State KeyState
+	Until time.Time
+}
+
+// KeyStates orders keys by activation and tells their states at now. The newest activated key
+// is active, an older one is retired once its successor signs for longer than tokens live.
+func KeyStates(keys []Key, now time.Time, tokenExpiration time.Duration) []KeyStatus {
+	res := make([]KeyStatus, len(keys))
+	for i, k := range keys {
+		res[i] = KeyStatus{Key: k}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].ActiveFrom.Equal(res[j].ActiveFrom) {
+			return res[i].ActiveFrom.Before(res[j].ActiveFrom)
+		}
+		return res[i].Id < res[j].Id
+	})
+
+	// with no activated key the earliest one signs, rather than nothing at all
+	active := 0
+	for i := range res {
+		if !res[i].ActiveFrom.After(now) {
+			active = i
+		}
+	}
+	for i := range res {
+		switch {
+		case i == active:
+			res[i].State = KeyActive
+			if i+1 < len(res) {
+				res[i].Until = res[i+1].ActiveFrom
+			}
+		case i > active:
+			res[i].State = KeyPending
+			res[i].Until = res[i].ActiveFrom
+		default:
+			res[i].Until = res[i+1].ActiveFrom.Add(tokenExpiration)
+			res[i].State = KeyVerifying
+			if !now.Before(res[i].Until) {
+				res[i].State = KeyRetired
+				res[i].Until = time.Time{}
+			}
+		}
+	}
+	return res
+}
+
+// RotationDue reports whether the active key is older than interval and no newer key is waiting.
+func RotationDue(states []KeyStatus, now time.Time, interval time.Duration) bool {
+	for _, s := range states {
+		if s.State == KeyPending {
+			return false
+		}
+	}
+	for _, s := range states {
+		if s.State == KeyActive {
+			return !now.Before(s.ActiveFrom.Add(interval))
+		}
+	}
+	return true
+}
+
+// LoadKeyPair reads a single key pair kept in separate pem files, it's always active.
+func LoadKeyPair(id string, privateBytes, publicBytes []byte) (Key, error) {
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateBytes)
+	if err != nil {
+		return Key{}, err
+	}
+	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicBytes)
+	if err != nil {
+		return Key{}, err
+	}
+	if !privateKey.PublicKey.Equal(publicKey) {
+		return Key{}, ErrKeyMismatch
+	}
+	return Key{Id: id, PrivateKey: privateKey}, nil
+}
+
+// LoadKeyDir reads the private keys named <kid>.pem in dir. The activation time is parsed
+// from ids of generated keys, keys added by hand are active since they were written.
+func LoadKeyDir(dir string) ([]Key, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []Key
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != keyFileExt {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		id := strings.TrimSuffix(e.Name(), keyFileExt)
+		activeFrom, err := time.Parse(KeyIdLayout, id)
+		if err != nil {
+			activeFrom = e.ModTime()
+		}
+		keys = append(keys, Key{Id: id, PrivateKey: privateKey, ActiveFrom: activeFrom.UTC()})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: %w", dir, ErrNoKeys)
+	}
+	return keys, nil
+}
+
+// GenerateKey writes a new private key to dir, it starts signing at activeFrom.
+func GenerateKey(dir string, activeFrom time.Time) (Key, error) {
+	activeFrom = activeFrom.UTC().Truncate(time.Second)
+	k := Key{Id: activeFrom.Format(KeyIdLayout), ActiveFrom: activeFrom}
+	privateKey, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return Key{}, err
+	}
+	k.PrivateKey = privateKey
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Key{}, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, k.Id+keyFileExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return Key{}, ErrKeyAlreadyExist
+		}
+		return Key{}, err
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}
+	if err := pem.Encode(f, block); err != nil {
+		f.Close()
+		return Key{}, err
+	}
+	return k, f.Close()
+}
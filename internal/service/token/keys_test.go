@@ -0,0 +1,79 @@
+package token
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestKeyStates checks the state of every key around a rotation.
+func TestKeyStates(t *testing.T) {
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	keys := []Key{
+		{Id: "pending", ActiveFrom: now.Add(time.Hour)},
+		{Id: "active", ActiveFrom: now.Add(-time.Minute)},
+		{Id: "verifying", ActiveFrom: now.Add(-48 * time.Hour)},
+		{Id: "retired", ActiveFrom: now.Add(-72 * time.Hour)},
+	}
+	states := KeyStates(keys, now, testExpiration)
+	want := []struct {
+		id    string
+		state KeyState
+		until time.Time
+	}{
+		{"retired", KeyRetired, time.Time{}},
+		{"verifying", KeyVerifying, now.Add(-time.Minute + testExpiration)},
+		{"active", KeyActive, now.Add(time.Hour)},
+		{"pending", KeyPending, now.Add(time.Hour)},
+	}
+	for i, w := range want {
+		s := states[i]
+		if s.Id != w.id || s.State != w.state || !s.Until.Equal(w.until) {
+			t.Errorf("key %d: got %s %v until %v, want %s %v until %v", i, s.Id, s.State, s.Until, w.id, w.state, w.until)
+		}
+	}
+
+	if RotationDue(states, now, 24*time.Hour) {
+		t.Error("rotation is due while the next key is waiting")
+	}
+	if !RotationDue(states[:3], now.Add(25*time.Hour), 24*time.Hour) {
+		t.Error("rotation isn't due for a key older than the interval")
+	}
+	if RotationDue(states[:3], now, 24*time.Hour) {
+		t.Error("rotation is due for a fresh key")
+	}
+
+	// a key added by hand with a later activation time than now still signs if it's the only one
+	only := KeyStates([]Key{{Id: "only", ActiveFrom: now.Add(time.Hour)}}, now, testExpiration)
+	if only[0].State != KeyActive {
+		t.Errorf("the only key is %v, want active", only[0].State)
+	}
+}
+
+// TestGenerateKey checks that generated keys are read back with their activation time.
+func TestGenerateKey(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+	if _, err := LoadKeyDir(t.TempDir()); err == nil {
+		t.Error("keys are loaded from an empty directory")
+	}
+
+	activeFrom := time.Now().Add(time.Hour)
+	k, err := GenerateKey(dir, activeFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GenerateKey(dir, activeFrom); err != ErrKeyAlreadyExist {
+		t.Errorf("second key with the same id: got %v, want %v", err, ErrKeyAlreadyExist)
+	}
+
+	keys, err := LoadKeyDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Id != k.Id || !keys[0].ActiveFrom.Equal(activeFrom.UTC().Truncate(time.Second)) {
+		t.Fatalf("got keys %v, want %s active from %v", keys, k.Id, k.ActiveFrom)
+	}
+	if !keys[0].PrivateKey.Equal(k.PrivateKey) {
+		t.Error("loaded key differs from the generated one")
+	}
+}
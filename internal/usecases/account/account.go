@@ -1,9 +1,19 @@
 package account
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/account"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/apikey"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/loginattempt"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/refreshtoken"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/service/token"
+	"sync"
 	"time"
 
 	"errors"
@@ -18,8 +28,43 @@ var (
 	ErrTooLongString         = errors.New("too long string")
 	ErrNoCapitalLetters      = errors.New("password string does not contain capital letters")
 	ErrNoDigits              = errors.New("password string does not contain digits")
+	ErrInvalidCredentials    = errors.New("invalid login or password")
+	ErrInvalidRefreshToken   = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused    = errors.New("refresh token is already used, the session is revoked")
+	ErrInvalidScope          = errors.New("unknown scope")
+	ErrExpirationInPast      = errors.New("expiration time is in the past")
+	ErrInvalidApiKey         = errors.New("invalid or expired api key")
+	ErrWrongPassword         = errors.New("wrong password")
+	ErrTooManyAttempts       = errors.New("too many failed sign in attempts")
 )
 
+// FieldError tells which input field failed validation.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ThrottledError tells how long to wait before signing in again after too many failures.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return ErrTooManyAttempts.Error()
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
 const (
 	minLoginLength    = 4
 	maxLoginLength    = 50
@@ -27,44 +72,155 @@ const (
 	maxPasswordLength = 50
 )
 
+// refreshTokenBytes is the amount of randomness in a refresh token.
+const refreshTokenBytes = 32
+
+// refreshTokensSweepInterval is how often sessions with expired refresh tokens are deleted.
+const refreshTokensSweepInterval = time.Hour
+
+const (
+	maxApiKeyNameLength = 100
+	// apiKeyBytes is the amount of randomness in an api key.
+	apiKeyBytes = 32
+	// apiKeyPrefix marks api keys, so that a leaked one is easy to recognize.
+	apiKeyPrefix = "lk_"
+	// apiKeyShownLength is the part of the key kept to tell keys apart.
+	apiKeyShownLength = len(apiKeyPrefix) + 6
+	// apiKeyTouchInterval limits how often the last use of a key is written,
+	// a script calling in a loop would otherwise write on every request.
+	apiKeyTouchInterval = time.Minute
+)
+
+// Scopes an api key can be granted, a signed in user has all of them.
+const (
+	ScopeLinksRead  = "links:read"
+	ScopeLinksWrite = "links:write"
+)
+
+var scopes = []string{ScopeLinksRead, ScopeLinksWrite}
+
+// LinksPolicy tells what happens to links of a deleted account.
+type LinksPolicy string
+
+const (
+	// DeleteLinks deletes the links together with the account.
+	DeleteLinks LinksPolicy = "cascade"
+	// AnonymizeLinks keeps the links redirecting as anonymous ones.
+	AnonymizeLinks LinksPolicy = "anonymize"
+)
+
 type Account struct {
 	Id string
 }
 
+// Tokens are issued on sign in and on every refresh.
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	// ExpiresIn is the lifetime of the access token.
+	ExpiresIn time.Duration
+}
+
+type ApiKey struct {
+	Id         string
+	Name       string
+	Prefix     string
+	Scopes     []string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// NewApiKey is returned once on creation, the key itself can't be looked up later.
+type NewApiKey struct {
+	ApiKey
+	Key string
+}
+
+// Principal is the account an api key acts for and what it's allowed to do.
+type Principal struct {
+	AccountId string
+	Scopes    []string
+}
+
 type AccountUseCasesInterface interface {
-	CreateAccount(login, password string) (Account, error)
-	GetAccountById(id string) (Account, error)
-	LoginToAccount(login, password string) (string, error)
-	Authenticate(token string) (string, error)
+	CreateAccount(ctx context.Context, login, password string) (Account, error)
+	GetAccountById(ctx context.Context, id string) (Account, error)
+	// LoginToAccount limits failed attempts per login and per ip address of the client.
+	LoginToAccount(ctx context.Context, login, password, ip string) (Tokens, error)
+	// RefreshToken exchanges a refresh token for new tokens, the old one can't be used again.
+	RefreshToken(ctx context.Context, refreshToken string) (Tokens, error)
+	// SignOut revokes the refresh token with all tokens of its session.
+	SignOut(ctx context.Context, refreshToken string) error
+	Authenticate(ctx context.Context, token string) (string, error)
+	// PublicKeys returns the keys other services can verify our tokens with.
+	PublicKeys(ctx context.Context) ([]token.PublicKey, error)
+	// CreateApiKey creates a key with the scopes, all of them if none are given.
+	CreateApiKey(ctx context.Context, accountId, name string, scopes []string, expiresAt *time.Time) (NewApiKey, error)
+	GetApiKeys(ctx context.Context, accountId string) ([]ApiKey, error)
+	RevokeApiKey(ctx context.Context, accountId, keyId string) error
+	AuthenticateApiKey(ctx context.Context, key string) (Principal, error)
+	// UpdatePassword replaces the password and ends every session of the account.
+	UpdatePassword(ctx context.Context, accountId, currentPassword, newPassword string) error
+	// DeleteAccount deletes the account and ends its sessions, its links are handled per LinksOnDelete.
+	DeleteAccount(ctx context.Context, accountId, password string) error
 
 	//Logging
 	LoggerCreateAccount(
-		createAccount func(login, password string) (Account, error)) func(login, password string) (Account, error)
+		createAccount func(ctx context.Context, login, password string) (Account, error)) func(ctx context.Context, login, password string) (Account, error)
 	LoggerGetAccountById(
-		getAccountById func(id string) (Account, error)) func(id string) (Account, error)
+		getAccountById func(ctx context.Context, id string) (Account, error)) func(ctx context.Context, id string) (Account, error)
 	LoggerLoginToAccount(
-		loginToAccount func(login, password string) (string, error)) func(login, password string) (string, error)
+		loginToAccount func(ctx context.Context, login, password, ip string) (Tokens, error)) func(ctx context.Context, login, password, ip string) (Tokens, error)
+	LoggerRefreshToken(
+		refreshToken func(ctx context.Context, refreshToken string) (Tokens, error)) func(ctx context.Context, refreshToken string) (Tokens, error)
+	LoggerSignOut(
+		signOut func(ctx context.Context, refreshToken string) error) func(ctx context.Context, refreshToken string) error
 	LoggerAuthenticate(
-		authenticate func(token string) (string, error)) func(token string) (string, error)
+		authenticate func(ctx context.Context, token string) (string, error)) func(ctx context.Context, token string) (string, error)
+	LoggerPublicKeys(
+		publicKeys func(ctx context.Context) ([]token.PublicKey, error)) func(ctx context.Context) ([]token.PublicKey, error)
+	LoggerCreateApiKey(
+		createApiKey func(ctx context.Context, accountId, name string, scopes []string, expiresAt *time.Time) (NewApiKey, error)) func(ctx context.Context, accountId, name string, scopes []string, expiresAt *time.Time) (NewApiKey, error)
+	LoggerGetApiKeys(
+		getApiKeys func(ctx context.Context, accountId string) ([]ApiKey, error)) func(ctx context.Context, accountId string) ([]ApiKey, error)
+	LoggerRevokeApiKey(
+		revokeApiKey func(ctx context.Context, accountId, keyId string) error) func(ctx context.Context, accountId, keyId string) error
+	LoggerAuthenticateApiKey(
+		authenticateApiKey func(ctx context.Context, key string) (Principal, error)) func(ctx context.Context, key string) (Principal, error)
+	LoggerUpdatePassword(
+		updatePassword func(ctx context.Context, accountId, currentPassword, newPassword string) error) func(ctx context.Context, accountId, currentPassword, newPassword string) error
+	LoggerDeleteAccount(
+		deleteAccount func(ctx context.Context, accountId, password string) error) func(ctx context.Context, accountId, password string) error
 }
 
 type AccountUseCases struct {
-	AccountStorage account.Interface
-	Auth           token.Interface
+	AccountStorage         account.Interface
+	RefreshTokenStorage    refreshtoken.Interface
+	ApiKeyStorage          apikey.Interface
+	LinkStorage            link.Interface
+	LoginAttemptStorage    loginattempt.Interface
+	Auth                   token.Interface
+	RefreshTokenExpiration time.Duration
+	LinksOnDelete          LinksPolicy
+
+	sweepMu        sync.Mutex
+	lastSweep      time.Time
+	lastTokenSweep time.Time
 }
 
-func (a *AccountUseCases) CreateAccount(login, password string) (Account, error) {
+func (a *AccountUseCases) CreateAccount(ctx context.Context, login, password string) (Account, error) {
 	if err := validateLogin(login); err != nil {
-		return Account{}, err
+		return Account{}, &FieldError{Field: "login", Err: err}
 	}
 	if err := validatePassword(password); err != nil {
-		return Account{}, err
+		return Account{}, &FieldError{Field: "password", Err: err}
 	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return Account{}, err
 	}
-	acc, err := a.AccountStorage.CreateAccount(account.Credentials{
+	acc, err := a.AccountStorage.CreateAccount(ctx, account.Credentials{
 		Login:    login,
 		Password: string(hashedPassword),
 	})
@@ -74,37 +230,357 @@ func (a *AccountUseCases) CreateAccount(login, password string) (Account, error)
 	return Account{Id: acc.Id}, nil
 }
 
-func (a *AccountUseCases) GetAccountById(id string) (Account, error) {
-	acc, err := a.AccountStorage.GetAccountById(id)
+func (a *AccountUseCases) GetAccountById(ctx context.Context, id string) (Account, error) {
+	acc, err := a.AccountStorage.GetAccountById(ctx, id)
 	if err != nil {
 		return Account{}, err
 	}
 	return Account{Id: acc.Id}, err
 }
 
-func (a *AccountUseCases) LoginToAccount(login, password string) (string, error) {
+func (a *AccountUseCases) LoginToAccount(ctx context.Context, login, password, ip string) (Tokens, error) {
 	if err := validateLogin(login); err != nil {
-		return "", err
+		return Tokens{}, &FieldError{Field: "login", Err: err}
 	}
 	if err := validatePassword(password); err != nil {
-		return "", err
+		return Tokens{}, &FieldError{Field: "password", Err: err}
+	}
+	now := time.Now()
+	keys := signinKeys(login, ip)
+	// the attempt is counted as failed up front and given back if it isn't one
+	if err := a.takeAttempt(ctx, keys, now); err != nil {
+		return Tokens{}, err
 	}
-	acc, err := a.AccountStorage.GetAccountByLogin(login)
+	acc, err := a.AccountStorage.GetAccountByLogin(ctx, login)
 	if err != nil {
-		return "", err
+		if err != account.ErrNotFound {
+			a.releaseAttempt(ctx, keys, now)
+			return Tokens{}, err
+		}
+		// unknown logins are compared and counted like the known ones, or they could be told apart
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return Tokens{}, ErrInvalidCredentials
 	}
 	if err := bcrypt.CompareHashAndPassword([]byte(acc.Credentials.Password), []byte(password)); err != nil {
+		if err != bcrypt.ErrMismatchedHashAndPassword {
+			a.releaseAttempt(ctx, keys, now)
+			return Tokens{}, err
+		}
+		return Tokens{}, ErrInvalidCredentials
+	}
+	// the login is cleared, the address only gets the attempt back, or signing in
+	// to an own account would reset the count of the address
+	if err := a.LoginAttemptStorage.ResetAttempts(ctx, keys[0].key); err != nil {
+		return Tokens{}, err
+	}
+	a.releaseAttempt(ctx, keys[1:], now)
+	familyId, err := randomHex(16)
+	if err != nil {
+		return Tokens{}, err
+	}
+	return a.issueTokens(ctx, acc.Id, familyId)
+}
+
+func (a *AccountUseCases) RefreshToken(ctx context.Context, refreshToken string) (Tokens, error) {
+	t, err := a.RefreshTokenStorage.GetRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		if err == refreshtoken.ErrNotFound {
+			return Tokens{}, ErrInvalidRefreshToken
+		}
+		return Tokens{}, err
+	}
+	now := time.Now()
+	if t.RevokedAt != nil || !now.Before(t.ExpiresAt) {
+		return Tokens{}, ErrInvalidRefreshToken
+	}
+	if err := a.RefreshTokenStorage.UseRefreshToken(ctx, t.Hash, now); err != nil {
+		if err != refreshtoken.ErrAlreadyUsed {
+			return Tokens{}, err
+		}
+		// a used token is presented again, either the client or whoever stole the token
+		// holds a newer one, so neither is trusted anymore
+		if err := a.RefreshTokenStorage.RevokeFamily(ctx, t.FamilyId, now); err != nil {
+			return Tokens{}, err
+		}
+		return Tokens{}, ErrRefreshTokenReused
+	}
+	return a.issueTokens(ctx, t.AccountId, t.FamilyId)
+}
+
+func (a *AccountUseCases) SignOut(ctx context.Context, refreshToken string) error {
+	t, err := a.RefreshTokenStorage.GetRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		if err == refreshtoken.ErrNotFound {
+			return ErrInvalidRefreshToken
+		}
+		return err
+	}
+	return a.RefreshTokenStorage.RevokeFamily(ctx, t.FamilyId, time.Now())
+}
+
+// issueTokens issues an access token and a refresh token of the family, which refers
+// to the access token so that revoking the family revokes it as well.
+func (a *AccountUseCases) issueTokens(ctx context.Context, accountId, familyId string) (Tokens, error) {
+	a.sweepRefreshTokens(ctx, time.Now())
+	access, err := a.Auth.IssueToken(accountId)
+	if err != nil {
+		return Tokens{}, err
+	}
+	b := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return Tokens{}, err
+	}
+	refresh := base64.RawURLEncoding.EncodeToString(b)
+	err = a.RefreshTokenStorage.StoreRefreshToken(ctx, refreshtoken.RefreshToken{
+		Hash:          hashToken(refresh),
+		FamilyId:      familyId,
+		AccountId:     accountId,
+		AccessTokenId: access.Id,
+		ExpiresAt:     time.Now().Add(a.RefreshTokenExpiration),
+	})
+	if err != nil {
+		return Tokens{}, err
+	}
+	return Tokens{
+		AccessToken:  access.Token,
+		RefreshToken: refresh,
+		ExpiresIn:    time.Until(access.ExpiresAt).Round(time.Second),
+	}, nil
+}
+
+// sweepRefreshTokens deletes sessions whose refresh tokens all expired at most once per
+// interval, otherwise every rotation would leave a row behind for good. Access tokens
+// don't outlive the refresh token issued along, so their revocations aren't needed either.
+func (a *AccountUseCases) sweepRefreshTokens(ctx context.Context, now time.Time) {
+	a.sweepMu.Lock()
+	if now.Sub(a.lastTokenSweep) < refreshTokensSweepInterval {
+		a.sweepMu.Unlock()
+		return
+	}
+	a.lastTokenSweep = now
+	a.sweepMu.Unlock()
+
+	if err := a.RefreshTokenStorage.DeleteExpiredFamilies(ctx, now); err != nil {
+		a.logger("DeleteExpiredFamilies", err, now)
+	}
+}
+
+// hashToken is enough to protect refresh tokens and api keys at rest, unlike passwords they are long and random.
+func hashToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
-	token, err := a.Auth.IssueToken(acc.Id)
+	return hex.EncodeToString(b), nil
+}
+
+func (a *AccountUseCases) Authenticate(ctx context.Context, accessToken string) (string, error) {
+	id, err := a.Auth.UserIdByToken(ctx, accessToken)
 	if err != nil {
 		return "", err
 	}
-	return token, err
+	// the account may be deleted while its tokens haven't expired yet
+	if _, err := a.AccountStorage.GetAccountById(ctx, id); err != nil {
+		if err == account.ErrNotFound {
+			return "", token.ErrRevokedToken
+		}
+		return "", err
+	}
+	return id, nil
+}
+
+func (a *AccountUseCases) PublicKeys(ctx context.Context) ([]token.PublicKey, error) {
+	return a.Auth.PublicKeys(), nil
+}
+
+func (a *AccountUseCases) CreateApiKey(ctx context.Context, accountId, name string, scopes []string, expiresAt *time.Time) (NewApiKey, error) {
+	if name == "" {
+		return NewApiKey{}, &FieldError{Field: "name", Err: ErrTooShortString}
+	}
+	if len([]rune(name)) > maxApiKeyNameLength {
+		return NewApiKey{}, &FieldError{Field: "name", Err: ErrTooLongString}
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return NewApiKey{}, &FieldError{Field: "scopes", Err: err}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return NewApiKey{}, &FieldError{Field: "expires_at", Err: ErrExpirationInPast}
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return NewApiKey{}, err
+	}
+	b := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		return NewApiKey{}, err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	k, err := a.ApiKeyStorage.StoreApiKey(ctx, apikey.ApiKey{
+		Id:        id,
+		AccountId: accountId,
+		Name:      name,
+		Hash:      hashToken(key),
+		Prefix:    key[:apiKeyShownLength],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return NewApiKey{}, err
+	}
+	return NewApiKey{ApiKey: toApiKey(k), Key: key}, nil
 }
 
-func (a *AccountUseCases) Authenticate(token string) (string, error) {
-	return a.Auth.UserIdByToken(token)
+// normalizeScopes checks the scopes and drops duplicates, no scopes means all of them.
+func normalizeScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return scopes, nil
+	}
+	res := make([]string, 0, len(requested))
+	for _, s := range scopes {
+		for _, r := range requested {
+			if r == s {
+				res = append(res, s)
+				break
+			}
+		}
+	}
+	for _, r := range requested {
+		if !hasScope(res, r) {
+			return nil, ErrInvalidScope
+		}
+	}
+	return res, nil
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AccountUseCases) GetApiKeys(ctx context.Context, accountId string) ([]ApiKey, error) {
+	keys, err := a.ApiKeyStorage.GetApiKeysByAccountId(ctx, accountId)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]ApiKey, 0, len(keys))
+	for _, k := range keys {
+		res = append(res, toApiKey(k))
+	}
+	return res, nil
+}
+
+func (a *AccountUseCases) RevokeApiKey(ctx context.Context, accountId, keyId string) error {
+	return a.ApiKeyStorage.DeleteApiKey(ctx, keyId, accountId)
+}
+
+func (a *AccountUseCases) AuthenticateApiKey(ctx context.Context, key string) (Principal, error) {
+	k, err := a.ApiKeyStorage.GetApiKeyByHash(ctx, hashToken(key))
+	if err != nil {
+		if err == apikey.ErrNotFound {
+			return Principal{}, ErrInvalidApiKey
+		}
+		return Principal{}, err
+	}
+	now := time.Now()
+	if k.ExpiresAt != nil && !now.Before(*k.ExpiresAt) {
+		return Principal{}, ErrInvalidApiKey
+	}
+	if _, err := a.AccountStorage.GetAccountById(ctx, k.AccountId); err != nil {
+		if err == account.ErrNotFound {
+			return Principal{}, ErrInvalidApiKey
+		}
+		return Principal{}, err
+	}
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= apiKeyTouchInterval {
+		// the request may go on even if the last use isn't recorded, the failure is only logged
+		if err := a.ApiKeyStorage.TouchApiKey(ctx, k.Id, now); err != nil {
+			a.logger("TouchApiKey", err, now)
+		}
+	}
+	return Principal{AccountId: k.AccountId, Scopes: k.Scopes}, nil
+}
+
+func (a *AccountUseCases) UpdatePassword(ctx context.Context, accountId, currentPassword, newPassword string) error {
+	if err := validatePassword(newPassword); err != nil {
+		return &FieldError{Field: "new_password", Err: err}
+	}
+	if err := a.checkPassword(ctx, accountId, currentPassword); err != nil {
+		if err == ErrWrongPassword {
+			return &FieldError{Field: "current_password", Err: err}
+		}
+		return err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := a.AccountStorage.UpdatePassword(ctx, accountId, string(hashedPassword)); err != nil {
+		return err
+	}
+	// whoever learned the old password may hold a session or have created api keys
+	if err := a.RefreshTokenStorage.RevokeAccount(ctx, accountId, time.Now()); err != nil {
+		return err
+	}
+	return a.ApiKeyStorage.DeleteApiKeysByAccountId(ctx, accountId)
+}
+
+func (a *AccountUseCases) DeleteAccount(ctx context.Context, accountId, password string) error {
+	if err := a.checkPassword(ctx, accountId, password); err != nil {
+		if err == ErrWrongPassword {
+			return &FieldError{Field: "password", Err: err}
+		}
+		return err
+	}
+	if err := a.RefreshTokenStorage.RevokeAccount(ctx, accountId, time.Now()); err != nil {
+		return err
+	}
+	// the account storage deletes or disowns links together with the account, so that
+	// a failure leaves neither an account without its links nor links of a deleted account
+	if err := a.AccountStorage.DeleteAccount(ctx, accountId, a.LinksOnDelete == AnonymizeLinks); err != nil {
+		return err
+	}
+	// the link storage catches up for storages without foreign keys and drops cached links,
+	// in sql storages the links are already gone or anonymous by now
+	if a.LinksOnDelete == AnonymizeLinks {
+		return a.LinkStorage.DisownLinksByAccountId(ctx, accountId)
+	}
+	return a.LinkStorage.DeleteLinksByAccountId(ctx, accountId)
+}
+
+// checkPassword returns ErrWrongPassword unless password is the one of the account.
+func (a *AccountUseCases) checkPassword(ctx context.Context, accountId, password string) error {
+	acc, err := a.AccountStorage.GetAccountById(ctx, accountId)
+	if err != nil {
+		return err
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(acc.Credentials.Password), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrWrongPassword
+	}
+	return err
+}
+
+func toApiKey(k apikey.ApiKey) ApiKey {
+	return ApiKey{
+		Id:         k.Id,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		CreatedAt:  k.CreatedAt,
+	}
 }
 
 func validateLogin(login string) error {
@@ -167,45 +643,144 @@ func (a *AccountUseCases) logger(method string, err error, start time.Time) {
 }
 
 func (a *AccountUseCases) LoggerCreateAccount(
-	createAccount func(login, password string) (Account, error)) func(login, password string) (Account, error) {
+	createAccount func(ctx context.Context, login, password string) (Account, error)) func(ctx context.Context, login, password string) (Account, error) {
 
-	return func(login, password string) (Account, error) {
+	return func(ctx context.Context, login, password string) (Account, error) {
 		start := time.Now()
-		acc, err := createAccount(login, password)
+		acc, err := createAccount(ctx, login, password)
 		a.logger("CreateAccount", err, start)
 		return acc, err
 	}
 }
 
 func (a *AccountUseCases) LoggerGetAccountById(
-	getAccountById func(id string) (Account, error)) func(id string) (Account, error) {
+	getAccountById func(ctx context.Context, id string) (Account, error)) func(ctx context.Context, id string) (Account, error) {
 
-	return func(id string) (Account, error) {
+	return func(ctx context.Context, id string) (Account, error) {
 		start := time.Now()
-		acc, err := getAccountById(id)
+		acc, err := getAccountById(ctx, id)
 		a.logger("GetAccountById", err, start)
 		return acc, err
 	}
 }
 
 func (a *AccountUseCases) LoggerLoginToAccount(
-	loginToAccount func(login, password string) (string, error)) func(login, password string) (string, error) {
+	loginToAccount func(ctx context.Context, login, password, ip string) (Tokens, error)) func(ctx context.Context, login, password, ip string) (Tokens, error) {
 
-	return func(login, password string) (string, error) {
+	return func(ctx context.Context, login, password, ip string) (Tokens, error) {
 		start := time.Now()
-		token, err := loginToAccount(login, password)
+		tokens, err := loginToAccount(ctx, login, password, ip)
 		a.logger("LoginToAccount", err, start)
-		return token, err
+		return tokens, err
+	}
+}
+
+func (a *AccountUseCases) LoggerRefreshToken(
+	refreshToken func(ctx context.Context, refreshToken string) (Tokens, error)) func(ctx context.Context, refreshToken string) (Tokens, error) {
+
+	return func(ctx context.Context, token string) (Tokens, error) {
+		start := time.Now()
+		tokens, err := refreshToken(ctx, token)
+		a.logger("RefreshToken", err, start)
+		return tokens, err
+	}
+}
+
+func (a *AccountUseCases) LoggerSignOut(
+	signOut func(ctx context.Context, refreshToken string) error) func(ctx context.Context, refreshToken string) error {
+
+	return func(ctx context.Context, refreshToken string) error {
+		start := time.Now()
+		err := signOut(ctx, refreshToken)
+		a.logger("SignOut", err, start)
+		return err
 	}
 }
 
 func (a *AccountUseCases) LoggerAuthenticate(
-	authenticate func(token string) (string, error)) func(token string) (string, error) {
+	authenticate func(ctx context.Context, token string) (string, error)) func(ctx context.Context, token string) (string, error) {
 
-	return func(token string) (string, error) {
+	return func(ctx context.Context, token string) (string, error) {
 		start := time.Now()
-		token, err := authenticate(token)
+		token, err := authenticate(ctx, token)
 		a.logger("Authenticate", err, start)
 		return token, err
 	}
 }
+
+func (a *AccountUseCases) LoggerPublicKeys(
+	publicKeys func(ctx context.Context) ([]token.PublicKey, error)) func(ctx context.Context) ([]token.PublicKey, error) {
+
+	return func(ctx context.Context) ([]token.PublicKey, error) {
+		start := time.Now()
+		keys, err := publicKeys(ctx)
+		a.logger("PublicKeys", err, start)
+		return keys, err
+	}
+}
+
+func (a *AccountUseCases) LoggerCreateApiKey(
+	createApiKey func(ctx context.Context, accountId, name string, scopes []string, expiresAt *time.Time) (NewApiKey, error)) func(ctx context.Context, accountId, name string, scopes []string, expiresAt *time.Time) (NewApiKey, error) {
+
+	return func(ctx context.Context, accountId, name string, scopes []string, expiresAt *time.Time) (NewApiKey, error) {
+		start := time.Now()
+		key, err := createApiKey(ctx, accountId, name, scopes, expiresAt)
+		a.logger("CreateApiKey", err, start)
+		return key, err
+	}
+}
+
+func (a *AccountUseCases) LoggerGetApiKeys(
+	getApiKeys func(ctx context.Context, accountId string) ([]ApiKey, error)) func(ctx context.Context, accountId string) ([]ApiKey, error) {
+
+	return func(ctx context.Context, accountId string) ([]ApiKey, error) {
+		start := time.Now()
+		keys, err := getApiKeys(ctx, accountId)
+		a.logger("GetApiKeys", err, start)
+		return keys, err
+	}
+}
+
+func (a *AccountUseCases) LoggerRevokeApiKey(
+	revokeApiKey func(ctx context.Context, accountId, keyId string) error) func(ctx context.Context, accountId, keyId string) error {
+
+	return func(ctx context.Context, accountId, keyId string) error {
+		start := time.Now()
+		err := revokeApiKey(ctx, accountId, keyId)
+		a.logger("RevokeApiKey", err, start)
+		return err
+	}
+}
+
+func (a *AccountUseCases) LoggerAuthenticateApiKey(
+	authenticateApiKey func(ctx context.Context, key string) (Principal, error)) func(ctx context.Context, key string) (Principal, error) {
+
+	return func(ctx context.Context, key string) (Principal, error) {
+		start := time.Now()
+		p, err := authenticateApiKey(ctx, key)
+		a.logger("AuthenticateApiKey", err, start)
+		return p, err
+	}
+}
+
+func (a *AccountUseCases) LoggerUpdatePassword(
+	updatePassword func(ctx context.Context, accountId, currentPassword, newPassword string) error) func(ctx context.Context, accountId, currentPassword, newPassword string) error {
+
+	return func(ctx context.Context, accountId, currentPassword, newPassword string) error {
+		start := time.Now()
+		err := updatePassword(ctx, accountId, currentPassword, newPassword)
+		a.logger("UpdatePassword", err, start)
+		return err
+	}
+}
+
+func (a *AccountUseCases) LoggerDeleteAccount(
+	deleteAccount func(ctx context.Context, accountId, password string) error) func(ctx context.Context, accountId, password string) error {
+
+	return func(ctx context.Context, accountId, password string) error {
+		start := time.Now()
+		err := deleteAccount(ctx, accountId, password)
+		a.logger("DeleteAccount", err, start)
+		return err
+	}
+}
@@ -0,0 +1,489 @@
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/account"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/refreshtoken"
+	memoryaccountrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/accountrepo"
+	memoryapikeyrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/apikeyrepo"
+	memorylinkrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/linkrepo"
+	memoryloginattemptrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/loginattemptrepo"
+	memoryrefreshtokenrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/refreshtokenrepo"
+	sqliteaccountrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/accountrepo"
+	sqliteapikeyrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/apikeyrepo"
+	sqlitelinkrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/linkrepo"
+	sqliteloginattemptrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/loginattemptrepo"
+	sqliterefreshtokenrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/refreshtokenrepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/migrate"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/service/token"
+	_ "modernc.org/sqlite"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testLogin    = "alice"
+	testPassword = "Secret123"
+	testIp       = "192.0.2.1"
+)
+
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+)
+
+// newAccountUseCases returns use cases backed by memory storages with an account of testLogin.
+func newAccountUseCases(t *testing.T) (*AccountUseCases, string) {
+	return withStorages(t, &AccountUseCases{
+		AccountStorage:      memoryaccountrepo.NewMemory(),
+		RefreshTokenStorage: memoryrefreshtokenrepo.NewMemory(),
+		ApiKeyStorage:       memoryapikeyrepo.NewMemory(),
+		LinkStorage:         memorylinkrepo.NewMemory(),
+		LoginAttemptStorage: memoryloginattemptrepo.NewMemory(),
+	})
+}
+
+// sqliteAccountUseCases is newAccountUseCases backed by a temporary sqlite file.
+func sqliteAccountUseCases(t *testing.T) (*AccountUseCases, string) {
+	conn, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "lenke.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetMaxOpenConns(1)
+	m, err := migrate.New(conn, migrate.Sqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return withStorages(t, &AccountUseCases{
+		AccountStorage:      sqliteaccountrepo.New(conn),
+		RefreshTokenStorage: sqliterefreshtokenrepo.New(conn),
+		ApiKeyStorage:       sqliteapikeyrepo.New(conn),
+		LinkStorage:         sqlitelinkrepo.New(conn),
+		LoginAttemptStorage: sqliteloginattemptrepo.New(conn),
+	})
+}
+
+// withStorages completes the use cases with the storages set and creates the account of testLogin.
+func withStorages(t *testing.T, a *AccountUseCases) (*AccountUseCases, string) {
+	testKeyOnce.Do(func() {
+		var err error
+		if testKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			panic(err)
+		}
+	})
+	auth, err := token.NewJwtHandler([]token.Key{{Id: "test", PrivateKey: testKey}}, time.Hour, a.RefreshTokenStorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Auth = auth
+	a.RefreshTokenExpiration = time.Hour
+	a.LinksOnDelete = DeleteLinks
+	acc, err := a.CreateAccount(context.Background(), testLogin, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, acc.Id
+}
+
+// TestRefreshToken checks that every refresh rotates the refresh token, and that a used
+// one presented again revokes the whole session including its access tokens.
+func TestRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	a, id := newAccountUseCases(t)
+	first, err := a.LoginToAccount(ctx, testLogin, testPassword, testIp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := a.RefreshToken(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Fatal("refresh returns the same tokens")
+	}
+	if got, err := a.Authenticate(ctx, second.AccessToken); err != nil || got != id {
+		t.Fatalf("refreshed access token: got %s, %v, want %s", got, err, id)
+	}
+
+	// a sign in on another device is a session of its own
+	other, err := a.LoginToAccount(ctx, testLogin, testPassword, testIp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.RefreshToken(ctx, first.RefreshToken); err != ErrRefreshTokenReused {
+		t.Fatalf("reused refresh token: got %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if _, err := a.RefreshToken(ctx, second.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("refresh token of a revoked session: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	for name, accessToken := range map[string]string{"first": first.AccessToken, "second": second.AccessToken} {
+		if _, err := a.Authenticate(ctx, accessToken); err != token.ErrRevokedToken {
+			t.Errorf("%s access token of a revoked session: got %v, want %v", name, err, token.ErrRevokedToken)
+		}
+	}
+	if _, err := a.Authenticate(ctx, other.AccessToken); err != nil {
+		t.Errorf("another session is revoked: %v", err)
+	}
+	if _, err := a.RefreshToken(ctx, "unknown"); err != ErrInvalidRefreshToken {
+		t.Errorf("unknown refresh token: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
+
+// TestSignOut checks that signing out revokes the refresh token with the access token issued along.
+func TestSignOut(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newAccountUseCases(t)
+	tokens, err := a.LoginToAccount(ctx, testLogin, testPassword, testIp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SignOut(ctx, tokens.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(ctx, tokens.AccessToken); !errors.Is(err, token.ErrRevokedToken) {
+		t.Errorf("access token after sign out: got %v, want %v", err, token.ErrRevokedToken)
+	}
+	if _, err := a.RefreshToken(ctx, tokens.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("refresh token after sign out: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
+
+// TestSweepRefreshTokens checks that sessions are deleted once all their refresh tokens
+// expired, while used tokens of a live session are kept to detect their reuse.
+func TestSweepRefreshTokens(t *testing.T) {
+	backends := map[string]func(t *testing.T) (*AccountUseCases, string){
+		"memory": newAccountUseCases,
+		"sqlite": sqliteAccountUseCases,
+	}
+	for name, newUseCases := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			a, id := newUseCases(t)
+			now := time.Now()
+			store := func(hash, familyId string, expiresAt time.Time, used bool) {
+				err := a.RefreshTokenStorage.StoreRefreshToken(ctx, refreshtoken.RefreshToken{
+					Hash:          hash,
+					FamilyId:      familyId,
+					AccountId:     id,
+					AccessTokenId: "access-" + hash,
+					ExpiresAt:     expiresAt,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if used {
+					if err := a.RefreshTokenStorage.UseRefreshToken(ctx, hash, expiresAt.Add(-time.Hour)); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			store("live-used", "live", now.Add(-2*time.Hour), true)
+			store("live", "live", now.Add(time.Hour), false)
+			store("expired-used", "expired", now.Add(-3*time.Hour), true)
+			store("expired", "expired", now.Add(-time.Hour), false)
+
+			a.sweepRefreshTokens(ctx, now)
+			for hash, want := range map[string]error{
+				"live-used":    nil,
+				"live":         nil,
+				"expired-used": refreshtoken.ErrNotFound,
+				"expired":      refreshtoken.ErrNotFound,
+			} {
+				if _, err := a.RefreshTokenStorage.GetRefreshToken(ctx, hash); err != want {
+					t.Errorf("token %s: got %v, want %v", hash, err, want)
+				}
+			}
+
+			// the storage is swept at most once per interval
+			store("later", "later", now.Add(-time.Minute), false)
+			a.sweepRefreshTokens(ctx, now.Add(time.Minute))
+			if _, err := a.RefreshTokenStorage.GetRefreshToken(ctx, "later"); err != nil {
+				t.Errorf("token expired after the sweep: got %v, want it kept until the next one", err)
+			}
+			a.sweepRefreshTokens(ctx, now.Add(refreshTokensSweepInterval))
+			if _, err := a.RefreshTokenStorage.GetRefreshToken(ctx, "later"); err != refreshtoken.ErrNotFound {
+				t.Errorf("token expired before the next sweep: got %v, want %v", err, refreshtoken.ErrNotFound)
+			}
+		})
+	}
+}
+
+// TestAuthenticateApiKey checks the scopes of api keys and that expired keys are refused.
+func TestAuthenticateApiKey(t *testing.T) {
+	ctx := context.Background()
+	a, id := newAccountUseCases(t)
+
+	all, err := a.CreateApiKey(ctx, id, "all", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := a.AuthenticateApiKey(ctx, all.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.AccountId != id || len(p.Scopes) != 2 {
+		t.Errorf("got %+v, want every scope of account %s", p, id)
+	}
+
+	k, err := a.CreateApiKey(ctx, id, "write", []string{ScopeLinksWrite, ScopeLinksWrite}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k.Scopes) != 1 || k.Scopes[0] != ScopeLinksWrite {
+		t.Errorf("got scopes %v, want just %s", k.Scopes, ScopeLinksWrite)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	if _, err := a.CreateApiKey(ctx, id, "expired", nil, &past); !errors.Is(err, ErrExpirationInPast) {
+		t.Errorf("key expired on creation: got %v, want %v", err, ErrExpirationInPast)
+	}
+	soon := time.Now().Add(50 * time.Millisecond)
+	expiring, err := a.CreateApiKey(ctx, id, "expiring", nil, &soon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Until(soon))
+	if _, err := a.AuthenticateApiKey(ctx, expiring.Key); err != ErrInvalidApiKey {
+		t.Errorf("expired key: got %v, want %v", err, ErrInvalidApiKey)
+	}
+	if _, err := a.AuthenticateApiKey(ctx, "lk_unknown"); err != ErrInvalidApiKey {
+		t.Errorf("unknown key: got %v, want %v", err, ErrInvalidApiKey)
+	}
+}
+
+// TestUpdatePassword checks that a new password ends every session and drops every api key.
+func TestUpdatePassword(t *testing.T) {
+	ctx := context.Background()
+	a, id := newAccountUseCases(t)
+	tokens, err := a.LoginToAccount(ctx, testLogin, testPassword, testIp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := a.CreateApiKey(ctx, id, "ci", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fieldErr *FieldError
+	if err := a.UpdatePassword(ctx, id, "Wrong123", "Secret456"); !errors.As(err, &fieldErr) || fieldErr.Field != "current_password" || fieldErr.Err != ErrWrongPassword {
+		t.Errorf("wrong current password: got %v", err)
+	}
+	if err := a.UpdatePassword(ctx, id, testPassword, "weak"); !errors.As(err, &fieldErr) || fieldErr.Field != "new_password" {
+		t.Errorf("weak new password: got %v", err)
+	}
+	if _, err := a.Authenticate(ctx, tokens.AccessToken); err != nil {
+		t.Fatalf("session ends on a failed change: %v", err)
+	}
+
+	if err := a.UpdatePassword(ctx, id, testPassword, "Secret456"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(ctx, tokens.AccessToken); err != token.ErrRevokedToken {
+		t.Errorf("access token after the change: got %v, want %v", err, token.ErrRevokedToken)
+	}
+	if _, err := a.RefreshToken(ctx, tokens.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("refresh token after the change: got %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if _, err := a.AuthenticateApiKey(ctx, k.Key); err != ErrInvalidApiKey {
+		t.Errorf("api key after the change: got %v, want %v", err, ErrInvalidApiKey)
+	}
+	if _, err := a.LoginToAccount(ctx, testLogin, testPassword, testIp); err != ErrInvalidCredentials {
+		t.Errorf("sign in with the old password: got %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, err := a.LoginToAccount(ctx, testLogin, "Secret456", testIp); err != nil {
+		t.Errorf("sign in with the new password: %v", err)
+	}
+}
+
+// TestDeleteAccount checks that links of a deleted account are deleted or kept anonymous
+// per the policy, and that nothing of the account can be used anymore.
+func TestDeleteAccount(t *testing.T) {
+	backends := map[string]func(t *testing.T) (*AccountUseCases, string){
+		"memory": newAccountUseCases,
+		"sqlite": sqliteAccountUseCases,
+	}
+	for name, newUseCases := range backends {
+		for _, policy := range []LinksPolicy{DeleteLinks, AnonymizeLinks} {
+			t.Run(name+"/"+string(policy), func(t *testing.T) {
+				ctx := context.Background()
+				a, id := newUseCases(t)
+				a.LinksOnDelete = policy
+				if _, err := a.LinkStorage.StoreLink(ctx, link.Link{LinkId: "owned", Link: "http://example.com", AccountId: &id}); err != nil {
+					t.Fatal(err)
+				}
+				tokens, err := a.LoginToAccount(ctx, testLogin, testPassword, testIp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				k, err := a.CreateApiKey(ctx, id, "ci", nil, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var fieldErr *FieldError
+				if err := a.DeleteAccount(ctx, id, "Wrong123"); !errors.As(err, &fieldErr) || fieldErr.Err != ErrWrongPassword {
+					t.Fatalf("wrong password: got %v", err)
+				}
+				if err := a.DeleteAccount(ctx, id, testPassword); err != nil {
+					t.Fatal(err)
+				}
+
+				l, err := a.LinkStorage.GetLinkByLinkId(ctx, "owned")
+				switch policy {
+				case DeleteLinks:
+					if err != link.ErrNotFound {
+						t.Errorf("link of the deleted account: got %v, %v", l, err)
+					}
+				case AnonymizeLinks:
+					if err != nil || l.AccountId != nil {
+						t.Errorf("link of the deleted account: got %v, %v, want it anonymous", l, err)
+					}
+				}
+				if _, err := a.GetAccountById(ctx, id); err != account.ErrNotFound {
+					t.Errorf("deleted account: got %v, want %v", err, account.ErrNotFound)
+				}
+				if _, err := a.Authenticate(ctx, tokens.AccessToken); err != token.ErrRevokedToken {
+					t.Errorf("access token of the deleted account: got %v, want %v", err, token.ErrRevokedToken)
+				}
+				if _, err := a.AuthenticateApiKey(ctx, k.Key); err != ErrInvalidApiKey {
+					t.Errorf("api key of the deleted account: got %v, want %v", err, ErrInvalidApiKey)
+				}
+				if _, err := a.LoginToAccount(ctx, testLogin, testPassword, testIp); err != ErrInvalidCredentials {
+					t.Errorf("sign in to the deleted account: got %v, want %v", err, ErrInvalidCredentials)
+				}
+			})
+		}
+	}
+}
+
+// TestLoginToAccountConcurrent checks that concurrent wrong passwords can't get past the lock
+// of the login, and that the right password is refused while it holds.
+func TestLoginToAccountConcurrent(t *testing.T) {
+	const workers = 20
+	backends := map[string]func(t *testing.T) (*AccountUseCases, string){
+		"memory": newAccountUseCases,
+		"sqlite": sqliteAccountUseCases,
+	}
+	for name, newUseCases := range backends {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			a, _ := newUseCases(t)
+
+			var wg sync.WaitGroup
+			results := make(chan error, workers)
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := a.LoginToAccount(ctx, testLogin, "Wrong123", testIp)
+					results <- err
+				}()
+			}
+			wg.Wait()
+			close(results)
+
+			failed := 0
+			for err := range results {
+				var throttled *ThrottledError
+				switch {
+				case err == ErrInvalidCredentials:
+					failed++
+				case errors.As(err, &throttled):
+					// the lock is set at the time of another attempt, which may have started later
+					if throttled.RetryAfter <= 0 || throttled.RetryAfter > 2*firstLockout {
+						t.Errorf("got Retry-After %v, want about %v", throttled.RetryAfter, firstLockout)
+					}
+				default:
+					t.Error(err)
+				}
+			}
+			// the failure past the allowance is checked, it sets the lock
+			if failed != loginFreeFailures+1 {
+				t.Errorf("%d passwords are checked, want %d", failed, loginFreeFailures+1)
+			}
+			// the first lock is too short to rely on in a slow run, it's set explicitly
+			key := "login:" + testLogin
+			setLock(t, a, key, time.Now().Add(time.Minute))
+			if _, err := a.LoginToAccount(ctx, testLogin, testPassword, testIp); !errors.Is(err, ErrTooManyAttempts) {
+				t.Fatalf("right password while locked: got %v, want %v", err, ErrTooManyAttempts)
+			}
+			setLock(t, a, key, time.Now().Add(-time.Second))
+			if _, err := a.LoginToAccount(ctx, testLogin, testPassword, testIp); err != nil {
+				t.Fatalf("right password after the lock: %v", err)
+			}
+			if attempts, err := a.LoginAttemptStorage.GetAttempts(ctx, key); err != nil || attempts.Failures != 0 {
+				t.Errorf("failures aren't reset by a sign in: %+v, %v", attempts, err)
+			}
+		})
+	}
+}
+
+// setLock replaces the lock of the key.
+func setLock(t *testing.T, a *AccountUseCases, key string, until time.Time) {
+	ctx := context.Background()
+	prev, err := a.LoginAttemptStorage.GetAttempts(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := prev
+	next.LockedUntil = &until
+	if ok, err := a.LoginAttemptStorage.SwapAttempts(ctx, key, prev.Failures, next); err != nil || !ok {
+		t.Fatalf("lock of %s isn't set: %v, %v", key, ok, err)
+	}
+}
+
+// TestLoginToAccountIp checks that failures with any login lock the address, not the other ones.
+func TestLoginToAccountIp(t *testing.T) {
+	ctx := context.Background()
+	a, _ := newAccountUseCases(t)
+	for i := 0; i <= ipFreeFailures; i++ {
+		if _, err := a.LoginToAccount(ctx, fmt.Sprintf("user%d", i), testPassword, testIp); err != ErrInvalidCredentials {
+			t.Fatalf("attempt %d: got %v, want %v", i, err, ErrInvalidCredentials)
+		}
+	}
+	attempts, err := a.LoginAttemptStorage.GetAttempts(ctx, "ip:"+testIp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != ipFreeFailures+1 || attempts.LockedUntil == nil {
+		t.Fatalf("got %+v, want the address locked", attempts)
+	}
+	setLock(t, a, "ip:"+testIp, time.Now().Add(time.Minute))
+	if _, err := a.LoginToAccount(ctx, testLogin, testPassword, testIp); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("sign in from a locked address: got %v, want %v", err, ErrTooManyAttempts)
+	}
+	if _, err := a.LoginToAccount(ctx, testLogin, testPassword, "192.0.2.2"); err != nil {
+		t.Errorf("sign in from another address: %v", err)
+	}
+}
+
+// TestLockout checks that the lockout doubles with every failure up to its maximum.
+func TestLockout(t *testing.T) {
+	tests := []struct {
+		n    int
+		want time.Duration
+	}{
+		{1, firstLockout},
+		{2, 2 * firstLockout},
+		{4, 8 * firstLockout},
+		{100, maxLockout},
+	}
+	for _, tt := range tests {
+		if got := lockout(tt.n); got != tt.want {
+			t.Errorf("lockout(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
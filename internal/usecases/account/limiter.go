@@ -0,0 +1,155 @@
+package account
+
+import (
+	"context"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/loginattempt"
+	"golang.org/x/crypto/bcrypt"
+	"sync"
+	"time"
+)
+
+const (
+	// loginFreeFailures and ipFreeFailures are the failed sign ins allowed before a lockout,
+	// an address is allowed more since many users may share it behind a nat.
+	loginFreeFailures = 5
+	ipFreeFailures    = 20
+	// every failure past the allowance doubles the lockout, starting from firstLockout.
+	firstLockout = time.Second
+	maxLockout   = 15 * time.Minute
+	// attemptsWindow is how long failures are remembered, it outlasts maxLockout,
+	// so a key is never locked when it's forgotten.
+	attemptsWindow = time.Hour
+)
+
+// attemptKey is what failed sign ins are counted by, with its allowance.
+type attemptKey struct {
+	key  string
+	free int
+}
+
+// signinKeys returns the keys of a sign in attempt, the login comes first.
+func signinKeys(login, ip string) []attemptKey {
+	return []attemptKey{
+		{key: "login:" + login, free: loginFreeFailures},
+		{key: "ip:" + ip, free: ipFreeFailures},
+	}
+}
+
+// takeAttempt counts the sign in as failed for every key before the password is checked,
+// so that concurrent attempts can't all pass the lock check before any failure is counted.
+// A key locked by earlier failures refuses the attempt with a ThrottledError, keys taken
+// before it get the attempt back.
+func (a *AccountUseCases) takeAttempt(ctx context.Context, keys []attemptKey, now time.Time) error {
+	for i, k := range keys {
+		if err := a.takeKey(ctx, k, now); err != nil {
+			a.releaseAttempt(ctx, keys[:i], now)
+			return err
+		}
+	}
+	a.sweepAttempts(ctx, now)
+	return nil
+}
+
+// takeKey counts a failure for the key and locks it once past its allowance, in a single swap.
+func (a *AccountUseCases) takeKey(ctx context.Context, k attemptKey, now time.Time) error {
+	for {
+		prev, err := a.LoginAttemptStorage.GetAttempts(ctx, k.key)
+		if err != nil {
+			return err
+		}
+		if prev.LockedUntil != nil && prev.LockedUntil.After(now) {
+			return &ThrottledError{RetryAfter: prev.LockedUntil.Sub(now)}
+		}
+		next := loginattempt.Attempts{Failures: prev.Failures + 1, LastFailureAt: now, LockedUntil: prev.LockedUntil}
+		if prev.LastFailureAt.Before(now.Add(-attemptsWindow)) {
+			next.Failures = 1
+		}
+		if next.Failures > k.free {
+			next.LockedUntil = later(prev.LockedUntil, now.Add(lockout(next.Failures-k.free)))
+		}
+		swapped, err := a.LoginAttemptStorage.SwapAttempts(ctx, k.key, prev.Failures, next)
+		if err != nil || swapped {
+			return err
+		}
+		// another attempt changed the key in between, count on top of it
+	}
+}
+
+// releaseAttempt gives back an attempt taken for the keys which turned out not to be a failure,
+// with the lock it brought if the remaining failures are within the allowance.
+func (a *AccountUseCases) releaseAttempt(ctx context.Context, keys []attemptKey, now time.Time) {
+	for _, k := range keys {
+		if err := a.releaseKey(ctx, k); err != nil {
+			// the count only errs on the strict side
+			a.logger("ReleaseAttempt", err, now)
+		}
+	}
+}
+
+func (a *AccountUseCases) releaseKey(ctx context.Context, k attemptKey) error {
+	for {
+		prev, err := a.LoginAttemptStorage.GetAttempts(ctx, k.key)
+		if err != nil || prev.Failures == 0 {
+			return err
+		}
+		next := prev
+		next.Failures--
+		if next.Failures <= k.free {
+			next.LockedUntil = nil
+		}
+		swapped, err := a.LoginAttemptStorage.SwapAttempts(ctx, k.key, prev.Failures, next)
+		if err != nil || swapped {
+			return err
+		}
+	}
+}
+
+// later returns the later of the lock and t, so that a lock is never shortened.
+func later(lock *time.Time, t time.Time) *time.Time {
+	if lock != nil && lock.After(t) {
+		return lock
+	}
+	return &t
+}
+
+// lockout is the lockout after the nth failure past the allowance.
+func lockout(n int) time.Duration {
+	d := firstLockout
+	for i := 1; i < n && d < maxLockout; i++ {
+		d *= 2
+	}
+	if d > maxLockout {
+		d = maxLockout
+	}
+	return d
+}
+
+// sweepAttempts forgets stale keys at most once per window, otherwise failures
+// with random logins would pile up in the storage.
+func (a *AccountUseCases) sweepAttempts(ctx context.Context, now time.Time) {
+	a.sweepMu.Lock()
+	if now.Sub(a.lastSweep) < attemptsWindow {
+		a.sweepMu.Unlock()
+		return
+	}
+	a.lastSweep = now
+	a.sweepMu.Unlock()
+
+	if err := a.LoginAttemptStorage.DeleteStaleAttempts(ctx, now.Add(-attemptsWindow)); err != nil {
+		a.logger("DeleteStaleAttempts", err, now)
+	}
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// dummyPasswordHash is compared with passwords of unknown logins, so that they are
+// answered as slowly as wrong passwords and don't reveal which logins exist.
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
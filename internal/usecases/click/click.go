@@ -0,0 +1,122 @@
+package click
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/click"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
+	"time"
+)
+
+type Stats struct {
+	TotalClicks    int
+	UniqueVisitors int
+	PerDay         []Bucket
+	PerHour        []Bucket
+}
+
+// Bucket is a number of clicks made during the period starting at Time.
+type Bucket struct {
+	Time   time.Time
+	Clicks int
+}
+
+type ClickUseCases struct {
+	ClickStorage click.Interface
+	LinkStorage  link.Interface
+	// IpSalt is mixed into client addresses before hashing, so stored hashes can't be reversed by brute force.
+	IpSalt string
+}
+
+type ClickUseCasesInterface interface {
+	RegisterClick(ctx context.Context, linkId, referrer, userAgent, ip string) error
+	GetLinkStats(ctx context.Context, linkId, accountId string) (Stats, error)
+
+	//Logging
+	LoggerRegisterClick(
+		registerClick func(ctx context.Context, linkId, referrer, userAgent, ip string) error) func(ctx context.Context, linkId, referrer, userAgent, ip string) error
+	LoggerGetLinkStats(
+		getLinkStats func(ctx context.Context, linkId, accountId string) (Stats, error)) func(ctx context.Context, linkId, accountId string) (Stats, error)
+}
+
+func (c *ClickUseCases) RegisterClick(ctx context.Context, linkId, referrer, userAgent, ip string) error {
+	return c.ClickStorage.StoreClick(ctx, click.Click{
+		LinkId:    linkId,
+		ClickedAt: time.Now().UTC(),
+		Referrer:  referrer,
+		UserAgent: userAgent,
+		IpHash:    c.hashIp(ip),
+	})
+}
+
+func (c *ClickUseCases) GetLinkStats(ctx context.Context, linkId, accountId string) (Stats, error) {
+	l, err := c.LinkStorage.GetLinkByLinkId(ctx, linkId)
+	if err != nil {
+		return Stats{}, err
+	}
+	if l.AccountId == nil || *l.AccountId != accountId {
+		return Stats{}, link.ErrAccessDenied
+	}
+	stats := Stats{}
+	stats.TotalClicks, stats.UniqueVisitors, err = c.ClickStorage.CountClicks(ctx, linkId)
+	if err != nil {
+		return Stats{}, err
+	}
+	perDay, err := c.ClickStorage.CountClicksPer(ctx, linkId, click.Day)
+	if err != nil {
+		return Stats{}, err
+	}
+	perHour, err := c.ClickStorage.CountClicksPer(ctx, linkId, click.Hour)
+	if err != nil {
+		return Stats{}, err
+	}
+	stats.PerDay = toBuckets(perDay)
+	stats.PerHour = toBuckets(perHour)
+	return stats, nil
+}
+
+func (c *ClickUseCases) hashIp(ip string) string {
+	sum := sha256.Sum256([]byte(c.IpSalt + ip))
+	return hex.EncodeToString(sum[:])
+}
+
+func toBuckets(buckets []click.Bucket) []Bucket {
+	res := make([]Bucket, 0, len(buckets))
+	for _, b := range buckets {
+		res = append(res, Bucket{Time: b.Time, Clicks: b.Clicks})
+	}
+	return res
+}
+
+func (c *ClickUseCases) logger(method string, err error, start time.Time) {
+	status := "SUCCESS"
+	if err != nil {
+		status = err.Error()
+	}
+	fmt.Printf("method: %s; status-code: %s; call time: %v; duration: %v;\n",
+		method, status, start, time.Since(start))
+}
+
+func (c *ClickUseCases) LoggerRegisterClick(
+	registerClick func(ctx context.Context, linkId, referrer, userAgent, ip string) error) func(ctx context.Context, linkId, referrer, userAgent, ip string) error {
+
+	return func(ctx context.Context, linkId, referrer, userAgent, ip string) error {
+		start := time.Now()
+		err := registerClick(ctx, linkId, referrer, userAgent, ip)
+		c.logger("RegisterClick", err, start)
+		return err
+	}
+}
+
+func (c *ClickUseCases) LoggerGetLinkStats(
+	getLinkStats func(ctx context.Context, linkId, accountId string) (Stats, error)) func(ctx context.Context, linkId, accountId string) (Stats, error) {
+
+	return func(ctx context.Context, linkId, accountId string) (Stats, error) {
+		start := time.Now()
+		stats, err := getLinkStats(ctx, linkId, accountId)
+		c.logger("GetLinkStats", err, start)
+		return stats, err
+	}
+}
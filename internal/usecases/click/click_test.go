@@ -0,0 +1,215 @@
+package click
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	_ "github.com/lib/pq"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/click"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/status"
+	memoryclickrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/clickrepo"
+	memorylinkrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/linkrepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/postgres/clickrepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/postgres/linkrepo"
+	sqliteclickrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/clickrepo"
+	sqlitelinkrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/linkrepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/migrate"
+	_ "modernc.org/sqlite"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// storage is a click storage with the link storage its clicks reference.
+type storage struct {
+	links  link.Interface
+	clicks click.Interface
+}
+
+// storages returns the click storages to check: memory, a temporary sqlite file
+// and postgres if LENKE_TEST_POSTGRES_DSN is set.
+func storages(t *testing.T) map[string]storage {
+	links := memorylinkrepo.NewMemory()
+	res := map[string]storage{
+		"memory": {links: links, clicks: memoryclickrepo.NewMemory(links)},
+		"sqlite": sqliteStorage(t),
+	}
+	dsn := os.Getenv("LENKE_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Log("LENKE_TEST_POSTGRES_DSN is not set, skipping postgres")
+		return res
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	res["postgres"] = storage{links: linkrepo.New(conn), clicks: clickrepo.New(conn)}
+	return res
+}
+
+func sqliteStorage(t *testing.T) storage {
+	conn, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "lenke.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetMaxOpenConns(1)
+	m, err := migrate.New(conn, migrate.Sqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return storage{links: sqlitelinkrepo.New(conn), clicks: sqliteclickrepo.New(conn)}
+}
+
+// storeLink stores a link with an id unique to the test run, so rows already stored
+// in a shared database don't count.
+func storeLink(t *testing.T, links link.Interface, name string) string {
+	linkId := fmt.Sprintf("t%d-%s", time.Now().UnixNano(), name)
+	_, err := links.StoreLink(context.Background(), link.Link{
+		LinkId:     linkId,
+		Link:       "http://example.com/" + name,
+		LinkStatus: status.OK,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return linkId
+}
+
+func checkBuckets(t *testing.T, period click.Period, got []click.Bucket, want []click.Bucket) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("per %s: got %v, want %v", period, got, want)
+	}
+	for i := range want {
+		if !got[i].Time.Equal(want[i].Time) || got[i].Clicks != want[i].Clicks {
+			t.Errorf("per %s: got %v, want %v", period, got, want)
+			return
+		}
+	}
+}
+
+// TestCountClicks checks unique visitors and that clicks stored with any offset are counted
+// in the UTC day and hour they were made in.
+func TestCountClicks(t *testing.T) {
+	// 01:30 in +03:00 is 22:30 of the previous day in UTC
+	east := time.FixedZone("east", 3*60*60)
+	day := time.Date(2021, 4, 30, 0, 0, 0, 0, time.UTC)
+	for name, s := range storages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			linkId := storeLink(t, s.links, "count")
+			clicks := []click.Click{
+				{LinkId: linkId, ClickedAt: time.Date(2021, 5, 1, 1, 30, 0, 0, east), IpHash: "a"},
+				{LinkId: linkId, ClickedAt: day.Add(22*time.Hour + 10*time.Minute), IpHash: "a"},
+				{LinkId: linkId, ClickedAt: day.Add(34 * time.Hour), IpHash: "b"},
+			}
+			if err := s.clicks.StoreClicks(ctx, clicks[:2]); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.clicks.StoreClick(ctx, clicks[2]); err != nil {
+				t.Fatal(err)
+			}
+
+			total, unique, err := s.clicks.CountClicks(ctx, linkId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != 3 || unique != 2 {
+				t.Errorf("got %d clicks of %d visitors, want 3 of 2", total, unique)
+			}
+			perDay, err := s.clicks.CountClicksPer(ctx, linkId, click.Day)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkBuckets(t, click.Day, perDay, []click.Bucket{
+				{Time: day, Clicks: 2},
+				{Time: day.Add(24 * time.Hour), Clicks: 1},
+			})
+			perHour, err := s.clicks.CountClicksPer(ctx, linkId, click.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkBuckets(t, click.Hour, perHour, []click.Bucket{
+				{Time: day.Add(22 * time.Hour), Clicks: 2},
+				{Time: day.Add(34 * time.Hour), Clicks: 1},
+			})
+		})
+	}
+}
+
+// TestStoreClicksDeletedLink checks that a batch with clicks of deleted links stores the rest,
+// and that an id reused after a link is deleted starts without clicks.
+func TestStoreClicksDeletedLink(t *testing.T) {
+	for name, s := range storages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			kept := storeLink(t, s.links, "kept")
+			deleted := storeLink(t, s.links, "deleted")
+			now := time.Now()
+			if err := s.clicks.StoreClicks(ctx, []click.Click{{LinkId: deleted, ClickedAt: now, IpHash: "a"}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.links.DeleteLink(ctx, deleted); err != nil {
+				t.Fatal(err)
+			}
+
+			err := s.clicks.StoreClicks(ctx, []click.Click{
+				{LinkId: kept, ClickedAt: now, IpHash: "a"},
+				{LinkId: deleted, ClickedAt: now, IpHash: "a"},
+				{LinkId: kept, ClickedAt: now, IpHash: "b"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total, _, err := s.clicks.CountClicks(ctx, kept); err != nil || total != 2 {
+				t.Errorf("link in the batch: got %d clicks, %v, want 2", total, err)
+			}
+
+			_, err = s.links.StoreLink(ctx, link.Link{LinkId: deleted, Link: "http://example.com/again", LinkStatus: status.OK})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total, _, err := s.clicks.CountClicks(ctx, deleted); err != nil || total != 0 {
+				t.Errorf("re-created link: got %d clicks, %v, want 0", total, err)
+			}
+		})
+	}
+}
+
+// TestGetLinkStatsAccess checks that only the owner of a link sees its stats.
+func TestGetLinkStatsAccess(t *testing.T) {
+	ctx := context.Background()
+	links := memorylinkrepo.NewMemory()
+	uc := &ClickUseCases{
+		ClickStorage: memoryclickrepo.NewMemory(links),
+		LinkStorage:  links,
+		IpSalt:       "test",
+	}
+	owner := "1"
+	_, err := links.StoreLink(ctx, link.Link{LinkId: "owned", Link: "http://example.com", LinkStatus: status.OK, AccountId: &owner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := uc.RegisterClick(ctx, "owned", "", "", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := uc.GetLinkStats(ctx, "owned", "2"); !errors.Is(err, link.ErrAccessDenied) {
+		t.Errorf("stats of another account's link: got %v, want %v", err, link.ErrAccessDenied)
+	}
+	stats, err := uc.GetLinkStats(ctx, "owned", owner)
+	if err != nil || stats.TotalClicks != 1 || stats.UniqueVisitors != 1 {
+		t.Errorf("owner got %+v, %v, want one click", stats, err)
+	}
+	if err := uc.RegisterClick(ctx, "missing", "", "", "192.0.2.1"); !errors.Is(err, link.ErrNotFound) {
+		t.Errorf("click of a missing link: got %v, want %v", err, link.ErrNotFound)
+	}
+}
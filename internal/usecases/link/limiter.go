@@ -0,0 +1,77 @@
+package link
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	maxUnlockAttempts    = 5
+	unlockAttemptsWindow = 15 * time.Minute
+)
+
+// attemptLimiter counts attempts per key within a sliding window, keys without
+// attempts in the window are evicted. The zero value is ready to use.
+type attemptLimiter struct {
+	mu        sync.Mutex
+	attempts  map[string][]time.Time
+	lastSweep time.Time
+}
+
+// Take registers an attempt for the key before it's made and reports whether it's allowed,
+// so that concurrent attempts can't all pass the check before any of them fails.
+// An attempt that turns out not to be a failure is handed back with Release.
+func (l *attemptLimiter) Take(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.attempts == nil {
+		l.attempts = make(map[string][]time.Time)
+	}
+	now := time.Now()
+	l.sweep(now)
+	attempts := l.recent(key, now)
+	if len(attempts) >= maxUnlockAttempts {
+		return false
+	}
+	l.attempts[key] = append(attempts, now)
+	return true
+}
+
+// Release forgets the latest attempt taken for the key.
+func (l *attemptLimiter) Release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	attempts := l.attempts[key]
+	if len(attempts) <= 1 {
+		delete(l.attempts, key)
+		return
+	}
+	l.attempts[key] = attempts[:len(attempts)-1]
+}
+
+// sweep evicts idle keys, at most once per window since it walks all of them.
+func (l *attemptLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < unlockAttemptsWindow {
+		return
+	}
+	l.lastSweep = now
+	for key := range l.attempts {
+		l.recent(key, now)
+	}
+}
+
+// recent drops the attempts which left the window and returns the rest.
+func (l *attemptLimiter) recent(key string, now time.Time) []time.Time {
+	attempts := l.attempts[key]
+	i := 0
+	for i < len(attempts) && now.Sub(attempts[i]) >= unlockAttemptsWindow {
+		i++
+	}
+	attempts = attempts[i:]
+	if len(attempts) == 0 {
+		delete(l.attempts, key)
+		return nil
+	}
+	l.attempts[key] = attempts
+	return attempts
+}
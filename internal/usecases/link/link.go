@@ -1,128 +1,470 @@
 package link
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
 	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/status"
-	"math/rand"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"sync/atomic"
 	"time"
-	"unsafe"
+)
+
+var (
+	ErrInvalidAlias  = errors.New("alias contains invalid character")
+	ErrTooShortAlias = errors.New("too short alias")
+	ErrTooLongAlias  = errors.New("too long alias")
+	ErrReservedAlias = errors.New("alias is reserved")
+
+	ErrExpirationInPast = errors.New("expiration time is in the past")
+	ErrInvalidMaxClicks = errors.New("max clicks must be positive")
+
+	ErrRevisionNotFound = errors.New("revision not found")
+
+	ErrNoFreeLinkId = errors.New("no free link id left")
+
+	ErrPasswordRequired = errors.New("link is protected by password")
+	ErrWrongPassword    = errors.New("wrong password")
+	ErrTooLongPassword  = errors.New("too long password")
+	ErrTooManyAttempts  = errors.New("too many failed attempts")
 )
 
 const (
-	linkLength    = 6
-	letterIdxBits = 6
-	letterIdxMask = 1<<letterIdxBits - 1
-	letterIdxMax  = 63 / letterIdxBits
-	letterBytes   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	// bcrypt ignores everything past 72 bytes
+	maxPasswordLength = 72
+
+	minAliasLength = 3
+	maxAliasLength = 32
+	aliasBytes     = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
 )
 
+// reservedAliases are the top-level route words that must never become link ids.
+var reservedAliases = map[string]struct{}{
+	"signup":   {},
+	"signin":   {},
+	"metrics":  {},
+	"accounts": {},
+	"links":    {},
+}
+
+const (
+	DefaultIdLength = 6
+	// idRetries is the number of collisions in a row after which generated ids get longer.
+	idRetries   = 3
+	maxIdLength = 64
+)
+
+// LinkIdGenerator makes candidates for ids of new links, they are checked for collisions by the caller.
+type LinkIdGenerator interface {
+	Generate(length int) (string, error)
+}
+
 type Link struct {
 	LinkId     string
 	Link       string
 	LinkStatus status.LinkStatus
+	ExpiresAt  *time.Time
+	MaxClicks  *int64
+	Clicks     int64
+	Expired    bool
+	Protected  bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// CutLinkOptions holds optional parameters of a new short link.
+type CutLinkOptions struct {
+	// Alias is a custom link id requested by the caller, generated if empty.
+	Alias string
+	// ExpiresAt is the moment the link stops redirecting, never if nil.
+	ExpiresAt *time.Time
+	// MaxClicks is the number of redirects the link serves, unlimited if nil.
+	MaxClicks *int64
+	// Password protects the redirect with a passphrase, the link is public if empty.
+	Password string
+	// Reuse returns an existing link of the same owner to the same destination instead of creating a new one.
+	// It only applies to plain links: without alias, password, expiration time and click limit.
+	Reuse bool
+}
+
+// Revision is a destination the link had before it was changed at ChangedAt.
+type Revision struct {
+	Id        int64
+	Link      string
+	ChangedAt time.Time
+}
+
+// LinkUpdate holds link attributes to change, nil fields are left as is.
+type LinkUpdate struct {
+	Link      *string
+	ExpiresAt *time.Time
+	MaxClicks *int64
 }
 
 type LinkUseCases struct {
 	LinkStorage link.Interface
+	IdGenerator LinkIdGenerator
+	// IdLength is the initial length of generated ids, DefaultIdLength if zero.
+	IdLength int
+
+	unlockAttempts attemptLimiter
+	// idLength is the current length of generated ids, it grows when the id space gets crowded.
+	idLength int32
 }
 
 type LinkUseCasesInterface interface {
-	GetLinkByLinkId(linkId string) (string, error)
-	CutLink(link string, accountId *string) (string, error)
-	DeleteLink(linkId string, accountId string) error
-	GetLinksByAccountId(accountId string) ([]Link, error)
+	GetLinkByLinkId(ctx context.Context, linkId string) (string, error)
+	UnlockLink(ctx context.Context, linkId string, password string) (string, error)
+	LinkExists(ctx context.Context, linkId string) (bool, error)
+	CutLink(ctx context.Context, link string, accountId *string, opts CutLinkOptions) (string, error)
+	DeleteLink(ctx context.Context, linkId string, accountId string) error
+	GetLinksByAccountId(ctx context.Context, accountId string) ([]Link, error)
+	GetLink(ctx context.Context, linkId string, accountId string) (Link, error)
+	UpdateLink(ctx context.Context, linkId string, accountId string, upd LinkUpdate) (Link, error)
+	GetLinkRevisions(ctx context.Context, linkId string, accountId string) ([]Revision, error)
+	RollbackLink(ctx context.Context, linkId string, accountId string, revisionId int64) (Link, error)
 
 	//Logging
 	LoggerGetLinkByLinkId(
-		getLinkByLinkId func(linkId string) (string, error)) func(linkId string) (string, error)
+		getLinkByLinkId func(ctx context.Context, linkId string) (string, error)) func(ctx context.Context, linkId string) (string, error)
+	LoggerUnlockLink(
+		unlockLink func(ctx context.Context, linkId string, password string) (string, error)) func(ctx context.Context, linkId string, password string) (string, error)
+	LoggerLinkExists(
+		linkExists func(ctx context.Context, linkId string) (bool, error)) func(ctx context.Context, linkId string) (bool, error)
 	LoggerCutLink(
-		cutLink func(link string, accountId *string) (string, error)) func(link string, accountId *string) (string, error)
+		cutLink func(ctx context.Context, link string, accountId *string, opts CutLinkOptions) (string, error)) func(ctx context.Context, link string, accountId *string, opts CutLinkOptions) (string, error)
 	LoggerDeleteLink(
-		deleteLink func(linkId string, accountId string) error) func(linkId string, accountId string) error
+		deleteLink func(ctx context.Context, linkId string, accountId string) error) func(ctx context.Context, linkId string, accountId string) error
 	LoggerGetLinksByAccountId(
-		getLinksByAccountId func(accountId string) ([]Link, error)) func(accountId string) ([]Link, error)
+		getLinksByAccountId func(ctx context.Context, accountId string) ([]Link, error)) func(ctx context.Context, accountId string) ([]Link, error)
+	LoggerGetLink(
+		getLink func(ctx context.Context, linkId string, accountId string) (Link, error)) func(ctx context.Context, linkId string, accountId string) (Link, error)
+	LoggerUpdateLink(
+		updateLink func(ctx context.Context, linkId string, accountId string, upd LinkUpdate) (Link, error)) func(ctx context.Context, linkId string, accountId string, upd LinkUpdate) (Link, error)
+	LoggerGetLinkRevisions(
+		getLinkRevisions func(ctx context.Context, linkId string, accountId string) ([]Revision, error)) func(ctx context.Context, linkId string, accountId string) ([]Revision, error)
+	LoggerRollbackLink(
+		rollbackLink func(ctx context.Context, linkId string, accountId string, revisionId int64) (Link, error)) func(ctx context.Context, linkId string, accountId string, revisionId int64) (Link, error)
+}
+
+func (a *LinkUseCases) GetLinkByLinkId(ctx context.Context, lnk string) (string, error) {
+	l, err := a.LinkStorage.GetLinkByLinkId(ctx, lnk)
+	if err != nil {
+		return "", err
+	}
+	if l.Expired(time.Now()) {
+		return "", link.ErrExpired
+	}
+	if l.Password != "" {
+		return "", ErrPasswordRequired
+	}
+	return a.visit(ctx, l)
 }
 
-func (a *LinkUseCases) GetLinkByLinkId(lnk string) (string, error) {
-	l, err := a.LinkStorage.GetLinkByLinkId(lnk)
+// UnlockLink resolves a password protected link, failed attempts are limited per link.
+func (a *LinkUseCases) UnlockLink(ctx context.Context, lnk string, password string) (string, error) {
+	l, err := a.LinkStorage.GetLinkByLinkId(ctx, lnk)
 	if err != nil {
 		return "", err
 	}
+	if l.Expired(time.Now()) {
+		return "", link.ErrExpired
+	}
+	if l.Password != "" {
+		if !a.unlockAttempts.Take(lnk) {
+			return "", ErrTooManyAttempts
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(l.Password), []byte(password)); err != nil {
+			if err == bcrypt.ErrMismatchedHashAndPassword {
+				return "", ErrWrongPassword
+			}
+			a.unlockAttempts.Release(lnk)
+			return "", err
+		}
+		a.unlockAttempts.Release(lnk)
+	}
+	return a.visit(ctx, l)
+}
+
+func (a *LinkUseCases) LinkExists(ctx context.Context, lnk string) (bool, error) {
+	return a.LinkStorage.CheckIfLinkExists(ctx, lnk)
+}
+
+// visit counts the redirect if the link has a click limit and returns its destination.
+func (a *LinkUseCases) visit(ctx context.Context, l link.Link) (string, error) {
+	if l.MaxClicks != nil {
+		if err := a.LinkStorage.IncrementLinkClicks(ctx, l.LinkId); err != nil {
+			return "", err
+		}
+	}
 	return l.Link, nil
 }
 
-func (a *LinkUseCases) CutLink(lnk string, accountId *string) (string, error) {
-	linkId := a.generateFreeLinkId()
-	l, err := a.LinkStorage.StoreLink(link.Link{
-		LinkId:    linkId,
+func (a *LinkUseCases) CutLink(ctx context.Context, lnk string, accountId *string, opts CutLinkOptions) (string, error) {
+	lnk, err := normalizeUrl(lnk)
+	if err != nil {
+		return "", err
+	}
+	if opts.ExpiresAt != nil && !opts.ExpiresAt.After(time.Now()) {
+		return "", ErrExpirationInPast
+	}
+	if opts.MaxClicks != nil && *opts.MaxClicks <= 0 {
+		return "", ErrInvalidMaxClicks
+	}
+	// the request is rejected before the password is hashed, bcrypt takes most of the call
+	if opts.Alias != "" {
+		if err := validateAlias(opts.Alias); err != nil {
+			return "", err
+		}
+	}
+	hashedPassword := ""
+	if opts.Password != "" {
+		if len(opts.Password) > maxPasswordLength {
+			return "", ErrTooLongPassword
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+		hashedPassword = string(hash)
+	}
+	if opts.Reuse && opts.plain() {
+		linkId, err := a.findPlainLink(ctx, lnk, accountId)
+		if err != nil {
+			return "", err
+		}
+		if linkId != "" {
+			return linkId, nil
+		}
+	}
+	l := link.Link{
 		Link:      lnk,
 		AccountId: accountId,
-	})
+		Password:  hashedPassword,
+		ExpiresAt: opts.ExpiresAt,
+		MaxClicks: opts.MaxClicks,
+	}
+	if opts.Alias != "" {
+		l.LinkId = opts.Alias
+		l, err = a.LinkStorage.StoreLink(ctx, l)
+	} else {
+		l, err = a.storeWithFreeLinkId(ctx, l)
+	}
 	if err != nil {
 		return "", err
 	}
 	return l.LinkId, nil
 }
 
-func (a *LinkUseCases) DeleteLink(lnk string, accountId string) error {
-	dbLink, err := a.LinkStorage.GetLinkByLinkId(lnk)
-	if err != nil {
-		if err == link.ErrNotFound {
-			return nil
-		}
+// DeleteLink deletes a link of the account, anonymous links can't be deleted by anyone.
+func (a *LinkUseCases) DeleteLink(ctx context.Context, lnk string, accountId string) error {
+	if _, err := a.ownedLink(ctx, lnk, accountId); err != nil {
 		return err
 	}
-	if dbLink.AccountId != nil && *dbLink.AccountId != accountId {
-		return link.ErrAccessDenied
-	}
-	return a.LinkStorage.DeleteLink(lnk)
+	return a.LinkStorage.DeleteLink(ctx, lnk)
 }
 
-func (a *LinkUseCases) GetLinksByAccountId(accountId string) ([]Link, error) {
-	links, err := a.LinkStorage.GetLinksByAccountId(accountId)
+func (a *LinkUseCases) GetLinksByAccountId(ctx context.Context, accountId string) ([]Link, error) {
+	links, err := a.LinkStorage.GetLinksByAccountId(ctx, accountId)
 	if err != nil {
 		return nil, err
 	}
+	now := time.Now()
 	res := make([]Link, 0, len(links))
 	for _, l := range links {
-		res = append(res, Link{
-			LinkId:     l.LinkId,
-			Link:       l.Link,
-			LinkStatus: l.LinkStatus,
+		res = append(res, toLink(l, now))
+	}
+	return res, nil
+}
+
+func (a *LinkUseCases) GetLink(ctx context.Context, linkId string, accountId string) (Link, error) {
+	l, err := a.ownedLink(ctx, linkId, accountId)
+	if err != nil {
+		return Link{}, err
+	}
+	return toLink(l, time.Now()), nil
+}
+
+func (a *LinkUseCases) UpdateLink(ctx context.Context, linkId string, accountId string, upd LinkUpdate) (Link, error) {
+	l, err := a.ownedLink(ctx, linkId, accountId)
+	if err != nil {
+		return Link{}, err
+	}
+	if upd.ExpiresAt != nil {
+		if !upd.ExpiresAt.After(time.Now()) {
+			return Link{}, ErrExpirationInPast
+		}
+		l.ExpiresAt = upd.ExpiresAt
+	}
+	if upd.MaxClicks != nil {
+		if *upd.MaxClicks <= 0 {
+			return Link{}, ErrInvalidMaxClicks
+		}
+		l.MaxClicks = upd.MaxClicks
+	}
+	if upd.Link != nil {
+		target, err := normalizeUrl(*upd.Link)
+		if err != nil {
+			return Link{}, err
+		}
+		if target != l.Link {
+			l.Link = target
+			l.LinkStatus = status.Unknown
+		}
+	}
+	l, err = a.LinkStorage.UpdateLink(ctx, l)
+	if err != nil {
+		return Link{}, err
+	}
+	return toLink(l, time.Now()), nil
+}
+
+func (a *LinkUseCases) GetLinkRevisions(ctx context.Context, linkId string, accountId string) ([]Revision, error) {
+	if _, err := a.ownedLink(ctx, linkId, accountId); err != nil {
+		return nil, err
+	}
+	revisions, err := a.LinkStorage.GetLinkRevisions(ctx, linkId)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Revision, 0, len(revisions))
+	for _, r := range revisions {
+		res = append(res, Revision{
+			Id:        r.Id,
+			Link:      r.Link,
+			ChangedAt: r.ChangedAt,
 		})
 	}
 	return res, nil
 }
 
-var src = rand.NewSource(time.Now().UnixNano())
+// RollbackLink restores the destination kept in the revision, the current one becomes a new revision.
+func (a *LinkUseCases) RollbackLink(ctx context.Context, linkId string, accountId string, revisionId int64) (Link, error) {
+	revisions, err := a.GetLinkRevisions(ctx, linkId, accountId)
+	if err != nil {
+		return Link{}, err
+	}
+	for _, r := range revisions {
+		if r.Id == revisionId {
+			return a.UpdateLink(ctx, linkId, accountId, LinkUpdate{Link: &r.Link})
+		}
+	}
+	return Link{}, ErrRevisionNotFound
+}
 
-func generateLinkId() (linkId string) {
+func (o CutLinkOptions) plain() bool {
+	return o.Alias == "" && o.ExpiresAt == nil && o.MaxClicks == nil && o.Password == ""
+}
 
-	b := make([]byte, linkLength)
-	for i, cache, remain := linkLength-1, src.Int63(), letterIdxMax; i >= 0; {
-		if remain == 0 {
-			cache, remain = src.Int63(), letterIdxMax
+// findPlainLink returns the id of the oldest plain link of the owner to target, empty if there is none.
+func (a *LinkUseCases) findPlainLink(ctx context.Context, target string, accountId *string) (string, error) {
+	links, err := a.LinkStorage.GetLinksByTarget(ctx, target, accountId)
+	if err != nil {
+		return "", err
+	}
+	res := ""
+	var createdAt time.Time
+	for _, l := range links {
+		if l.ExpiresAt != nil || l.MaxClicks != nil || l.Password != "" {
+			continue
 		}
-		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
-			b[i] = letterBytes[idx]
-			i--
+		if res == "" || l.CreatedAt.Before(createdAt) {
+			res, createdAt = l.LinkId, l.CreatedAt
 		}
-		cache >>= letterIdxBits
-		remain--
 	}
+	return res, nil
+}
 
-	return *(*string)(unsafe.Pointer(&b))
+// ownedLink returns the link if it belongs to the account.
+func (a *LinkUseCases) ownedLink(ctx context.Context, linkId string, accountId string) (link.Link, error) {
+	l, err := a.LinkStorage.GetLinkByLinkId(ctx, linkId)
+	if err != nil {
+		return link.Link{}, err
+	}
+	if l.AccountId == nil || *l.AccountId != accountId {
+		return link.Link{}, link.ErrAccessDenied
+	}
+	return l, nil
 }
 
-func (a *LinkUseCases) generateFreeLinkId() (linkId string) {
+func toLink(l link.Link, now time.Time) Link {
+	return Link{
+		LinkId:     l.LinkId,
+		Link:       l.Link,
+		LinkStatus: l.LinkStatus,
+		ExpiresAt:  l.ExpiresAt,
+		MaxClicks:  l.MaxClicks,
+		Clicks:     l.Clicks,
+		Expired:    l.Expired(now),
+		Protected:  l.Password != "",
+		CreatedAt:  l.CreatedAt,
+		UpdatedAt:  l.UpdatedAt,
+	}
+}
+
+// storeWithFreeLinkId stores the link under a generated id, storage enforces
+// uniqueness so a taken id is just replaced by a new candidate. The length of ids
+// grows by one every time idRetries candidates in a row turn out to be taken.
+func (a *LinkUseCases) storeWithFreeLinkId(ctx context.Context, l link.Link) (link.Link, error) {
 	for {
-		linkId = generateLinkId()
-		if !a.LinkStorage.CheckIfLinkExists(linkId) {
-			break
+		length := a.currentIdLength()
+		for i := 0; i < idRetries; i++ {
+			linkId, err := a.IdGenerator.Generate(int(length))
+			if err != nil {
+				return link.Link{}, err
+			}
+			if isReservedAlias(linkId) {
+				continue
+			}
+			l.LinkId = linkId
+			stored, err := a.LinkStorage.StoreLink(ctx, l)
+			if err != link.ErrAlreadyExist {
+				return stored, err
+			}
+		}
+		if length >= maxIdLength {
+			return link.Link{}, ErrNoFreeLinkId
+		}
+		// another request may have grown it already
+		atomic.CompareAndSwapInt32(&a.idLength, length, length+1)
+	}
+}
+
+func (a *LinkUseCases) currentIdLength() int32 {
+	if length := atomic.LoadInt32(&a.idLength); length != 0 {
+		return length
+	}
+	length := int32(a.IdLength)
+	if length <= 0 {
+		length = DefaultIdLength
+	}
+	atomic.CompareAndSwapInt32(&a.idLength, 0, length)
+	return atomic.LoadInt32(&a.idLength)
+}
+
+func validateAlias(alias string) error {
+	for _, r := range alias {
+		if !strings.ContainsRune(aliasBytes, r) {
+			return ErrInvalidAlias
 		}
 	}
-	return linkId
+	if len(alias) < minAliasLength {
+		return ErrTooShortAlias
+	}
+	if len(alias) > maxAliasLength {
+		return ErrTooLongAlias
+	}
+	if isReservedAlias(alias) {
+		return ErrReservedAlias
+	}
+	return nil
+}
+
+func isReservedAlias(alias string) bool {
+	_, ok := reservedAliases[strings.ToLower(alias)]
+	return ok
 }
 
 func (a *LinkUseCases) logger(method string, err error, start time.Time) {
@@ -135,45 +477,111 @@ func (a *LinkUseCases) logger(method string, err error, start time.Time) {
 }
 
 func (a *LinkUseCases) LoggerGetLinkByLinkId(
-	getLinkByLinkId func(linkId string) (string, error)) func(linkId string) (string, error) {
+	getLinkByLinkId func(ctx context.Context, linkId string) (string, error)) func(ctx context.Context, linkId string) (string, error) {
 
-	return func(linkId string) (string, error) {
+	return func(ctx context.Context, linkId string) (string, error) {
 		start := time.Now()
-		link, err := getLinkByLinkId(linkId)
+		link, err := getLinkByLinkId(ctx, linkId)
 		a.logger("GetLinkByLinkId", err, start)
 		return link, err
 	}
 }
 
+func (a *LinkUseCases) LoggerUnlockLink(
+	unlockLink func(ctx context.Context, linkId string, password string) (string, error)) func(ctx context.Context, linkId string, password string) (string, error) {
+
+	return func(ctx context.Context, linkId string, password string) (string, error) {
+		start := time.Now()
+		link, err := unlockLink(ctx, linkId, password)
+		a.logger("UnlockLink", err, start)
+		return link, err
+	}
+}
+
+func (a *LinkUseCases) LoggerLinkExists(
+	linkExists func(ctx context.Context, linkId string) (bool, error)) func(ctx context.Context, linkId string) (bool, error) {
+
+	return func(ctx context.Context, linkId string) (bool, error) {
+		start := time.Now()
+		exists, err := linkExists(ctx, linkId)
+		a.logger("LinkExists", err, start)
+		return exists, err
+	}
+}
+
 func (a *LinkUseCases) LoggerCutLink(
-	cutLink func(link string, accountId *string) (string, error)) func(link string, accountId *string) (string, error) {
+	cutLink func(ctx context.Context, link string, accountId *string, opts CutLinkOptions) (string, error)) func(ctx context.Context, link string, accountId *string, opts CutLinkOptions) (string, error) {
 
-	return func(link string, accountId *string) (string, error) {
+	return func(ctx context.Context, link string, accountId *string, opts CutLinkOptions) (string, error) {
 		start := time.Now()
-		linkId, err := cutLink(link, accountId)
+		linkId, err := cutLink(ctx, link, accountId, opts)
 		a.logger("CutLink", err, start)
 		return linkId, err
 	}
 }
 
 func (a *LinkUseCases) LoggerDeleteLink(
-	deleteLink func(linkId string, accountId string) error) func(linkId string, accountId string) error {
+	deleteLink func(ctx context.Context, linkId string, accountId string) error) func(ctx context.Context, linkId string, accountId string) error {
 
-	return func(linkId string, accountId string) error {
+	return func(ctx context.Context, linkId string, accountId string) error {
 		start := time.Now()
-		err := deleteLink(linkId, accountId)
+		err := deleteLink(ctx, linkId, accountId)
 		a.logger("DeleteLink", err, start)
 		return err
 	}
 }
 
 func (a *LinkUseCases) LoggerGetLinksByAccountId(
-	getLinksByAccountId func(accountId string) ([]Link, error)) func(accountId string) ([]Link, error) {
+	getLinksByAccountId func(ctx context.Context, accountId string) ([]Link, error)) func(ctx context.Context, accountId string) ([]Link, error) {
 
-	return func(accountId string) ([]Link, error) {
+	return func(ctx context.Context, accountId string) ([]Link, error) {
 		start := time.Now()
-		res, err := getLinksByAccountId(accountId)
+		res, err := getLinksByAccountId(ctx, accountId)
 		a.logger("GetLinksByAccountId", err, start)
 		return res, err
 	}
 }
+
+func (a *LinkUseCases) LoggerGetLink(
+	getLink func(ctx context.Context, linkId string, accountId string) (Link, error)) func(ctx context.Context, linkId string, accountId string) (Link, error) {
+
+	return func(ctx context.Context, linkId string, accountId string) (Link, error) {
+		start := time.Now()
+		res, err := getLink(ctx, linkId, accountId)
+		a.logger("GetLink", err, start)
+		return res, err
+	}
+}
+
+func (a *LinkUseCases) LoggerUpdateLink(
+	updateLink func(ctx context.Context, linkId string, accountId string, upd LinkUpdate) (Link, error)) func(ctx context.Context, linkId string, accountId string, upd LinkUpdate) (Link, error) {
+
+	return func(ctx context.Context, linkId string, accountId string, upd LinkUpdate) (Link, error) {
+		start := time.Now()
+		res, err := updateLink(ctx, linkId, accountId, upd)
+		a.logger("UpdateLink", err, start)
+		return res, err
+	}
+}
+
+func (a *LinkUseCases) LoggerGetLinkRevisions(
+	getLinkRevisions func(ctx context.Context, linkId string, accountId string) ([]Revision, error)) func(ctx context.Context, linkId string, accountId string) ([]Revision, error) {
+
+	return func(ctx context.Context, linkId string, accountId string) ([]Revision, error) {
+		start := time.Now()
+		res, err := getLinkRevisions(ctx, linkId, accountId)
+		a.logger("GetLinkRevisions", err, start)
+		return res, err
+	}
+}
+
+func (a *LinkUseCases) LoggerRollbackLink(
+	rollbackLink func(ctx context.Context, linkId string, accountId string, revisionId int64) (Link, error)) func(ctx context.Context, linkId string, accountId string, revisionId int64) (Link, error) {
+
+	return func(ctx context.Context, linkId string, accountId string, revisionId int64) (Link, error) {
+		start := time.Now()
+		res, err := rollbackLink(ctx, linkId, accountId, revisionId)
+		a.logger("RollbackLink", err, start)
+		return res, err
+	}
+}
@@ -0,0 +1,541 @@
+package link
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/link"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/domain/status"
+	memorylinkrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/memory/linkrepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/interface/postgres/linkrepo"
+	sqlitelinkrepo "github.com/mp-hl-2021/lenkeforkortelse/internal/interface/sqlite/linkrepo"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/migrate"
+	"github.com/mp-hl-2021/lenkeforkortelse/internal/service/idgen"
+	_ "modernc.org/sqlite"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	stressWorkers = 16
+	stressLinks   = 50
+)
+
+// prefixed keeps ids of a test run apart from the rows already stored in a shared database.
+type prefixed struct {
+	prefix string
+	gen    LinkIdGenerator
+}
+
+func (p prefixed) Generate(length int) (string, error) {
+	id, err := p.gen.Generate(length)
+	return p.prefix + id, err
+}
+
+// storages returns the link storages to stress: memory, a temporary sqlite file
+// and postgres if LENKE_TEST_POSTGRES_DSN is set.
+func storages(t *testing.T) map[string]link.Interface {
+	res := map[string]link.Interface{
+		"memory": memorylinkrepo.NewMemory(),
+		"sqlite": sqliteStorage(t),
+	}
+	dsn := os.Getenv("LENKE_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Log("LENKE_TEST_POSTGRES_DSN is not set, skipping postgres")
+		return res
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	res["postgres"] = linkrepo.New(conn)
+	return res
+}
+
+func sqliteStorage(t *testing.T) link.Interface {
+	conn, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "lenke.db")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetMaxOpenConns(1)
+	m, err := migrate.New(conn, migrate.Sqlite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return sqlitelinkrepo.New(conn)
+}
+
+// TestCutLinkConcurrent creates links from many goroutines with a tiny id space,
+// so generated ids collide all the time, and checks no link is lost or overwritten.
+func TestCutLinkConcurrent(t *testing.T) {
+	for name, storage := range storages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			gen, err := idgen.NewRandom("ab")
+			if err != nil {
+				t.Fatal(err)
+			}
+			prefix := fmt.Sprintf("t%d-", time.Now().UnixNano())
+			uc := &LinkUseCases{
+				LinkStorage: storage,
+				IdGenerator: prefixed{prefix: prefix, gen: gen},
+				IdLength:    1,
+			}
+
+			var (
+				mu  sync.Mutex
+				ids = make(map[string]string)
+				wg  sync.WaitGroup
+			)
+			errs := make(chan error, stressWorkers*stressLinks)
+			for w := 0; w < stressWorkers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < stressLinks; i++ {
+						target := fmt.Sprintf("http://example.com/%d/%d", w, i)
+						id, err := uc.CutLink(ctx, target, nil, CutLinkOptions{})
+						if err != nil {
+							errs <- err
+							return
+						}
+						mu.Lock()
+						if prev, ok := ids[id]; ok {
+							errs <- fmt.Errorf("id %s is returned for both %s and %s", id, prev, target)
+						}
+						ids[id] = target
+						mu.Unlock()
+					}
+				}(w)
+			}
+			wg.Wait()
+			close(errs)
+			t.Cleanup(func() {
+				for id := range ids {
+					storage.DeleteLink(ctx, id)
+				}
+			})
+			for err := range errs {
+				t.Error(err)
+			}
+
+			if len(ids) != stressWorkers*stressLinks {
+				t.Fatalf("got %d links, want %d", len(ids), stressWorkers*stressLinks)
+			}
+			for id, target := range ids {
+				l, err := storage.GetLinkByLinkId(ctx, id)
+				if err != nil {
+					t.Fatalf("link %s: %v", id, err)
+				}
+				if l.Link != target {
+					t.Errorf("link %s points to %s, want %s", id, l.Link, target)
+				}
+			}
+		})
+	}
+}
+
+// TestCutLinkSameAlias checks that exactly one of concurrent requests gets a custom alias.
+func TestCutLinkSameAlias(t *testing.T) {
+	for name, storage := range storages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			alias := fmt.Sprintf("a%d", time.Now().UnixNano())
+			uc := &LinkUseCases{LinkStorage: storage}
+			t.Cleanup(func() { storage.DeleteLink(ctx, alias) })
+
+			var wg sync.WaitGroup
+			results := make(chan error, stressWorkers)
+			for w := 0; w < stressWorkers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					_, err := uc.CutLink(ctx, fmt.Sprintf("http://example.com/%d", w), nil, CutLinkOptions{Alias: alias})
+					results <- err
+				}(w)
+			}
+			wg.Wait()
+			close(results)
+
+			created := 0
+			for err := range results {
+				switch err {
+				case nil:
+					created++
+				case link.ErrAlreadyExist:
+				default:
+					t.Error(err)
+				}
+			}
+			if created != 1 {
+				t.Fatalf("alias is given out %d times, want 1", created)
+			}
+		})
+	}
+}
+
+// TestUnlockLinkConcurrent checks that concurrent wrong passwords can't make more
+// attempts than the limit, and that the right one doesn't use up attempts.
+func TestUnlockLinkConcurrent(t *testing.T) {
+	ctx := context.Background()
+	uc := &LinkUseCases{LinkStorage: memorylinkrepo.NewMemory()}
+	id, err := uc.CutLink(ctx, "http://example.com", nil, CutLinkOptions{Alias: "locked", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxUnlockAttempts+1; i++ {
+		if _, err := uc.UnlockLink(ctx, id, "secret"); err != nil {
+			t.Fatalf("unlock %d with the right password: %v", i, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan error, stressWorkers)
+	for w := 0; w < stressWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := uc.UnlockLink(ctx, id, "wrong")
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	wrong := 0
+	for err := range results {
+		switch err {
+		case ErrWrongPassword:
+			wrong++
+		case ErrTooManyAttempts:
+		default:
+			t.Error(err)
+		}
+	}
+	if wrong != maxUnlockAttempts {
+		t.Errorf("%d passwords are checked, want %d", wrong, maxUnlockAttempts)
+	}
+	if _, err := uc.UnlockLink(ctx, id, "secret"); err != ErrTooManyAttempts {
+		t.Errorf("unlock after the limit: got %v, want %v", err, ErrTooManyAttempts)
+	}
+}
+
+// TestAttemptLimiterEvictsIdleKeys checks that keys whose attempts left the window don't stay in memory.
+func TestAttemptLimiterEvictsIdleKeys(t *testing.T) {
+	l := &attemptLimiter{}
+	for i := 0; i < 100; i++ {
+		l.Take(fmt.Sprintf("idle%d", i))
+	}
+	past := time.Now().Add(-unlockAttemptsWindow)
+	for key := range l.attempts {
+		l.attempts[key] = []time.Time{past}
+	}
+	l.lastSweep = past
+
+	if !l.Take("active") {
+		t.Fatal("attempt on a new key is refused")
+	}
+	if len(l.attempts) != 1 {
+		t.Errorf("%d keys are kept, want 1", len(l.attempts))
+	}
+}
+
+// TestCutLinkAlias checks the aliases a link can be created with.
+func TestCutLinkAlias(t *testing.T) {
+	tests := []struct {
+		alias string
+		err   error
+	}{
+		{"my-alias_1", nil},
+		{"abc", nil},
+		{"ab", ErrTooShortAlias},
+		{"a234567890123456789012345678901234", ErrTooLongAlias},
+		{"a/b", ErrInvalidAlias},
+		{"ключ", ErrInvalidAlias},
+		{"links", ErrReservedAlias},
+		{"SignIn", ErrReservedAlias},
+	}
+	ctx := context.Background()
+	uc := &LinkUseCases{LinkStorage: memorylinkrepo.NewMemory()}
+	for _, tt := range tests {
+		id, err := uc.CutLink(ctx, "http://example.com", nil, CutLinkOptions{Alias: tt.alias})
+		if err != tt.err {
+			t.Errorf("alias %q: got %v, want %v", tt.alias, err, tt.err)
+			continue
+		}
+		if err == nil && id != tt.alias {
+			t.Errorf("alias %q: link is created as %q", tt.alias, id)
+		}
+	}
+	if _, err := uc.CutLink(ctx, "http://example.org", nil, CutLinkOptions{Alias: "abc"}); err != link.ErrAlreadyExist {
+		t.Errorf("taken alias: got %v, want %v", err, link.ErrAlreadyExist)
+	}
+	// an invalid alias is rejected before the password is looked at
+	tooLong := strings.Repeat("x", maxPasswordLength+1)
+	if _, err := uc.CutLink(ctx, "http://example.com", nil, CutLinkOptions{Alias: "a/b", Password: tooLong}); err != ErrInvalidAlias {
+		t.Errorf("invalid alias with a password: got %v, want %v", err, ErrInvalidAlias)
+	}
+}
+
+// repeated generates an id of one repeated character, so every id of a length collides
+// with the first one.
+type repeated struct {
+	calls map[int]int
+}
+
+func (g repeated) Generate(length int) (string, error) {
+	g.calls[length]++
+	return strings.Repeat("a", length), nil
+}
+
+// TestCutLinkIdLength checks that the id length grows after idRetries collisions, and that
+// no id is found once the longest ids collide too.
+func TestCutLinkIdLength(t *testing.T) {
+	ctx := context.Background()
+	gen := repeated{calls: make(map[int]int)}
+	uc := &LinkUseCases{LinkStorage: memorylinkrepo.NewMemory(), IdGenerator: gen, IdLength: 1}
+	for _, want := range []string{"a", "aa"} {
+		id, err := uc.CutLink(ctx, "http://example.com", nil, CutLinkOptions{})
+		if err != nil || id != want {
+			t.Fatalf("got %q, %v, want %q", id, err, want)
+		}
+	}
+	if gen.calls[1] != 1+idRetries || gen.calls[2] != 1 {
+		t.Errorf("got %v generated ids by length, want %d of 1 and one of 2", gen.calls, 1+idRetries)
+	}
+	if length := uc.currentIdLength(); length != 2 {
+		t.Errorf("id length is %d after the collisions, want 2", length)
+	}
+
+	uc = &LinkUseCases{LinkStorage: memorylinkrepo.NewMemory(), IdGenerator: gen, IdLength: maxIdLength - 1}
+	for _, want := range []error{nil, nil, ErrNoFreeLinkId} {
+		if _, err := uc.CutLink(ctx, "http://example.com", nil, CutLinkOptions{}); err != want {
+			t.Fatalf("got %v, want %v", err, want)
+		}
+	}
+	if length := uc.currentIdLength(); length != maxIdLength {
+		t.Errorf("id length grew to %d, want at most %d", length, maxIdLength)
+	}
+}
+
+// TestGetLinkClickLimit checks that concurrent redirects of a link with a click limit
+// are served exactly as many times as the limit allows.
+func TestGetLinkClickLimit(t *testing.T) {
+	const maxClicks = 5
+	for name, storage := range storages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			alias := fmt.Sprintf("c%d", time.Now().UnixNano())
+			uc := &LinkUseCases{LinkStorage: storage}
+			limit := int64(maxClicks)
+			if _, err := uc.CutLink(ctx, "http://example.com", nil, CutLinkOptions{Alias: alias, MaxClicks: &limit}); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { storage.DeleteLink(ctx, alias) })
+
+			var wg sync.WaitGroup
+			results := make(chan error, stressWorkers)
+			for w := 0; w < stressWorkers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := uc.GetLinkByLinkId(ctx, alias)
+					results <- err
+				}()
+			}
+			wg.Wait()
+			close(results)
+
+			served := 0
+			for err := range results {
+				switch err {
+				case nil:
+					served++
+				case link.ErrExpired:
+				default:
+					t.Error(err)
+				}
+			}
+			if served != maxClicks {
+				t.Errorf("link is served %d times, want %d", served, maxClicks)
+			}
+			l, err := storage.GetLinkByLinkId(ctx, alias)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l.Clicks != maxClicks {
+				t.Errorf("%d clicks are counted, want %d", l.Clicks, maxClicks)
+			}
+		})
+	}
+}
+
+// TestGetLinkExpired checks that a link stops redirecting at its expiration time,
+// also when it's protected by a password.
+func TestGetLinkExpired(t *testing.T) {
+	ctx := context.Background()
+	storage := memorylinkrepo.NewMemory()
+	uc := &LinkUseCases{LinkStorage: storage}
+	past := time.Now().Add(-time.Second)
+	future := time.Now().Add(time.Hour)
+	zero := int64(0)
+
+	if _, err := uc.CutLink(ctx, "http://example.com", nil, CutLinkOptions{Alias: "past", ExpiresAt: &past}); err != ErrExpirationInPast {
+		t.Errorf("expiration in the past: got %v, want %v", err, ErrExpirationInPast)
+	}
+	if _, err := uc.CutLink(ctx, "http://example.com", nil, CutLinkOptions{Alias: "zero", MaxClicks: &zero}); err != ErrInvalidMaxClicks {
+		t.Errorf("zero click limit: got %v, want %v", err, ErrInvalidMaxClicks)
+	}
+	if _, err := uc.CutLink(ctx, "http://example.com", nil, CutLinkOptions{Alias: "future", ExpiresAt: &future}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uc.GetLinkByLinkId(ctx, "future"); err != nil {
+		t.Errorf("link is expired before its time: %v", err)
+	}
+
+	for _, l := range []link.Link{
+		{LinkId: "expired", Link: "http://example.com", ExpiresAt: &past},
+		{LinkId: "locked", Link: "http://example.com", ExpiresAt: &past, Password: "hash"},
+	} {
+		if _, err := storage.StoreLink(ctx, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := uc.GetLinkByLinkId(ctx, "expired"); err != link.ErrExpired {
+		t.Errorf("expired link: got %v, want %v", err, link.ErrExpired)
+	}
+	if _, err := uc.UnlockLink(ctx, "locked", "secret"); err != link.ErrExpired {
+		t.Errorf("expired protected link: got %v, want %v", err, link.ErrExpired)
+	}
+}
+
+// TestUpdateLinkRevisions checks that a changed destination is kept as a revision and can be restored.
+func TestUpdateLinkRevisions(t *testing.T) {
+	ctx := context.Background()
+	storage := memorylinkrepo.NewMemory()
+	uc := &LinkUseCases{LinkStorage: storage}
+	owner := "1"
+	if _, err := uc.CutLink(ctx, "http://a.example", &owner, CutLinkOptions{Alias: "edited"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.UpdateLinkStatusByLinkId(ctx, "edited", status.OK); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{"http://b.example", "B.example:80", "http://c.example"} {
+		if _, err := uc.UpdateLink(ctx, "edited", owner, LinkUpdate{Link: &target}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	revisions, err := uc.GetLinkRevisions(ctx, "edited", owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second change normalizes to the same destination and isn't a revision
+	if len(revisions) != 2 || revisions[0].Link != "http://a.example" || revisions[1].Link != "http://b.example" {
+		t.Fatalf("got revisions %+v", revisions)
+	}
+
+	l, err := uc.RollbackLink(ctx, "edited", owner, revisions[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Link != "http://a.example" || l.LinkStatus != status.Unknown {
+		t.Errorf("rolled back to %s with status %v", l.Link, l.LinkStatus)
+	}
+	if target, err := uc.GetLinkByLinkId(ctx, "edited"); err != nil || target != "http://a.example" {
+		t.Errorf("redirects to %q, %v after the rollback", target, err)
+	}
+	if revisions, _ := uc.GetLinkRevisions(ctx, "edited", owner); len(revisions) != 3 || revisions[2].Link != "http://c.example" {
+		t.Errorf("destination replaced by the rollback isn't kept: %+v", revisions)
+	}
+
+	if _, err := uc.RollbackLink(ctx, "edited", owner, -1); err != ErrRevisionNotFound {
+		t.Errorf("unknown revision: got %v, want %v", err, ErrRevisionNotFound)
+	}
+	if _, err := uc.RollbackLink(ctx, "edited", "2", revisions[0].Id); err != link.ErrAccessDenied {
+		t.Errorf("rollback by another account: got %v, want %v", err, link.ErrAccessDenied)
+	}
+}
+
+// TestCutLinkReuse checks that a plain link to the same normalized destination is given out again on request.
+func TestCutLinkReuse(t *testing.T) {
+	for name, storage := range storages(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			gen, err := idgen.NewRandom(idgen.Base62)
+			if err != nil {
+				t.Fatal(err)
+			}
+			uc := &LinkUseCases{LinkStorage: storage, IdGenerator: gen}
+			path := fmt.Sprintf("/%d", time.Now().UnixNano())
+			target := "http://example.com" + path
+			limit := int64(10)
+
+			var ids []string
+			cut := func(target string, opts CutLinkOptions) string {
+				id, err := uc.CutLink(ctx, target, nil, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, id)
+				return id
+			}
+			t.Cleanup(func() {
+				for _, id := range ids {
+					storage.DeleteLink(ctx, id)
+				}
+			})
+
+			limited := cut(target, CutLinkOptions{MaxClicks: &limit})
+			first := cut(target, CutLinkOptions{Reuse: true})
+			if first == limited {
+				t.Fatal("link with a click limit is reused")
+			}
+			if again := cut("EXAMPLE.com:80"+path, CutLinkOptions{Reuse: true}); again != first {
+				t.Errorf("got %s for the same destination, want %s", again, first)
+			}
+			if fresh := cut(target, CutLinkOptions{}); fresh == first {
+				t.Error("link is reused without a request")
+			}
+			if again := cut(target, CutLinkOptions{Reuse: true}); again != first {
+				t.Errorf("got %s, want the oldest link %s", again, first)
+			}
+		})
+	}
+}
+
+// TestCutLinkReuseOwner checks that links are reused only for their owner.
+func TestCutLinkReuseOwner(t *testing.T) {
+	ctx := context.Background()
+	gen, err := idgen.NewRandom(idgen.Base62)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uc := &LinkUseCases{LinkStorage: memorylinkrepo.NewMemory(), IdGenerator: gen}
+	alice, bob := "1", "2"
+	owned := make(map[string]*string)
+	for _, owner := range []*string{nil, &alice, &bob} {
+		id, err := uc.CutLink(ctx, "http://example.com", owner, CutLinkOptions{Reuse: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := owned[id]; ok {
+			t.Fatalf("link %s is given out to two owners", id)
+		}
+		owned[id] = owner
+	}
+	if id, err := uc.CutLink(ctx, "http://example.com", &alice, CutLinkOptions{Reuse: true}); err != nil || owned[id] != &alice {
+		t.Errorf("got %s, %v, want the link of the same owner", id, err)
+	}
+}
@@ -0,0 +1,116 @@
+package link
+
+import (
+	"errors"
+	"golang.org/x/net/idna"
+	"net"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrEmptyUrl             = errors.New("link is empty")
+	ErrTooLongUrl           = errors.New("too long link")
+	ErrInvalidUrl           = errors.New("link is not a valid url")
+	ErrUnsupportedUrlScheme = errors.New("only http and https links are allowed")
+	ErrMissingUrlHost       = errors.New("link has no host")
+	ErrInvalidUrlHost       = errors.New("link host is not valid")
+)
+
+const (
+	maxUrlLength  = 2048
+	defaultScheme = "http"
+)
+
+// defaultPorts are dropped from normalized urls since they don't change the destination.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// normalizeUrl validates a submitted destination and returns its canonical form:
+// scheme is added if missing and lowercased, host is lowercased and converted
+// to punycode, default port is removed.
+func normalizeUrl(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", ErrEmptyUrl
+	}
+	if len(raw) > maxUrlLength {
+		return "", ErrTooLongUrl
+	}
+	if !hasScheme(raw) {
+		raw = defaultScheme + "://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", ErrInvalidUrl
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if _, ok := defaultPorts[u.Scheme]; !ok {
+		return "", ErrUnsupportedUrlScheme
+	}
+	if u.Opaque != "" {
+		return "", ErrInvalidUrl
+	}
+
+	host, port := u.Hostname(), u.Port()
+	if host == "" {
+		return "", ErrMissingUrlHost
+	}
+	if host, err = normalizeHost(host); err != nil {
+		return "", err
+	}
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	res := u.String()
+	if len(res) > maxUrlLength {
+		return "", ErrTooLongUrl
+	}
+	return res, nil
+}
+
+// hasScheme reports whether raw starts with a scheme, so "helpme.com" gets
+// the default one while "javascript:..." and "data:..." are rejected as is.
+func hasScheme(raw string) bool {
+	i := strings.Index(raw, ":")
+	if i <= 0 {
+		return false
+	}
+	for j, r := range raw[:i] {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case j > 0 && ('0' <= r && r <= '9' || r == '+' || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	// "localhost:8080/path" is a host with a port rather than a scheme
+	rest := raw[i+1:]
+	if !strings.HasPrefix(rest, "//") && len(rest) > 0 && '0' <= rest[0] && rest[0] <= '9' {
+		return false
+	}
+	return true
+}
+
+func normalizeHost(host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	host = strings.TrimSuffix(host, ".")
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil || ascii == "" {
+		return "", ErrInvalidUrlHost
+	}
+	return strings.ToLower(ascii), nil
+}
@@ -0,0 +1,44 @@
+package link
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestNormalizeUrl checks the canonical form of accepted links and the errors of rejected ones.
+func TestNormalizeUrl(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		err  error
+	}{
+		{"https://example.com/a?b=c#d", "https://example.com/a?b=c#d", nil},
+		{"  example.com/path  ", "http://example.com/path", nil},
+		{"HTTPS://Example.COM./Path", "https://example.com/Path", nil},
+		{"http://example.com:80/", "http://example.com/", nil},
+		{"https://example.com:443", "https://example.com", nil},
+		{"https://example.com:8443", "https://example.com:8443", nil},
+		{"localhost:8080/path", "http://localhost:8080/path", nil},
+		{"http://пример.рф", "http://xn--e1afmkfd.xn--p1ai", nil},
+		{"http://[::1]:80/", "http://[::1]/", nil},
+		{"http://127.0.0.1:8080", "http://127.0.0.1:8080", nil},
+
+		{"", "", ErrEmptyUrl},
+		{"   ", "", ErrEmptyUrl},
+		{"https://example.com/" + strings.Repeat("a", maxUrlLength), "", ErrTooLongUrl},
+		{"javascript:alert(1)", "", ErrUnsupportedUrlScheme},
+		{"data:text/html,hi", "", ErrUnsupportedUrlScheme},
+		{"ftp://example.com", "", ErrUnsupportedUrlScheme},
+		{"http:example.com", "", ErrInvalidUrl},
+		{"http://", "", ErrMissingUrlHost},
+		{"http://%zz", "", ErrInvalidUrl},
+		{"http://exa mple.com", "", ErrInvalidUrl},
+		{"http://-example-.com", "", ErrInvalidUrlHost},
+	}
+	for _, tt := range tests {
+		got, err := normalizeUrl(tt.raw)
+		if err != tt.err || got != tt.want {
+			t.Errorf("normalizeUrl(%q) = %q, %v, want %q, %v", tt.raw, got, err, tt.want, tt.err)
+		}
+	}
+}